@@ -2,144 +2,261 @@ package main
 
 import (
 	"context"
-	"log"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-
+	"github.com/seunghoon34/linkapp/backend/internal/config"
 	"github.com/seunghoon34/linkapp/backend/internal/handler"
-	"github.com/seunghoon34/linkapp/backend/internal/repository"
+	"github.com/seunghoon34/linkapp/backend/internal/jobs"
+	"github.com/seunghoon34/linkapp/backend/internal/logging"
+	"github.com/seunghoon34/linkapp/backend/internal/mail"
+	"github.com/seunghoon34/linkapp/backend/internal/metrics"
+	"github.com/seunghoon34/linkapp/backend/internal/model"
 	"github.com/seunghoon34/linkapp/backend/internal/service"
+	"github.com/seunghoon34/linkapp/backend/internal/tracing"
 
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 )
 
-func loadEnv() {
-	currentDir, err := os.Getwd()
+func main() {
+	// A missing .env is fine; the variables may come from the environment
+	_ = godotenv.Load()
+
+	loader := config.NewLoader(flag.CommandLine)
+	printConfig := flag.Bool("print-config", false, "print the effective configuration, secrets redacted, and exit")
+	flag.Parse()
+
+	cfg, err := loader.Load()
+	if *printConfig && cfg != nil {
+		cfg.Print(os.Stdout)
+	}
 	if err != nil {
-		log.Println("Error getting current directory:", err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *printConfig {
 		return
 	}
-	log.Println("Current directory:", currentDir)
 
-	// The .env file is in the current directory (backend)
-	backendDir := currentDir
-	log.Println("Backend directory:", backendDir)
+	slog.SetDefault(logging.New(os.Stdout, cfg.LogLevel))
 
-	envPath := filepath.Join(backendDir, ".env")
-	log.Println("Env file path:", envPath)
-
-	err = godotenv.Load(envPath)
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
 	if err != nil {
-		log.Println("Error loading .env file:", err)
+		fatal("setting up tracing failed", err)
 	}
-}
 
-func runLinkExpirationTask(userService *service.UserService) {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+	store := openStores(cfg)
+	metrics.RegisterSearchers(func(ctx context.Context) (int64, error) {
+		return store.users.CountByState(ctx, model.UserStateSearching)
+	})
 
-	for {
-		select {
-		case <-ticker.C:
-			if err := userService.ExpireLinks(); err != nil {
-				log.Printf("Error expiring links: %v", err)
-			}
-		}
-	}
-}
+	// Initialize services
+	userService := service.NewUserService(store.users, store.links, store.chatrooms)
+	userService.SetSearchRadius(cfg.SearchRadius)
+	userService.SetLinkTTL(cfg.LinkTTL)
+	userService.SetLocationSharingLimit(cfg.LocationSharingLimit)
+	userService.SetLocationStaleAfter(cfg.LocationStaleAfter)
+	userService.SetLockedChatroomTTL(cfg.LockedChatroomTTL)
+	userService.SetUnlockPolicy(cfg.Unlock)
+	userService.SetLoginPolicy(cfg.Login)
+	userService.SetLoginProtection(store.loginAttempts, store.securityEvents)
+	userService.SetLocationEvents(store.locationEvents)
 
-func main() {
-	loadEnv()
-	// Load environment variables
-	mongoURI := os.Getenv("MONGO_URI")
-	if mongoURI == "" {
-		log.Fatal("MONGO_URI environment variable is not set")
+	// QR unlock tokens are signed with a per-process secret unless one is
+	// configured, which every replica needs to share
+	if cfg.UnlockTokenSecret != "" {
+		userService.SetUnlockTokenSecret([]byte(cfg.UnlockTokenSecret))
 	}
 
-	// Set up MongoDB connection
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	mailer, closeMailer, err := mail.Open(cfg.Mail)
 	if err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
+		fatal("setting up mail failed", err)
 	}
-	defer func() {
-		if err = client.Disconnect(ctx); err != nil {
-			log.Fatalf("Failed to disconnect from MongoDB: %v", err)
-		}
-	}()
-
-	// Ping the database to verify connection
-	err = client.Ping(ctx, nil)
-	if err != nil {
-		log.Fatalf("Failed to ping MongoDB: %v", err)
+	if cfg.Mail.Backend == mail.BackendLog {
+		slog.Warn("emails are logged, not sent; set mail-backend to send them")
 	}
+	userService.SetMailer(mailer, cfg.AppURL)
+	userService.SetAccountTokenPolicy(cfg.AccountTokens)
 
-	log.Println("Connected to MongoDB successfully")
-
-	// Initialize database and collections
-	database := client.Database("dating_app")
-	// Remove the following line:
-	// userCollection := database.Collection("users")
+	// Emailed links outlive the process, so without a configured secret
+	// they break on restart and on every other replica
+	if cfg.AccountTokenSecret != "" {
+		userService.SetAccountTokenSecret([]byte(cfg.AccountTokenSecret))
+	} else {
+		slog.Warn("account-token-secret isn't set; emailed links only work on this instance until it restarts")
+	}
 
-	// Initialize repositories
-	userRepo := repository.NewUserRepository(database)
-	linkRepo := repository.NewLinkRepository(database)
-	chatroomRepo := repository.NewChatroomRepository(database)
+	// Identifies this instance when it holds a lease
+	hostname, _ := os.Hostname()
+	instanceID := fmt.Sprintf("%s-%d", hostname, os.Getpid())
 
-	// Initialize services
-	userService := service.NewUserService(userRepo, linkRepo, chatroomRepo)
+	// Start background jobs
+	jobRunner := jobs.NewRunner(store.leases, store.jobRuns, instanceID)
+	jobRunner.Register(jobs.Job{
+		// Re-arms timers for pending links, so links whose creating instance
+		// went away still expire on time, and releases users stuck on links
+		// that are over
+		Name:     "link-expiry",
+		Interval: cfg.LinkExpiryInterval,
+		Jitter:   time.Second,
+		Run: func(ctx context.Context) error {
+			return userService.RecoverLinkExpiry(ctx)
+		},
+	})
+	jobRunner.Register(jobs.Job{
+		Name:     "chatroom-expiration",
+		Interval: time.Hour,
+		Jitter:   time.Minute,
+		Run: func(ctx context.Context) error {
+			return userService.ExpireChatrooms(ctx)
+		},
+	})
+	jobRunner.Register(jobs.Job{
+		Name:     "location-retention",
+		Interval: time.Hour,
+		Jitter:   time.Minute,
+		Run: func(ctx context.Context) error {
+			return userService.PurgeStaleLocations(ctx)
+		},
+	})
+	jobRunner.Register(jobs.Job{
+		Name:     "stale-search",
+		Interval: time.Minute,
+		Jitter:   5 * time.Second,
+		Run: func(ctx context.Context) error {
+			return userService.StopStaleSearches(ctx)
+		},
+	})
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	jobRunner.Start(jobsCtx)
+	userService.StartLocationFeed(jobsCtx)
 
-	// Start link expiration goroutine
-	go runLinkExpirationTask(userService)
+	rateLimits, closeRateLimits := openRateLimits(cfg.RateLimit, store, cfg.Timeouts)
+	limiter := handler.NewRateLimiter(rateLimits)
 
 	// Initialize handlers
 	userHandler := handler.NewUserHandler(userService)
+	adminHandler := handler.NewAdminHandler(jobRunner)
+	healthHandler := handler.NewHealthHandler(store.ping, jobRunner)
 
 	// Set up router
 	r := mux.NewRouter()
 
 	// Set up routes
-	r.HandleFunc("/users", userHandler.CreateUser).Methods("POST")
+	r.Handle("/users", limiter.Limit("signup", cfg.RateLimit.Signup, userHandler.CreateUser)).Methods("POST")
 	r.HandleFunc("/users/{id}", userHandler.GetUser).Methods("GET")
 	r.HandleFunc("/users/{id}", userHandler.UpdateUser).Methods("PUT")
-	r.HandleFunc("/login", userHandler.Login).Methods("POST")
+	r.Handle("/login", limiter.Limit("login", cfg.RateLimit.Login, userHandler.Login)).Methods("POST")
+	r.Handle("/users/{id}/email-verification", limiter.Limit("email-verification", cfg.RateLimit.EmailVerification, userHandler.RequestEmailVerification)).Methods("POST")
+	r.HandleFunc("/email-verification/confirm", userHandler.VerifyEmail).Methods("POST")
+	r.Handle("/password-reset", limiter.Limit("password-reset", cfg.RateLimit.PasswordReset, userHandler.RequestPasswordReset)).Methods("POST")
+	r.HandleFunc("/password-reset/confirm", userHandler.ResetPassword).Methods("POST")
 	r.HandleFunc("/users/{id}/matches", userHandler.SearchMatches).Methods("GET")
 	r.HandleFunc("/users/{id}/location", userHandler.UpdateLocation).Methods("PUT")
+	r.HandleFunc("/users/{id}/location/history", userHandler.GetLocationHistory).Methods("GET")
 	r.HandleFunc("/users/{id}/start-searching", userHandler.StartSearching).Methods("POST")
 	r.HandleFunc("/users/{id}/stop-searching", userHandler.StopSearching).Methods("POST")
-	r.HandleFunc("/users/{id}/find-match", userHandler.FindMatch).Methods("GET")
+	r.Handle("/users/{id}/find-match", limiter.Limit("find-match", cfg.RateLimit.FindMatch, userHandler.FindMatch)).Methods("GET")
 	r.HandleFunc("/users/{userId}/links/{linkId}/respond", userHandler.RespondToLink).Methods("POST")
-	r.HandleFunc("/users/{userId}/chatrooms/{chatroomId}/messages", userHandler.SendMessage).Methods("POST")
+	r.HandleFunc("/users/{userId}/links/{linkId}/preview", userHandler.GetLinkPreview).Methods("GET")
+	r.Handle("/users/{userId}/chatrooms/{chatroomId}/messages", limiter.Limit("send-message", cfg.RateLimit.SendMessage, userHandler.SendMessage)).Methods("POST")
 	r.HandleFunc("/users/{userId}/chatrooms/{chatroomId}/messages", userHandler.GetMessages).Methods("GET")
-	r.HandleFunc("/chatrooms/{chatroomId}/unlock", userHandler.UnlockChatroom).Methods("POST")
+	r.HandleFunc("/users/{userId}/chatrooms/{chatroomId}/unmatch", userHandler.Unmatch).Methods("POST")
 	r.HandleFunc("/users/{userId}/chatrooms/{chatroomId}/nfc-unlock", userHandler.VerifyNFCAndUnlockChatroom).Methods("POST")
+	r.HandleFunc("/users/{userId}/chatrooms/{chatroomId}/qr-unlock/token", userHandler.IssueUnlockToken).Methods("POST")
+	r.HandleFunc("/users/{userId}/chatrooms/{chatroomId}/qr-unlock", userHandler.RedeemUnlockToken).Methods("POST")
+	r.HandleFunc("/users/{userId}/chatrooms/{chatroomId}/location", userHandler.ShareChatroomLocation).Methods("PUT")
+	r.HandleFunc("/users/{userId}/chatrooms/{chatroomId}/location", userHandler.GetPeerLocation).Methods("GET")
+	r.HandleFunc("/users/{userId}/chatrooms/{chatroomId}/location/stream", userHandler.StreamPeerLocation).Methods("GET")
+	r.HandleFunc("/users/{userId}/chatrooms/{chatroomId}/location/pause", userHandler.PauseLocationSharing).Methods("POST")
+	r.HandleFunc("/users/{userId}/chatrooms/{chatroomId}/location/resume", userHandler.ResumeLocationSharing).Methods("POST")
+
+	// Job status names instances and shows their errors, and metrics give
+	// away traffic, so both need the admin token
+	if cfg.AdminToken == "" {
+		slog.Warn("admin-token isn't set; /admin routes and /metrics are off")
+	}
+	r.Handle("/admin/jobs", handler.RequireAdminToken(cfg.AdminToken, http.HandlerFunc(adminHandler.JobStatus))).Methods("GET")
 
-	// Add middleware
-	r.Use(loggingMiddleware)
+	r.HandleFunc("/healthz", healthHandler.Live).Methods("GET")
+	r.HandleFunc("/readyz", healthHandler.Ready).Methods("GET")
+	r.HandleFunc("/version", healthHandler.Version).Methods("GET")
+	r.Handle("/metrics", handler.RequireAdminToken(cfg.AdminToken, promhttp.Handler())).Methods("GET")
+
+	// Add middleware. Probes and scrapes aren't traced; they'd drown out
+	// everything else.
+	r.Use(otelmux.Middleware(tracing.ServiceName, otelmux.WithFilter(func(r *http.Request) bool {
+		switch r.URL.Path {
+		case "/healthz", "/readyz", "/metrics":
+			return false
+		}
+		return true
+	})))
+	middleware := []mux.MiddlewareFunc{
+		handler.RequestIDMiddleware,
+		handler.ClientIPMiddleware(cfg.ProxyHops),
+		handler.LoggingMiddleware,
+		handler.MetricsMiddleware,
+	}
+	r.Use(middleware...)
+
+	// Unmatched routes skip the router's middleware, so they get it
+	// themselves
+	r.NotFoundHandler = chain(http.HandlerFunc(handler.NotFound), middleware)
+	r.MethodNotAllowedHandler = chain(http.HandlerFunc(handler.MethodNotAllowed), middleware)
 
 	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	server := &http.Server{Addr: ":" + strconv.Itoa(cfg.Port), Handler: r}
+	// Location streams only end when their client leaves, so end them as
+	// soon as shutdown starts instead of waiting them out
+	server.RegisterOnShutdown(userService.CloseLocationStreams)
+
+	err = serve(server, healthHandler.Drain, cfg.ShutdownDelay, cfg.ShutdownTimeout)
+	if err != nil {
+		slog.Error("server failed", "error", err)
+	}
+
+	// With requests done, stop the background work before closing the
+	// database and mailer it uses
+	stopBackground(stopJobs, jobRunner, userService)
+	closeRateLimits()
+	if err := closeMailer(); err != nil {
+		slog.Error("closing the mailer failed", "error", err)
+	}
+	store.close()
+
+	// Send off the last spans
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("flushing traces failed", "error", err)
 	}
+	cancel()
 
-	log.Printf("Server starting on port %s", port)
-	if err := http.ListenAndServe(":"+port, r); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	if err != nil {
+		os.Exit(1)
 	}
+	slog.Info("server stopped")
 }
 
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("%s %s %s", r.RemoteAddr, r.Method, r.URL)
-		next.ServeHTTP(w, r)
-	})
+// chain wraps h in middleware, the first outermost, the way the router
+// applies it.
+func chain(h http.Handler, middleware []mux.MiddlewareFunc) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// fatal logs why the API can't start and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/jobs"
+	"github.com/seunghoon34/linkapp/backend/internal/service"
+)
+
+// serve runs the server until it fails or the process gets SIGINT or
+// SIGTERM. It then calls drain and keeps serving for delay, so load
+// balancers see readiness fail and stop sending traffic, before it stops
+// taking connections and gives in-flight requests up to timeout to finish.
+func serve(server *http.Server, drain func(), delay, timeout time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("server starting", "addr", server.Addr)
+		errc <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	// A second signal kills the process the usual way
+	stop()
+
+	drain()
+	if delay > 0 {
+		slog.Info("draining before shutdown", "delay", delay.String())
+		select {
+		case <-time.After(delay):
+		case err := <-errc:
+			return err
+		}
+	}
+
+	slog.Info("shutting down, waiting for requests to finish", "timeout", timeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		server.Close()
+		return err
+	}
+	return nil
+}
+
+// stopBackground stops the work that outlives requests, once the server has
+// stopped taking them: the jobs, the location feed, the link expiry timers
+// and any emails still being sent. Nothing uses the stores or the mailer
+// after it returns.
+func stopBackground(stopJobs context.CancelFunc, jobRunner *jobs.Runner, userService *service.UserService) {
+	stopJobs()
+	jobRunner.Wait()
+	userService.WaitForLocationFeed()
+	userService.StopLinkExpiry()
+	userService.WaitForMail()
+}
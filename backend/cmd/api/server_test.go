@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/goleak"
+
+	"github.com/seunghoon34/linkapp/backend/internal/handler"
+	"github.com/seunghoon34/linkapp/backend/internal/jobs"
+	"github.com/seunghoon34/linkapp/backend/internal/mail"
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository/memory"
+	"github.com/seunghoon34/linkapp/backend/internal/service"
+)
+
+// slowMailer takes a while over each message, like a real mail server.
+type slowMailer struct {
+	mu   sync.Mutex
+	sent []mail.Message
+}
+
+func (m *slowMailer) Send(ctx context.Context, msg mail.Message) error {
+	time.Sleep(200 * time.Millisecond)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *slowMailer) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sent)
+}
+
+// leases hands every lease to whoever asks; there's only one instance.
+type leases struct{}
+
+func (leases) Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	return true, nil
+}
+
+func (leases) Get(ctx context.Context, name string) (*model.Lease, error) {
+	return nil, nil
+}
+
+func (leases) Release(ctx context.Context, name, holder string) error {
+	return nil
+}
+
+type jobRuns struct{}
+
+func (jobRuns) Record(ctx context.Context, run *model.JobRun) error {
+	return nil
+}
+
+func (jobRuns) Recent(ctx context.Context, job string, limit int) ([]*model.JobRun, error) {
+	return nil, nil
+}
+
+// freeAddr finds a local address nothing is listening on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// TestShutdownLeavesNoGoroutines runs the server, the job runner and link
+// expiry timers with a location stream open and an email on its way, then
+// shuts down the way main does and checks everything has stopped.
+func TestShutdownLeavesNoGoroutines(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	ctx := context.Background()
+	users := memory.NewUserRepository()
+	links := memory.NewLinkRepository()
+	chatrooms := memory.NewChatroomRepository()
+
+	mailer := &slowMailer{}
+	userService := service.NewUserService(users, links, chatrooms)
+	userService.SetMailer(mailer, service.DefaultAppURL)
+
+	// One link expires while the server runs and one is still pending when
+	// it stops
+	userAID, userBID := primitive.NewObjectID(), primitive.NewObjectID()
+	if _, err := links.CreateLink(ctx, userAID, userBID, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := links.CreateLink(ctx, userAID, userBID, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	chatroom, err := chatrooms.CreateChatroom(ctx, primitive.NewObjectID(), userAID, userBID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobRunner := jobs.NewRunner(leases{}, jobRuns{}, "test")
+	jobRunner.Register(jobs.Job{
+		Name:     "link-expiry",
+		Interval: 10 * time.Millisecond,
+		Run:      userService.RecoverLinkExpiry,
+	})
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	jobRunner.Start(jobsCtx)
+
+	userHandler := handler.NewUserHandler(userService)
+	r := mux.NewRouter()
+	r.HandleFunc("/users", userHandler.CreateUser).Methods("POST")
+	r.HandleFunc("/users/{userId}/chatrooms/{chatroomId}/location/stream", userHandler.StreamPeerLocation).Methods("GET")
+
+	addr := freeAddr(t)
+	server := &http.Server{Addr: addr, Handler: r}
+	server.RegisterOnShutdown(userService.CloseLocationStreams)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(server, func() {}, 0, 5*time.Second)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	base := "http://" + addr
+
+	// Signing up sends the verification email in the background
+	var signedUp bool
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		body := strings.NewReader(`{"username":"someone","email":"someone@example.com","password":"correct horse battery"}`)
+		resp, err := client.Post(base+"/users", "application/json", body)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("signing up returned %d", resp.StatusCode)
+		}
+		signedUp = true
+		break
+	}
+	if !signedUp {
+		t.Fatal("server never came up")
+	}
+
+	resp, err := client.Get(fmt.Sprintf("%s/users/%s/chatrooms/%s/location/stream", base, userAID.Hex(), chatroom.ID.Hex()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("opening the location stream returned %d", resp.StatusCode)
+	}
+	streamEnded := make(chan struct{})
+	go func() {
+		defer close(streamEnded)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+		}
+	}()
+
+	// Let the short link expire
+	time.Sleep(100 * time.Millisecond)
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("serve returned %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("server didn't stop")
+	}
+	stopBackground(stopJobs, jobRunner, userService)
+
+	select {
+	case <-streamEnded:
+	case <-time.After(time.Second):
+		t.Fatal("location stream wasn't closed")
+	}
+
+	if n := mailer.count(); n != 1 {
+		t.Errorf("sent %d emails, want the verification email", n)
+	}
+
+	pending, err := links.GetPendingLinks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 {
+		t.Errorf("%d links pending, want only the long one", len(pending))
+	}
+
+	client.CloseIdleConnections()
+}
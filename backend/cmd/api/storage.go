@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+
+	"github.com/seunghoon34/linkapp/backend/db/migrations"
+	"github.com/seunghoon34/linkapp/backend/internal/config"
+	"github.com/seunghoon34/linkapp/backend/internal/migrate"
+	"github.com/seunghoon34/linkapp/backend/internal/ratelimit"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+	"github.com/seunghoon34/linkapp/backend/internal/repository/instrumented"
+	"github.com/seunghoon34/linkapp/backend/internal/repository/postgres"
+	"github.com/seunghoon34/linkapp/backend/pkg/db"
+)
+
+// stores is everything the API keeps in its database, whichever backend
+// that is.
+type stores struct {
+	users     repository.UserStore
+	links     repository.LinkStore
+	chatrooms repository.ChatroomStore
+	leases    repository.LeaseStore
+	jobRuns   repository.JobRunStore
+
+	loginAttempts  repository.LoginAttemptStore
+	securityEvents repository.SecurityEventStore
+
+	// locationEvents carries live location updates between instances
+	locationEvents repository.LocationEventStore
+
+	// migrations is the schema the stores expect
+	migrations migrate.Target
+
+	// mongo is the database when the backend is MongoDB
+	mongo *mongo.Database
+	ping  func(ctx context.Context) error
+	close func()
+}
+
+// openStores connects to the configured backend. Every storage call is
+// bounded by the configured timeouts and timed for the metrics.
+func openStores(cfg *config.Config) *stores {
+	var s *stores
+	if cfg.StorageBackend == "postgres" {
+		s = openPostgres(cfg.Postgres, cfg.Timeouts)
+	} else {
+		s = openMongo(cfg.MongoURI, cfg.MongoDatabase, cfg.Timeouts)
+	}
+
+	// Refuse to run against a schema older than this build's
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := migrate.CheckApplied(ctx, s.migrations); err != nil {
+		fatal("checking schema migrations failed", err)
+	}
+
+	s.users = instrumented.NewUsers(s.users)
+	s.links = instrumented.NewLinks(s.links)
+	s.chatrooms = instrumented.NewChatrooms(s.chatrooms)
+	s.leases = instrumented.NewLeases(s.leases)
+	s.jobRuns = instrumented.NewJobRuns(s.jobRuns)
+	s.loginAttempts = instrumented.NewLoginAttempts(s.loginAttempts)
+	s.securityEvents = instrumented.NewSecurityEvents(s.securityEvents)
+	s.locationEvents = instrumented.NewLocationEvents(s.locationEvents)
+	return s
+}
+
+func openMongo(mongoURI, databaseName string, timeouts repository.Timeouts) *stores {
+	// Set up MongoDB connection
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Commands are traced without their bodies, which hold users' details
+	monitor := otelmongo.NewMonitor(otelmongo.WithCommandAttributeDisabled(true))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI).SetMonitor(monitor))
+	if err != nil {
+		fatal("connecting to MongoDB failed", err)
+	}
+
+	// Ping the database to verify connection
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		fatal("pinging MongoDB failed", err)
+	}
+
+	// Users are moved in pairs inside a transaction, which would fail on
+	// every match rather than here
+	if err := repository.CheckTransactions(ctx, client); err != nil {
+		fatal("checking the MongoDB deployment failed", err)
+	}
+
+	slog.Info("connected to MongoDB")
+
+	database := client.Database(databaseName)
+
+	return &stores{
+		migrations: migrate.NewMongo(database),
+
+		mongo:     database,
+		users:     repository.NewUserRepository(database, timeouts),
+		links:     repository.NewLinkRepository(database, timeouts),
+		chatrooms: repository.NewChatroomRepository(database, timeouts),
+		leases:    repository.NewLeaseRepository(database, timeouts),
+		jobRuns:   repository.NewJobRunRepository(database, timeouts),
+
+		loginAttempts:  repository.NewLoginAttemptRepository(database, timeouts),
+		securityEvents: repository.NewSecurityEventRepository(database, timeouts),
+		locationEvents: repository.NewLocationEventRepository(database, timeouts),
+
+		ping: func(ctx context.Context) error {
+			return client.Ping(ctx, nil)
+		},
+		close: func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			if err := client.Disconnect(ctx); err != nil {
+				slog.Error("disconnecting from MongoDB failed", "error", err)
+			}
+		},
+	}
+}
+
+// openRateLimits opens the configured rate limit store. The returned
+// function releases its connection, if it has one.
+func openRateLimits(cfg config.RateLimit, store *stores, timeouts repository.Timeouts) (ratelimit.Store, func()) {
+	switch cfg.Backend {
+	case "mongo":
+		return ratelimit.NewMongoStore(store.mongo, timeouts.Query), func() {}
+	case "redis":
+		opts, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			fatal("parsing the Redis URL failed", err)
+		}
+		client := redis.NewClient(opts)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := client.Ping(ctx).Err(); err != nil {
+			fatal("pinging Redis failed", err)
+		}
+		slog.Info("connected to Redis")
+
+		return ratelimit.NewRedisStore(client, "linkapp:ratelimit:"), func() {
+			if err := client.Close(); err != nil {
+				slog.Error("closing Redis connection failed", "error", err)
+			}
+		}
+	default:
+		return ratelimit.NewMemoryStore(), func() {}
+	}
+}
+
+// openPostgres connects to Postgres. The schema comes from db/migrations and
+// is applied with cmd/migrate; openStores checks it's up to date.
+func openPostgres(pg config.Postgres, timeouts repository.Timeouts) *stores {
+	conn, err := db.NewPostgresConnection(pg.Host, strconv.Itoa(pg.Port), pg.User, pg.Password, pg.Database)
+	if err != nil {
+		fatal("connecting to Postgres failed", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := conn.PingContext(ctx); err != nil {
+		fatal("pinging Postgres failed", err)
+	}
+
+	slog.Info("connected to Postgres")
+
+	schema, err := migrate.NewPostgres(conn, migrations.FS)
+	if err != nil {
+		fatal("reading migrations failed", err)
+	}
+
+	return &stores{
+		migrations: schema,
+
+		users:     postgres.NewUserRepository(conn, timeouts),
+		links:     postgres.NewLinkRepository(conn, timeouts),
+		chatrooms: postgres.NewChatroomRepository(conn, timeouts),
+		leases:    postgres.NewLeaseRepository(conn, timeouts),
+		jobRuns:   postgres.NewJobRunRepository(conn, timeouts),
+
+		loginAttempts:  postgres.NewLoginAttemptRepository(conn, timeouts),
+		securityEvents: postgres.NewSecurityEventRepository(conn, timeouts),
+		// LISTEN needs a connection of its own, outside the pool
+		locationEvents: postgres.NewLocationEventRepository(conn,
+			db.PostgresConnString(pg.Host, strconv.Itoa(pg.Port), pg.User, pg.Password, pg.Database), timeouts),
+
+		ping: conn.PingContext,
+		close: func() {
+			if err := conn.Close(); err != nil {
+				slog.Error("closing Postgres connection failed", "error", err)
+			}
+		},
+	}
+}
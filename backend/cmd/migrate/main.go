@@ -0,0 +1,125 @@
+// Command migrate applies the database migrations for the configured storage
+// backend.
+//
+//	migrate [-backend mongo|postgres] [-steps n] [-dry-run] up|down|status
+//
+// up applies every pending migration, or the first n with -steps. down
+// reverts the latest migration, or the latest n. -dry-run prints what would
+// run without touching the schema. The connection comes from the same
+// configuration as the API, so its config file, environment variables and
+// flags all work here too.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/seunghoon34/linkapp/backend/db/migrations"
+	"github.com/seunghoon34/linkapp/backend/internal/config"
+	"github.com/seunghoon34/linkapp/backend/internal/migrate"
+	"github.com/seunghoon34/linkapp/backend/pkg/db"
+)
+
+func main() {
+	// A missing .env is fine; the variables may come from the environment
+	_ = godotenv.Load()
+
+	loader := config.NewLoader(flag.CommandLine)
+	backend := flag.String("backend", "", "storage backend to migrate, overriding -storage-backend")
+	steps := flag.Int("steps", 0, "number of migrations to apply or revert")
+	dryRun := flag.Bool("dry-run", false, "print the migrations that would run without running them")
+	timeout := flag.Duration("timeout", 5*time.Minute, "give up after this long")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] up|down|status\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *backend != "" {
+		flag.Set("storage-backend", *backend)
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	target, closeTarget := openTarget(ctx, cfg)
+	defer closeTarget()
+
+	migrator := migrate.NewMigrator(target, *dryRun, os.Stdout)
+
+	switch command := flag.Arg(0); command {
+	case "up":
+		err = migrator.Up(ctx, *steps)
+	case "down":
+		err = migrator.Down(ctx, *steps)
+	case "status":
+		err = migrator.Status(ctx)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		closeTarget()
+		log.Fatalf("Migration failed: %v", err)
+	}
+}
+
+func openTarget(ctx context.Context, cfg *config.Config) (migrate.Target, func()) {
+	switch cfg.StorageBackend {
+	case "mongo":
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.MongoURI))
+		if err != nil {
+			log.Fatalf("Failed to connect to MongoDB: %v", err)
+		}
+		if err := client.Ping(ctx, nil); err != nil {
+			log.Fatalf("Failed to ping MongoDB: %v", err)
+		}
+
+		return migrate.NewMongo(client.Database(cfg.MongoDatabase)), func() {
+			client.Disconnect(context.Background())
+		}
+
+	case "postgres":
+		pg := cfg.Postgres
+		conn, err := db.NewPostgresConnection(pg.Host, strconv.Itoa(pg.Port), pg.User, pg.Password, pg.Database)
+		if err != nil {
+			log.Fatalf("Failed to connect to Postgres: %v", err)
+		}
+		if err := conn.PingContext(ctx); err != nil {
+			log.Fatalf("Failed to ping Postgres: %v", err)
+		}
+
+		target, err := migrate.NewPostgres(conn, migrations.FS)
+		if err != nil {
+			log.Fatalf("Failed to read migrations: %v", err)
+		}
+
+		return target, func() {
+			conn.Close()
+		}
+
+	default:
+		log.Fatalf("Unknown backend %q", cfg.StorageBackend)
+		return nil, nil
+	}
+}
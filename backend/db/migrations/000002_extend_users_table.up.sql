@@ -0,0 +1,39 @@
+CREATE EXTENSION IF NOT EXISTS postgis;
+
+-- 000001 made users with SERIAL ids and account fields only. IDs are
+-- ObjectID hex strings from here on so they stay interchangeable with
+-- MongoDB; existing ids become zero-padded hex.
+ALTER TABLE users ALTER COLUMN id DROP DEFAULT;
+ALTER TABLE users ALTER COLUMN id TYPE CHAR(24) USING lpad(to_hex(id), 24, '0');
+DROP SEQUENCE IF EXISTS users_id_seq;
+
+-- Existing users have no date of birth, so they get the zero time, like a
+-- MongoDB user without one. New users always have one.
+ALTER TABLE users
+    ADD COLUMN first_name TEXT NOT NULL DEFAULT '',
+    ADD COLUMN last_name TEXT NOT NULL DEFAULT '',
+    ADD COLUMN date_of_birth TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT '0001-01-01 00:00:00+00',
+    ADD COLUMN gender TEXT NOT NULL DEFAULT '',
+    ADD COLUMN bio TEXT NOT NULL DEFAULT '',
+    ADD COLUMN profile_pic_url TEXT NOT NULL DEFAULT '',
+    ADD COLUMN min_age INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN max_age INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN preferred_genders TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN location GEOGRAPHY(POINT, 4326),
+    ADD COLUMN location_updated_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN state TEXT NOT NULL DEFAULT 'idle',
+    ADD COLUMN current_link_id CHAR(24);
+ALTER TABLE users ALTER COLUMN date_of_birth DROP DEFAULT;
+
+CREATE INDEX users_location_idx ON users USING GIST (location);
+CREATE INDEX users_state_idx ON users (state);
+
+CREATE TABLE location_history (
+    id CHAR(24) PRIMARY KEY,
+    user_id CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    location GEOGRAPHY(POINT, 4326) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX location_history_user_created_idx ON location_history (user_id, created_at DESC);
+CREATE INDEX location_history_created_idx ON location_history (created_at);
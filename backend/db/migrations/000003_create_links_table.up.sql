@@ -0,0 +1,11 @@
+CREATE TABLE links (
+    id CHAR(24) PRIMARY KEY,
+    user_a_id CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_b_id CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    status TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX links_status_expires_idx ON links (status, expires_at);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS chatroom_locations;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS chatrooms;
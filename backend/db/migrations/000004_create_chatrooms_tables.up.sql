@@ -0,0 +1,39 @@
+CREATE TABLE chatrooms (
+    id CHAR(24) PRIMARY KEY,
+    link_id CHAR(24) NOT NULL REFERENCES links (id) ON DELETE CASCADE,
+    user_a_id CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_b_id CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    is_locked BOOLEAN NOT NULL DEFAULT TRUE,
+    location_paused_by TEXT[] NOT NULL DEFAULT '{}',
+    unlock_taps JSONB NOT NULL DEFAULT '{}',
+    used_unlock_nonces TEXT[] NOT NULL DEFAULT '{}',
+    status TEXT NOT NULL,
+    closed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX chatrooms_user_a_idx ON chatrooms (user_a_id);
+CREATE INDEX chatrooms_user_b_idx ON chatrooms (user_b_id);
+CREATE INDEX chatrooms_locked_open_idx ON chatrooms (created_at) WHERE is_locked AND closed_at IS NULL;
+
+CREATE TABLE messages (
+    id CHAR(24) PRIMARY KEY,
+    chatroom_id CHAR(24) NOT NULL REFERENCES chatrooms (id) ON DELETE CASCADE,
+    sender_id CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX messages_chatroom_created_idx ON messages (chatroom_id, created_at);
+
+CREATE TABLE chatroom_locations (
+    id CHAR(24) UNIQUE NOT NULL,
+    chatroom_id CHAR(24) NOT NULL REFERENCES chatrooms (id) ON DELETE CASCADE,
+    user_id CHAR(24) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    location GEOGRAPHY(POINT, 4326) NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (chatroom_id, user_id)
+);
+
+CREATE INDEX chatroom_locations_updated_idx ON chatroom_locations (updated_at);
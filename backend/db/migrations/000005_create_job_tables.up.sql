@@ -0,0 +1,17 @@
+CREATE TABLE leases (
+    name TEXT PRIMARY KEY,
+    holder TEXT NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    renewed_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE TABLE job_runs (
+    id CHAR(24) PRIMARY KEY,
+    job TEXT NOT NULL,
+    holder TEXT NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    finished_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX job_runs_job_started_idx ON job_runs (job, started_at DESC);
@@ -0,0 +1,2 @@
+-- The original spelling of each email isn't kept, so there's nothing to undo
+SELECT 1;
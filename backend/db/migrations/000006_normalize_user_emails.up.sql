@@ -0,0 +1,4 @@
+-- Emails are stored trimmed and lowercased from now on; bring existing
+-- accounts in line so they can still log in. Fails on accounts that only
+-- differed by case, which have to be merged by hand.
+UPDATE users SET email = lower(btrim(email)) WHERE email <> lower(btrim(email));
@@ -0,0 +1,3 @@
+-- When the user last moved between states, for the time-to-match metric.
+-- Existing users get it on their next move.
+ALTER TABLE users ADD COLUMN state_changed_at TIMESTAMPTZ;
@@ -0,0 +1,20 @@
+CREATE TABLE login_failures (
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX login_failures_expires_at_idx ON login_failures (expires_at);
+
+CREATE TABLE security_events (
+    id CHAR(24) PRIMARY KEY,
+    type TEXT NOT NULL,
+    user_id CHAR(24),
+    ip TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX security_events_created_at_idx ON security_events (created_at);
+CREATE INDEX security_events_user_created_idx ON security_events (user_id, created_at DESC);
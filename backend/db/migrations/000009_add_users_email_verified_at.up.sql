@@ -0,0 +1,4 @@
+-- Unverified users can't search. Accounts made before emails were verified
+-- are taken as verified rather than shut out.
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+UPDATE users SET email_verified_at = created_at;
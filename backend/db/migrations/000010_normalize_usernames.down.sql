@@ -0,0 +1,2 @@
+-- The original spelling isn't kept, so there's nothing to undo.
+SELECT 1;
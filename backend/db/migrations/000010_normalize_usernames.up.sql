@@ -0,0 +1,6 @@
+-- Usernames are stored trimmed and in NFKC form from now on, so the unique
+-- constraint also catches names that only differ in encoding. Needs
+-- PostgreSQL 13 or later and a UTF8 database. Fails on names that collide
+-- once normalized, which have to be renamed by hand.
+UPDATE users SET username = normalize(btrim(username), NFKC)
+WHERE username <> normalize(btrim(username), NFKC);
@@ -0,0 +1,8 @@
+// Package migrations embeds the Postgres schema migrations so cmd/migrate
+// can apply them without the source tree at hand.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
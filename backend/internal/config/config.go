@@ -0,0 +1,448 @@
+// Package config gathers the API's settings into one Config. Every setting
+// starts at its default and can be overridden, in increasing order of
+// precedence, by a JSON config file, an environment variable and a
+// command-line flag.
+//
+// The config file is a flat JSON object keyed by flag name, for example
+//
+//	{"port": 8080, "storage-backend": "postgres", "link-ttl": "45s"}
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	netmail "net/mail"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/mail"
+	"github.com/seunghoon34/linkapp/backend/internal/ratelimit"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+	"github.com/seunghoon34/linkapp/backend/internal/service"
+	"github.com/seunghoon34/linkapp/backend/internal/tracing"
+)
+
+type Config struct {
+	Port            int
+	ShutdownTimeout time.Duration
+	// ShutdownDelay is how long the server keeps serving, while failing
+	// readiness, before it stops taking connections
+	ShutdownDelay time.Duration
+	// ProxyHops is how many proxies in front of the API add themselves to
+	// X-Forwarded-For, and so how many of its entries can be trusted
+	ProxyHops int
+	// AdminToken guards /admin routes and /metrics, which are off without it
+	AdminToken string
+	// LogLevel is the lowest level that gets logged
+	LogLevel slog.Level
+	Tracing  tracing.Options
+
+	StorageBackend string
+	MongoURI       string
+	MongoDatabase  string
+	Postgres       Postgres
+	Timeouts       repository.Timeouts
+
+	// SearchRadius is how far away, in metres, a match can be
+	SearchRadius float64
+	LinkTTL      time.Duration
+	// LinkExpiryInterval is how often the leader looks for pending links
+	// that no instance is timing
+	LinkExpiryInterval time.Duration
+
+	LocationSharingLimit time.Duration
+	LocationStaleAfter   time.Duration
+	LockedChatroomTTL    time.Duration
+
+	Unlock            service.UnlockPolicy
+	UnlockTokenSecret string
+
+	Login service.LoginPolicy
+
+	Mail mail.Options
+	// AppURL is where links in emails point
+	AppURL             string
+	AccountTokens      service.AccountTokenPolicy
+	AccountTokenSecret string
+
+	RateLimit RateLimit
+
+	// sources says where each setting's value came from, by name
+	sources map[string]string
+}
+
+type Postgres struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Database string
+}
+
+// RateLimit says where rate limit buckets are kept and how fast each
+// limited route can be called.
+type RateLimit struct {
+	// Backend is memory, mongo or redis
+	Backend  string
+	RedisURL string
+
+	Login             ratelimit.Policy
+	Signup            ratelimit.Policy
+	FindMatch         ratelimit.Policy
+	SendMessage       ratelimit.Policy
+	PasswordReset     ratelimit.Policy
+	EmailVerification ratelimit.Policy
+}
+
+// Default returns the configuration used when nothing is overridden. It
+// isn't valid on its own, since MongoDB needs a URI.
+func Default() *Config {
+	return &Config{
+		Port:            8080,
+		ShutdownTimeout: 15 * time.Second,
+		ShutdownDelay:   5 * time.Second,
+		LogLevel:        slog.LevelInfo,
+		Tracing:         tracing.DefaultOptions,
+
+		StorageBackend: "mongo",
+		MongoDatabase:  "dating_app",
+		Postgres:       Postgres{Port: 5432},
+		Timeouts:       repository.DefaultTimeouts,
+
+		SearchRadius:       service.DefaultSearchRadius,
+		LinkTTL:            service.DefaultLinkTTL,
+		LinkExpiryInterval: 5 * time.Second,
+
+		LocationSharingLimit: service.DefaultLocationSharingLimit,
+		LocationStaleAfter:   service.DefaultLocationStaleAfter,
+		LockedChatroomTTL:    service.DefaultLockedChatroomTTL,
+
+		Unlock: service.DefaultUnlockPolicy,
+		Login:  service.DefaultLoginPolicy,
+
+		Mail:          mail.DefaultOptions,
+		AppURL:        service.DefaultAppURL,
+		AccountTokens: service.DefaultAccountTokenPolicy,
+
+		RateLimit: RateLimit{
+			Backend: "memory",
+			Login:   ratelimit.Policy{PerIP: ratelimit.Limit{Requests: 10, Per: time.Minute}},
+			Signup:  ratelimit.Policy{PerIP: ratelimit.Limit{Requests: 5, Per: time.Hour}},
+			FindMatch: ratelimit.Policy{
+				PerIP:   ratelimit.Limit{Requests: 60, Per: time.Minute},
+				PerUser: ratelimit.Limit{Requests: 20, Per: time.Minute},
+			},
+			SendMessage: ratelimit.Policy{
+				PerIP:   ratelimit.Limit{Requests: 120, Per: time.Minute},
+				PerUser: ratelimit.Limit{Requests: 30, Per: time.Minute},
+			},
+			// Both send email, so they're kept slow
+			PasswordReset:     ratelimit.Policy{PerIP: ratelimit.Limit{Requests: 5, Per: time.Hour}},
+			EmailVerification: ratelimit.Policy{PerUser: ratelimit.Limit{Requests: 3, Per: time.Hour}},
+		},
+	}
+}
+
+// setting binds one field of a Config to its flag, config file key and
+// environment variable.
+type setting struct {
+	name   string
+	env    string
+	usage  string
+	secret bool
+	value  value
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{name: "port", env: "PORT", usage: "port to listen on", value: intValue(&c.Port)},
+		{name: "shutdown-timeout", env: "SHUTDOWN_TIMEOUT", usage: "how long to wait for requests to finish when stopping", value: durationValue(&c.ShutdownTimeout)},
+		{name: "shutdown-delay", env: "SHUTDOWN_DELAY", usage: "how long to fail readiness before stopping, so load balancers move traffic away", value: durationValue(&c.ShutdownDelay)},
+		{name: "proxy-hops", env: "PROXY_HOPS", usage: "proxies in front of the API whose X-Forwarded-For entries are trusted", value: intValue(&c.ProxyHops)},
+		{name: "admin-token", env: "ADMIN_TOKEN", usage: "bearer token for /admin routes and /metrics, which are off without one", secret: true, value: stringValue(&c.AdminToken)},
+		{name: "log-level", env: "LOG_LEVEL", usage: "lowest level to log: debug, info, warn or error", value: levelValue(&c.LogLevel)},
+		{name: "trace-exporter", env: "TRACE_EXPORTER", usage: "where to send traces: none, otlp or stdout", value: stringValue(&c.Tracing.Exporter)},
+		{name: "trace-otlp-endpoint", env: "TRACE_OTLP_ENDPOINT", usage: "OTLP/HTTP collector address, such as localhost:4318", value: stringValue(&c.Tracing.Endpoint)},
+		{name: "trace-sample-ratio", env: "TRACE_SAMPLE_RATIO", usage: "share of new traces to record, from 0 to 1", value: floatValue(&c.Tracing.SampleRatio)},
+
+		{name: "storage-backend", env: "STORAGE_BACKEND", usage: "database to use: mongo or postgres", value: stringValue(&c.StorageBackend)},
+		{name: "mongo-uri", env: "MONGO_URI", usage: "MongoDB connection string; the server must be a replica set, for transactions", secret: true, value: stringValue(&c.MongoURI)},
+		{name: "mongo-database", env: "MONGO_DATABASE", usage: "MongoDB database name", value: stringValue(&c.MongoDatabase)},
+		{name: "postgres-host", env: "POSTGRES_HOST", usage: "Postgres host", value: stringValue(&c.Postgres.Host)},
+		{name: "postgres-port", env: "POSTGRES_PORT", usage: "Postgres port", value: intValue(&c.Postgres.Port)},
+		{name: "postgres-user", env: "POSTGRES_USER", usage: "Postgres user", value: stringValue(&c.Postgres.User)},
+		{name: "postgres-password", env: "POSTGRES_PASSWORD", usage: "Postgres password", secret: true, value: stringValue(&c.Postgres.Password)},
+		{name: "postgres-db", env: "POSTGRES_DB", usage: "Postgres database name", value: stringValue(&c.Postgres.Database)},
+		{name: "db-query-timeout", env: "DB_QUERY_TIMEOUT", usage: "limit on a single database read or write", value: durationValue(&c.Timeouts.Query)},
+		{name: "db-search-timeout", env: "DB_SEARCH_TIMEOUT", usage: "limit on a match search or cleanup sweep", value: durationValue(&c.Timeouts.Search)},
+
+		{name: "search-radius", env: "SEARCH_RADIUS_METERS", usage: "how far away, in metres, a match can be", value: floatValue(&c.SearchRadius)},
+		{name: "link-ttl", env: "LINK_TTL", usage: "how long a link waits for both users to respond", value: durationValue(&c.LinkTTL)},
+		{name: "link-expiry-interval", env: "LINK_EXPIRY_INTERVAL", usage: "how often pending links are checked for expiry", value: durationValue(&c.LinkExpiryInterval)},
+
+		{name: "location-sharing-limit", env: "LOCATION_SHARING_LIMIT", usage: "how long after a chatroom opens its users can share locations", value: durationValue(&c.LocationSharingLimit)},
+		{name: "location-stale-after", env: "LOCATION_STALE_AFTER", usage: "how old a location can get before it's no good for matching", value: durationValue(&c.LocationStaleAfter)},
+		{name: "locked-chatroom-ttl-days", env: "LOCKED_CHATROOM_TTL_DAYS", usage: "days a chatroom can stay locked before it expires", value: daysValue(&c.LockedChatroomTTL)},
+
+		{name: "unlock-max-distance", env: "UNLOCK_MAX_DISTANCE_METERS", usage: "how far apart, in metres, users can be to unlock", value: floatValue(&c.Unlock.MaxDistance)},
+		{name: "unlock-max-location-age", env: "UNLOCK_MAX_LOCATION_AGE", usage: "how old a location can be at unlock time", value: durationValue(&c.Unlock.MaxLocationAge)},
+		{name: "unlock-tap-window", env: "UNLOCK_TAP_WINDOW", usage: "how long one user's tap waits for the other's", value: durationValue(&c.Unlock.TapWindow)},
+		{name: "unlock-token-secret", env: "UNLOCK_TOKEN_SECRET", usage: "key for signing QR unlock tokens, shared by every instance", secret: true, value: stringValue(&c.UnlockTokenSecret)},
+
+		{name: "login-free-attempts", env: "LOGIN_FREE_ATTEMPTS", usage: "failed logins in a row before each further attempt has to wait", value: intValue(&c.Login.FreeAttempts)},
+		{name: "login-backoff", env: "LOGIN_BACKOFF", usage: "first wait after the free attempts, doubled with every failure after", value: durationValue(&c.Login.Backoff)},
+		{name: "login-lockout-after", env: "LOGIN_LOCKOUT_AFTER", usage: "failed logins in a row that lock an account, or 0 for never", value: intValue(&c.Login.LockoutAfter)},
+		{name: "login-lockout-duration", env: "LOGIN_LOCKOUT_DURATION", usage: "how long a locked account or blocked IP stays that way", value: durationValue(&c.Login.LockoutDuration)},
+		{name: "login-ip-lockout-after", env: "LOGIN_IP_LOCKOUT_AFTER", usage: "failed logins from one IP that block it, or 0 for never", value: intValue(&c.Login.IPLockoutAfter)},
+
+		{name: "mail-backend", env: "MAIL_BACKEND", usage: "how to send email: log, file or smtp", value: stringValue(&c.Mail.Backend)},
+		{name: "mail-from", env: "MAIL_FROM", usage: "sender of the emails, such as \"LinkApp <no-reply@example.com>\"", value: stringValue(&c.Mail.From)},
+		{name: "mail-file", env: "MAIL_FILE", usage: "file the file backend appends emails to", value: stringValue(&c.Mail.File)},
+		{name: "smtp-host", env: "SMTP_HOST", usage: "SMTP server for the smtp backend", value: stringValue(&c.Mail.SMTP.Host)},
+		{name: "smtp-port", env: "SMTP_PORT", usage: "SMTP server port", value: intValue(&c.Mail.SMTP.Port)},
+		{name: "smtp-username", env: "SMTP_USERNAME", usage: "SMTP username, if the server needs one", value: stringValue(&c.Mail.SMTP.Username)},
+		{name: "smtp-password", env: "SMTP_PASSWORD", usage: "SMTP password", secret: true, value: stringValue(&c.Mail.SMTP.Password)},
+		{name: "app-url", env: "APP_URL", usage: "where links in emails point, such as https://linkapp.example or linkapp://", value: stringValue(&c.AppURL)},
+		{name: "email-verification-ttl", env: "EMAIL_VERIFICATION_TTL", usage: "how long an email verification link works", value: durationValue(&c.AccountTokens.VerificationTTL)},
+		{name: "password-reset-ttl", env: "PASSWORD_RESET_TTL", usage: "how long a password reset link works", value: durationValue(&c.AccountTokens.ResetTTL)},
+		{name: "account-token-secret", env: "ACCOUNT_TOKEN_SECRET", usage: "key for signing emailed links, shared by every instance", secret: true, value: stringValue(&c.AccountTokenSecret)},
+
+		{name: "rate-limit-backend", env: "RATE_LIMIT_BACKEND", usage: "where rate limits are kept: memory (this instance only), mongo or redis", value: stringValue(&c.RateLimit.Backend)},
+		{name: "rate-limit-redis-url", env: "RATE_LIMIT_REDIS_URL", usage: "Redis URL, such as redis://localhost:6379/0, for the redis backend", secret: true, value: stringValue(&c.RateLimit.RedisURL)},
+		{name: "rate-limit-login-ip", env: "RATE_LIMIT_LOGIN_IP", usage: "login attempts allowed per IP, as requests/period or off", value: limitValue(&c.RateLimit.Login.PerIP)},
+		{name: "rate-limit-signup-ip", env: "RATE_LIMIT_SIGNUP_IP", usage: "signups allowed per IP, as requests/period or off", value: limitValue(&c.RateLimit.Signup.PerIP)},
+		{name: "rate-limit-find-match-ip", env: "RATE_LIMIT_FIND_MATCH_IP", usage: "match searches allowed per IP, as requests/period or off", value: limitValue(&c.RateLimit.FindMatch.PerIP)},
+		{name: "rate-limit-find-match-user", env: "RATE_LIMIT_FIND_MATCH_USER", usage: "match searches allowed per user, as requests/period or off", value: limitValue(&c.RateLimit.FindMatch.PerUser)},
+		{name: "rate-limit-send-message-ip", env: "RATE_LIMIT_SEND_MESSAGE_IP", usage: "messages allowed per IP, as requests/period or off", value: limitValue(&c.RateLimit.SendMessage.PerIP)},
+		{name: "rate-limit-send-message-user", env: "RATE_LIMIT_SEND_MESSAGE_USER", usage: "messages allowed per user, as requests/period or off", value: limitValue(&c.RateLimit.SendMessage.PerUser)},
+		{name: "rate-limit-password-reset-ip", env: "RATE_LIMIT_PASSWORD_RESET_IP", usage: "password reset emails allowed per IP, as requests/period or off", value: limitValue(&c.RateLimit.PasswordReset.PerIP)},
+		{name: "rate-limit-email-verification-user", env: "RATE_LIMIT_EMAIL_VERIFICATION_USER", usage: "verification emails allowed per user, as requests/period or off", value: limitValue(&c.RateLimit.EmailVerification.PerUser)},
+	}
+}
+
+// Loader reads the configuration once the flags it registered are parsed.
+type Loader struct {
+	file  *string
+	flags []flagValue
+}
+
+type flagValue struct {
+	name  string
+	value string
+}
+
+// NewLoader registers a flag for every setting on fs, plus -config to name
+// a config file.
+func NewLoader(fs *flag.FlagSet) *Loader {
+	l := &Loader{
+		file: fs.String("config", os.Getenv("CONFIG_FILE"), "JSON config file to read (env CONFIG_FILE)"),
+	}
+
+	for _, s := range Default().settings() {
+		name := s.name
+		usage := fmt.Sprintf("%s (env %s)", s.usage, s.env)
+		if def := s.value.String(); def != "" && def != "0" && !s.secret {
+			usage = fmt.Sprintf("%s (default %s, env %s)", s.usage, def, s.env)
+		}
+		fs.Func(name, usage, func(v string) error {
+			l.flags = append(l.flags, flagValue{name, v})
+			return nil
+		})
+	}
+
+	return l
+}
+
+// Load applies the config file, the environment and the flags on top of the
+// defaults and validates the result. Every problem found is reported, not
+// just the first. Unless the config file can't be read, the Config comes
+// back even when it's invalid, so it can still be printed.
+func (l *Loader) Load() (*Config, error) {
+	cfg := Default()
+	cfg.sources = make(map[string]string)
+	settings := cfg.settings()
+
+	byName := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		byName[s.name] = s
+	}
+
+	var errs []error
+	set := func(s setting, raw, source string) {
+		if err := s.value.Set(raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s from %s: %v", s.name, source, err))
+			return
+		}
+		cfg.sources[s.name] = source
+	}
+
+	if *l.file != "" {
+		values, err := readFile(*l.file)
+		if err != nil {
+			return nil, err
+		}
+		for name, raw := range values {
+			s, ok := byName[name]
+			if !ok {
+				errs = append(errs, fmt.Errorf("unknown setting %q in %s", name, *l.file))
+				continue
+			}
+			set(s, raw, *l.file)
+		}
+	}
+
+	for _, s := range settings {
+		if raw := os.Getenv(s.env); raw != "" {
+			set(s, raw, "$"+s.env)
+		}
+	}
+
+	for _, f := range l.flags {
+		set(byName[f.name], f.value, "-"+f.name)
+	}
+
+	errs = append(errs, cfg.validate()...)
+	if len(errs) > 0 {
+		return cfg, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+
+	return cfg, nil
+}
+
+// readFile reads a config file into raw values. Strings are unquoted;
+// numbers and booleans are kept as written.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(fields))
+	for name, field := range fields {
+		var s string
+		if err := json.Unmarshal(field, &s); err == nil {
+			values[name] = s
+		} else {
+			values[name] = string(field)
+		}
+	}
+	return values, nil
+}
+
+func (c *Config) validate() []error {
+	var errs []error
+	check := func(ok bool, name, problem string) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", name, problem))
+		}
+	}
+
+	check(c.Port > 0 && c.Port <= 65535, "port", "must be between 1 and 65535")
+	check(c.ShutdownTimeout > 0, "shutdown-timeout", "must be positive")
+	check(c.ShutdownDelay >= 0, "shutdown-delay", "can't be negative")
+	check(c.ProxyHops >= 0, "proxy-hops", "can't be negative")
+	check(c.AdminToken == "" || len(c.AdminToken) >= 32, "admin-token", "must be at least 32 bytes")
+
+	switch c.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout:
+	default:
+		check(false, "trace-exporter", fmt.Sprintf("must be none, otlp or stdout, not %q", c.Tracing.Exporter))
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "trace-sample-ratio", "must be between 0 and 1")
+
+	switch c.StorageBackend {
+	case "mongo":
+		check(c.MongoURI != "", "mongo-uri", "is required for the mongo backend")
+		check(c.MongoDatabase != "", "mongo-database", "is required for the mongo backend")
+	case "postgres":
+		check(c.Postgres.Host != "", "postgres-host", "is required for the postgres backend")
+		check(c.Postgres.Port > 0 && c.Postgres.Port <= 65535, "postgres-port", "must be between 1 and 65535")
+		check(c.Postgres.User != "", "postgres-user", "is required for the postgres backend")
+		check(c.Postgres.Database != "", "postgres-db", "is required for the postgres backend")
+	default:
+		check(false, "storage-backend", fmt.Sprintf("must be mongo or postgres, not %q", c.StorageBackend))
+	}
+	check(c.Timeouts.Query > 0, "db-query-timeout", "must be positive")
+	check(c.Timeouts.Search > 0, "db-search-timeout", "must be positive")
+
+	check(c.SearchRadius > 0, "search-radius", "must be positive")
+	check(c.LinkTTL > 0, "link-ttl", "must be positive")
+	check(c.LinkExpiryInterval > 0, "link-expiry-interval", "must be positive")
+
+	check(c.LocationSharingLimit > 0, "location-sharing-limit", "must be positive")
+	check(c.LocationStaleAfter > 0, "location-stale-after", "must be positive")
+	check(c.LockedChatroomTTL > 0, "locked-chatroom-ttl-days", "must be positive")
+
+	check(c.Unlock.MaxDistance > 0, "unlock-max-distance", "must be positive")
+	check(c.Unlock.MaxLocationAge > 0, "unlock-max-location-age", "must be positive")
+	check(c.Unlock.TapWindow > 0, "unlock-tap-window", "must be positive")
+	check(c.UnlockTokenSecret == "" || len(c.UnlockTokenSecret) >= 32, "unlock-token-secret", "must be at least 32 bytes")
+
+	check(c.Login.FreeAttempts >= 0, "login-free-attempts", "can't be negative")
+	check(c.Login.Backoff >= 0, "login-backoff", "can't be negative")
+	check(c.Login.LockoutAfter >= 0, "login-lockout-after", "can't be negative")
+	check(c.Login.LockoutDuration > 0, "login-lockout-duration", "must be positive")
+	check(c.Login.IPLockoutAfter >= 0, "login-ip-lockout-after", "can't be negative")
+
+	switch c.Mail.Backend {
+	case mail.BackendLog:
+	case mail.BackendFile:
+		check(c.Mail.File != "", "mail-file", "is required for the file backend")
+	case mail.BackendSMTP:
+		check(c.Mail.SMTP.Host != "", "smtp-host", "is required for the smtp backend")
+		check(c.Mail.SMTP.Port > 0 && c.Mail.SMTP.Port <= 65535, "smtp-port", "must be between 1 and 65535")
+	default:
+		check(false, "mail-backend", fmt.Sprintf("must be log, file or smtp, not %q", c.Mail.Backend))
+	}
+	_, err := netmail.ParseAddress(c.Mail.From)
+	check(err == nil, "mail-from", "must be an email address, optionally with a name")
+	check(c.AppURL != "", "app-url", "is required")
+	check(c.AccountTokens.VerificationTTL > 0, "email-verification-ttl", "must be positive")
+	check(c.AccountTokens.ResetTTL > 0, "password-reset-ttl", "must be positive")
+	check(c.AccountTokenSecret == "" || len(c.AccountTokenSecret) >= 32, "account-token-secret", "must be at least 32 bytes")
+
+	switch c.RateLimit.Backend {
+	case "memory":
+	case "mongo":
+		check(c.StorageBackend == "mongo", "rate-limit-backend", "mongo needs the mongo storage backend")
+	case "redis":
+		check(c.RateLimit.RedisURL != "", "rate-limit-redis-url", "is required for the redis backend")
+	default:
+		check(false, "rate-limit-backend", fmt.Sprintf("must be memory, mongo or redis, not %q", c.RateLimit.Backend))
+	}
+
+	return errs
+}
+
+// Print writes every setting with its value and where the value came from.
+// Secrets are shown only as set or unset.
+func (c *Config) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, s := range c.settings() {
+		value := s.value.String()
+		if s.secret && value != "" {
+			value = "[redacted]"
+		}
+		if value == "" {
+			value = "-"
+		}
+
+		source, ok := c.sources[s.name]
+		if !ok {
+			source = "default"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.name, value, source)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/ratelimit"
+)
+
+// value reads and writes one Config field as text. Set trims the text
+// first, so "30s " from a .env file still parses.
+type value struct {
+	set func(string) error
+	get func() string
+}
+
+func (v value) Set(raw string) error {
+	return v.set(strings.TrimSpace(raw))
+}
+
+func (v value) String() string {
+	return v.get()
+}
+
+func stringValue(p *string) value {
+	return value{
+		set: func(s string) error { *p = s; return nil },
+		get: func() string { return *p },
+	}
+}
+
+func intValue(p *int) value {
+	return value{
+		set: func(s string) error {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return fmt.Errorf("%q is not a whole number", s)
+			}
+			*p = n
+			return nil
+		},
+		get: func() string { return strconv.Itoa(*p) },
+	}
+}
+
+func floatValue(p *float64) value {
+	return value{
+		set: func(s string) error {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return fmt.Errorf("%q is not a number", s)
+			}
+			*p = f
+			return nil
+		},
+		get: func() string { return strconv.FormatFloat(*p, 'f', -1, 64) },
+	}
+}
+
+func durationValue(p *time.Duration) value {
+	return value{
+		set: func(s string) error {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return fmt.Errorf("%q is not a duration such as 90s or 2h", s)
+			}
+			*p = d
+			return nil
+		},
+		get: func() string { return p.String() },
+	}
+}
+
+// daysValue holds a duration set as a whole number of days.
+func daysValue(p *time.Duration) value {
+	return value{
+		set: func(s string) error {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return fmt.Errorf("%q is not a whole number of days", s)
+			}
+			*p = time.Duration(n) * 24 * time.Hour
+			return nil
+		},
+		get: func() string { return strconv.Itoa(int(*p / (24 * time.Hour))) },
+	}
+}
+
+func levelValue(p *slog.Level) value {
+	return value{
+		set: func(s string) error {
+			var level slog.Level
+			if err := level.UnmarshalText([]byte(s)); err != nil {
+				return fmt.Errorf("%q is not a log level such as debug, info, warn or error", s)
+			}
+			*p = level
+			return nil
+		},
+		get: func() string { return strings.ToLower(p.String()) },
+	}
+}
+
+func limitValue(p *ratelimit.Limit) value {
+	return value{
+		set: func(s string) error {
+			limit, err := ratelimit.ParseLimit(s)
+			if err != nil {
+				return err
+			}
+			*p = limit
+			return nil
+		},
+		get: func() string { return p.String() },
+	}
+}
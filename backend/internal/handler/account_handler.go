@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (h *UserHandler) RequestEmailVerification(w http.ResponseWriter, r *http.Request) {
+	userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		badRequest(w, r, "invalid_id", "invalid user ID")
+		return
+	}
+
+	if err := h.userService.RequestEmailVerification(r.Context(), userID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Verification email sent"})
+}
+
+func (h *UserHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token string `json:"token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		badRequest(w, r, "invalid_body", "invalid request body")
+		return
+	}
+	if input.Token == "" {
+		badRequest(w, r, "missing_token", "missing verification token")
+		return
+	}
+
+	if err := h.userService.VerifyEmail(r.Context(), input.Token); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email verified"})
+}
+
+// RequestPasswordReset answers the same whether or not an account has the
+// email.
+func (h *UserHandler) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		badRequest(w, r, "invalid_body", "invalid request body")
+		return
+	}
+
+	if err := h.userService.RequestPasswordReset(r.Context(), input.Email); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "If an account has this email, a reset link is on its way"})
+}
+
+func (h *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		badRequest(w, r, "invalid_body", "invalid request body")
+		return
+	}
+	if input.Token == "" {
+		badRequest(w, r, "missing_token", "missing reset token")
+		return
+	}
+
+	if err := h.userService.ResetPassword(r.Context(), input.Token, input.Password, ClientIP(r)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password reset"})
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/seunghoon34/linkapp/backend/internal/jobs"
+)
+
+type AdminHandler struct {
+	jobRunner *jobs.Runner
+}
+
+func NewAdminHandler(jobRunner *jobs.Runner) *AdminHandler {
+	return &AdminHandler{jobRunner: jobRunner}
+}
+
+func (h *AdminHandler) JobStatus(w http.ResponseWriter, r *http.Request) {
+	reports, err := h.jobRunner.Report(r.Context(), 10)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"instance": h.jobRunner.Holder(),
+		"jobs":     reports,
+	})
+}
+
+// RequireAdminToken lets a request through to next only if it carries token
+// as "Authorization: Bearer <token>". With no token configured the route
+// answers as if it didn't exist.
+func RequireAdminToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			NotFound(w, r)
+			return
+		}
+
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeErrorResponse(w, r, http.StatusUnauthorized, errorResponse{Code: "admin_token_required", Message: "a valid admin token is required"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequireAdminToken(t *testing.T) {
+	const token = "0123456789abcdef0123456789abcdef"
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		want          int
+	}{
+		{"right token", token, "Bearer " + token, http.StatusOK},
+		{"no header", token, "", http.StatusUnauthorized},
+		{"wrong token", token, "Bearer " + strings.Repeat("x", len(token)), http.StatusUnauthorized},
+		{"token prefix", token, "Bearer " + token[:8], http.StatusUnauthorized},
+		{"other scheme", token, "Basic " + token, http.StatusUnauthorized},
+		// Without a token nothing gets in, not even an empty bearer
+		{"not configured", "", "Bearer ", http.StatusNotFound},
+		{"not configured, no header", "", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/jobs", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			RequireAdminToken(tt.token, ok).ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if challenge := rec.Header().Get("WWW-Authenticate"); (challenge != "") != (tt.want == http.StatusUnauthorized) {
+				t.Errorf("WWW-Authenticate = %q with status %d", challenge, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type clientIPKey struct{}
+
+// ClientIPMiddleware works out the address each request came from. Behind
+// proxies that's the X-Forwarded-For entry added by the outermost of the
+// proxyHops trusted proxies; entries further left could have been made up
+// by the client.
+func ClientIPMiddleware(proxyHops int) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := remoteIP(r)
+			if proxyHops > 0 {
+				var hops []string
+				for _, header := range r.Header.Values("X-Forwarded-For") {
+					for _, hop := range strings.Split(header, ",") {
+						hops = append(hops, strings.TrimSpace(hop))
+					}
+				}
+				if len(hops) >= proxyHops {
+					ip = hops[len(hops)-proxyHops]
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+		})
+	}
+}
+
+// ClientIP returns the address ClientIPMiddleware found for the request,
+// or the peer's address if it didn't run.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+	"github.com/seunghoon34/linkapp/backend/internal/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// errorResponse is the body of every error response. Code is stable for
+// clients to branch on; Details, when present, says which inputs were wrong.
+type errorResponse struct {
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	Details   map[string]string `json:"details,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+var kindStatus = map[service.Kind]int{
+	service.KindInvalid:      http.StatusBadRequest,
+	service.KindUnauthorized: http.StatusUnauthorized,
+	service.KindForbidden:    http.StatusForbidden,
+	service.KindNotFound:     http.StatusNotFound,
+	service.KindConflict:     http.StatusConflict,
+	service.KindGone:         http.StatusGone,
+	service.KindPrecondition: http.StatusPreconditionFailed,
+	service.KindThrottled:    http.StatusTooManyRequests,
+}
+
+// notFound maps the repositories' not-found errors, which the service passes
+// through untouched.
+var notFound = []struct {
+	err  error
+	code string
+}{
+	{repository.ErrUserNotFound, "user_not_found"},
+	{repository.ErrLinkNotFound, "link_not_found"},
+	{repository.ErrChatroomNotFound, "chatroom_not_found"},
+}
+
+// writeError is the one place errors become responses. Anything it doesn't
+// recognise is logged and reported as a bare internal error, so database
+// and driver messages never reach the client.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		serviceErr *service.Error
+		invalid    *service.ValidationError
+		conflict   *repository.ConflictError
+		throttled  *service.LoginThrottledError
+	)
+
+	if errors.As(err, &throttled) {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(throttled.RetryAfter)))
+	}
+
+	switch {
+	case errors.As(err, &serviceErr):
+		status, ok := kindStatus[serviceErr.Kind]
+		if !ok {
+			status = http.StatusInternalServerError
+		}
+		writeErrorResponse(w, r, status, errorResponse{Code: serviceErr.Code, Message: serviceErr.Message})
+		return
+	case errors.As(err, &invalid):
+		writeErrorResponse(w, r, http.StatusBadRequest, errorResponse{Code: "invalid", Message: err.Error(), Details: invalid.Fields})
+		return
+	case errors.As(err, &conflict):
+		writeErrorResponse(w, r, http.StatusConflict, errorResponse{
+			Code:    "conflict",
+			Message: err.Error(),
+			Details: map[string]string{conflict.Field: "is already taken"},
+		})
+		return
+	case errors.Is(err, primitive.ErrInvalidHex):
+		writeErrorResponse(w, r, http.StatusBadRequest, errorResponse{Code: "invalid_id", Message: "invalid ID"})
+		return
+	case errors.Is(err, context.Canceled) && r.Context().Err() != nil:
+		// The client went away; there's nobody left to answer
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		slog.WarnContext(r.Context(), "request timed out", "method", r.Method, "path", r.URL.Path, "error", err)
+		writeErrorResponse(w, r, http.StatusGatewayTimeout, errorResponse{Code: "timeout", Message: "the request took too long"})
+		return
+	}
+
+	for _, nf := range notFound {
+		if errors.Is(err, nf.err) {
+			writeErrorResponse(w, r, http.StatusNotFound, errorResponse{Code: nf.code, Message: nf.err.Error()})
+			return
+		}
+	}
+
+	slog.ErrorContext(r.Context(), "internal error", "method", r.Method, "path", r.URL.Path, "error", err)
+	writeErrorResponse(w, r, http.StatusInternalServerError, errorResponse{Code: "internal", Message: "internal server error"})
+}
+
+// badRequest reports a request the handler couldn't parse.
+func badRequest(w http.ResponseWriter, r *http.Request, code, message string) {
+	writeErrorResponse(w, r, http.StatusBadRequest, errorResponse{Code: code, Message: message})
+}
+
+func writeErrorResponse(w http.ResponseWriter, r *http.Request, status int, body errorResponse) {
+	body.RequestID = RequestID(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// NotFound answers requests for routes that don't exist.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	writeErrorResponse(w, r, http.StatusNotFound, errorResponse{Code: "route_not_found", Message: "no such route"})
+}
+
+// MethodNotAllowed answers requests with a method the route doesn't take.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeErrorResponse(w, r, http.StatusMethodNotAllowed, errorResponse{Code: "method_not_allowed", Message: "method not allowed"})
+}
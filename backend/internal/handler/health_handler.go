@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/jobs"
+)
+
+// readyPingTimeout bounds the database ping behind /readyz, so a hung
+// database fails the probe instead of hanging it.
+const readyPingTimeout = 2 * time.Second
+
+// HealthHandler answers the orchestrator's liveness and readiness probes.
+type HealthHandler struct {
+	ping      func(ctx context.Context) error
+	jobRunner *jobs.Runner
+	draining  atomic.Bool
+}
+
+func NewHealthHandler(ping func(ctx context.Context) error, jobRunner *jobs.Runner) *HealthHandler {
+	return &HealthHandler{ping: ping, jobRunner: jobRunner}
+}
+
+// Drain makes readiness fail from now on, so traffic moves elsewhere before
+// the server stops.
+func (h *HealthHandler) Drain() {
+	h.draining.Store(true)
+}
+
+// Live reports that the process is up and serving requests.
+func (h *HealthHandler) Live(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// Ready reports whether this instance should get traffic: it isn't shutting
+// down, the database answers and no background job has stalled.
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{
+		"shutdown": "ok",
+		"database": "ok",
+		"jobs":     "ok",
+	}
+	ready := true
+
+	if h.draining.Load() {
+		checks["shutdown"] = "draining"
+		ready = false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyPingTimeout)
+	defer cancel()
+	if err := h.ping(ctx); err != nil {
+		slog.WarnContext(r.Context(), "readiness check: database ping failed", "error", err)
+		checks["database"] = "unreachable"
+		ready = false
+	}
+
+	if stalled := h.jobRunner.Stalled(time.Now()); len(stalled) > 0 {
+		checks["jobs"] = "stalled: " + strings.Join(stalled, ", ")
+		ready = false
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+// Version reports what build is running, from the information the Go
+// toolchain embeds in the binary.
+func (h *HealthHandler) Version(w http.ResponseWriter, r *http.Request) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		writeErrorResponse(w, r, http.StatusNotImplemented, errorResponse{Code: "no_build_info", Message: "build information is not available"})
+		return
+	}
+
+	version := map[string]string{
+		"module":     info.Main.Path,
+		"version":    info.Main.Version,
+		"go_version": info.GoVersion,
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			version["revision"] = setting.Value
+		case "vcs.time":
+			version["commit_time"] = setting.Value
+		case "vcs.modified":
+			version["modified"] = setting.Value
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version)
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func chatroomVars(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, primitive.ObjectID, bool) {
+	vars := mux.Vars(r)
+	userID, err := primitive.ObjectIDFromHex(vars["userId"])
+	if err != nil {
+		badRequest(w, r, "invalid_id", "invalid user ID")
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+
+	chatroomID, err := primitive.ObjectIDFromHex(vars["chatroomId"])
+	if err != nil {
+		badRequest(w, r, "invalid_id", "invalid chatroom ID")
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+
+	return userID, chatroomID, true
+}
+
+func (h *UserHandler) ShareChatroomLocation(w http.ResponseWriter, r *http.Request) {
+	userID, chatroomID, ok := chatroomVars(w, r)
+	if !ok {
+		return
+	}
+
+	var locationData struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&locationData); err != nil {
+		badRequest(w, r, "invalid_body", "invalid request body")
+		return
+	}
+
+	location, err := h.userService.ShareLocation(r.Context(), userID, chatroomID, locationData.Latitude, locationData.Longitude)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(location)
+}
+
+func (h *UserHandler) GetPeerLocation(w http.ResponseWriter, r *http.Request) {
+	userID, chatroomID, ok := chatroomVars(w, r)
+	if !ok {
+		return
+	}
+
+	location, err := h.userService.GetPeerLocation(r.Context(), userID, chatroomID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if location == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(location)
+}
+
+// StreamPeerLocation sends the peer's position as server-sent events until
+// the chatroom unlocks, the sharing window runs out or the client goes away.
+func (h *UserHandler) StreamPeerLocation(w http.ResponseWriter, r *http.Request) {
+	userID, chatroomID, ok := chatroomVars(w, r)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeErrorResponse(w, r, http.StatusInternalServerError, errorResponse{Code: "streaming_unsupported", Message: "streaming unsupported"})
+		return
+	}
+
+	sub, err := h.userService.SubscribePeerLocation(r.Context(), userID, chatroomID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer sub.Close()
+
+	latest, err := h.userService.GetPeerLocation(r.Context(), userID, chatroomID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if latest != nil {
+		writeLocationEvent(w, latest)
+	}
+	flusher.Flush()
+
+	expired := time.NewTimer(time.Until(sub.Expires))
+	defer expired.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-expired.C:
+			fmt.Fprint(w, "event: end\ndata: {}\n\n")
+			flusher.Flush()
+			return
+		case location, ok := <-sub.Updates:
+			if !ok {
+				fmt.Fprint(w, "event: end\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+			writeLocationEvent(w, location)
+			flusher.Flush()
+		}
+	}
+}
+
+func writeLocationEvent(w http.ResponseWriter, location *model.SharedLocation) {
+	data, err := json.Marshal(location)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: location\ndata: %s\n\n", data)
+}
+
+func (h *UserHandler) PauseLocationSharing(w http.ResponseWriter, r *http.Request) {
+	userID, chatroomID, ok := chatroomVars(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.userService.PauseLocationSharing(r.Context(), userID, chatroomID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Location sharing paused"})
+}
+
+func (h *UserHandler) ResumeLocationSharing(w http.ResponseWriter, r *http.Request) {
+	userID, chatroomID, ok := chatroomVars(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.userService.ResumeLocationSharing(r.Context(), userID, chatroomID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Location sharing resumed"})
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/seunghoon34/linkapp/backend/internal/metrics"
+	"github.com/seunghoon34/linkapp/backend/internal/ratelimit"
+)
+
+// RateLimiter caps how often clients can call the routes it wraps.
+type RateLimiter struct {
+	store ratelimit.Store
+}
+
+func NewRateLimiter(store ratelimit.Store) *RateLimiter {
+	return &RateLimiter{store: store}
+}
+
+// Limit wraps next so each client IP, and each user named in the path, can
+// call it only as often as policy allows. route names the buckets, so
+// routes sharing a name share a budget. If the store fails, requests are
+// let through rather than refused.
+func (l *RateLimiter) Limit(route string, policy ratelimit.Policy, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The IP goes first, so someone flooding a user's routes from one
+		// address can't spend that user's budget with requests the IP
+		// bucket turns away
+		checks := []struct {
+			key   string
+			id    string
+			limit ratelimit.Limit
+		}{
+			{"ip", ClientIP(r), policy.PerIP},
+			{"user", pathUserID(r), policy.PerUser},
+		}
+
+		var tightest *ratelimit.Result
+		for _, check := range checks {
+			if check.limit.IsZero() || check.id == "" {
+				continue
+			}
+
+			res, err := l.store.Take(r.Context(), route+":"+check.key+":"+check.id, check.limit)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "rate limit check failed", "route", route, "error", err)
+				continue
+			}
+
+			if !res.Allowed {
+				metrics.RateLimited.WithLabelValues(route, check.key).Inc()
+				setRateLimitHeaders(w, res)
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+				writeErrorResponse(w, r, http.StatusTooManyRequests, errorResponse{Code: "rate_limited", Message: "too many requests, try again later"})
+				return
+			}
+			if tightest == nil || res.Remaining < tightest.Remaining {
+				tightest = &res
+			}
+		}
+
+		if tightest != nil {
+			setRateLimitHeaders(w, *tightest)
+		}
+		next(w, r)
+	})
+}
+
+// setRateLimitHeaders describes the bucket in the RateLimit headers from
+// the IETF draft, so clients can pace themselves.
+func setRateLimitHeaders(w http.ResponseWriter, res ratelimit.Result) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit.Requests))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", res.Limit.Requests, ceilSeconds(res.Limit.Per)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// pathUserID is the user the request is made as, for routes that have one.
+func pathUserID(r *http.Request) string {
+	vars := mux.Vars(r)
+	if id := vars["userId"]; id != "" {
+		return id
+	}
+	return vars["id"]
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/seunghoon34/linkapp/backend/internal/ratelimit"
+)
+
+// failingStore stands in for a rate limit backend that's down.
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("store unavailable")
+}
+
+// limitedRouter serves /users/{userId}/ping behind the rate limiter.
+func limitedRouter(store ratelimit.Store, policy ratelimit.Policy) *mux.Router {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	r := mux.NewRouter()
+	r.Handle("/users/{userId}/ping", NewRateLimiter(store).Limit("ping", policy, ok))
+	return r
+}
+
+func ping(router http.Handler, userID, ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/users/"+userID+"/ping", nil)
+	req.RemoteAddr = ip + ":1234"
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimiterHeaders(t *testing.T) {
+	router := limitedRouter(ratelimit.NewMemoryStore(), ratelimit.Policy{
+		PerIP: ratelimit.Limit{Requests: 2, Per: time.Minute},
+	})
+
+	for _, remaining := range []string{"1", "0"} {
+		rec := ping(router, "someone", "192.0.2.1")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+
+		want := map[string]string{
+			"RateLimit-Limit":     "2",
+			"RateLimit-Remaining": remaining,
+			"RateLimit-Policy":    "2;w=60",
+		}
+		for header, value := range want {
+			if got := rec.Header().Get(header); got != value {
+				t.Errorf("%s = %q, want %q", header, got, value)
+			}
+		}
+		if reset, err := strconv.Atoi(rec.Header().Get("RateLimit-Reset")); err != nil || reset < 1 || reset > 60 {
+			t.Errorf("RateLimit-Reset = %q, want 1 to 60 seconds", rec.Header().Get("RateLimit-Reset"))
+		}
+		if got := rec.Header().Get("Retry-After"); got != "" {
+			t.Errorf("Retry-After = %q on an allowed request", got)
+		}
+	}
+
+	rec := ping(router, "someone", "192.0.2.1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rec.Code)
+	}
+	// A token comes back every 30s
+	if retry, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || retry < 1 || retry > 30 {
+		t.Errorf("Retry-After = %q, want 1 to 30 seconds", rec.Header().Get("Retry-After"))
+	}
+	if got := rec.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("RateLimit-Remaining = %q, want 0", got)
+	}
+
+	var body errorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != "rate_limited" {
+		t.Errorf("error code = %q, want rate_limited", body.Code)
+	}
+
+	// Another address has its own budget
+	if rec := ping(router, "someone", "192.0.2.2"); rec.Code != http.StatusOK {
+		t.Errorf("status from another address = %d, want 200", rec.Code)
+	}
+}
+
+func TestRateLimiterPerUser(t *testing.T) {
+	router := limitedRouter(ratelimit.NewMemoryStore(), ratelimit.Policy{
+		PerIP:   ratelimit.Limit{Requests: 10, Per: time.Minute},
+		PerUser: ratelimit.Limit{Requests: 1, Per: time.Minute},
+	})
+
+	// The headers describe whichever bucket is closest to running out
+	rec := ping(router, "someone", "192.0.2.1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("RateLimit-Limit"); got != "1" {
+		t.Errorf("RateLimit-Limit = %q, want the user's limit of 1", got)
+	}
+
+	// The same user from another address is still limited
+	if rec := ping(router, "someone", "192.0.2.2"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", rec.Code)
+	}
+	if rec := ping(router, "someone-else", "192.0.2.1"); rec.Code != http.StatusOK {
+		t.Errorf("status for another user = %d, want 200", rec.Code)
+	}
+}
+
+func TestRateLimiterIPDeniedKeepsUserBudget(t *testing.T) {
+	router := limitedRouter(ratelimit.NewMemoryStore(), ratelimit.Policy{
+		PerIP:   ratelimit.Limit{Requests: 1, Per: time.Minute},
+		PerUser: ratelimit.Limit{Requests: 2, Per: time.Minute},
+	})
+
+	if rec := ping(router, "someone", "192.0.2.1"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	// Turned away by the IP bucket, however often
+	for range 3 {
+		if rec := ping(router, "someone", "192.0.2.1"); rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("status = %d, want 429", rec.Code)
+		}
+	}
+
+	// None of which cost the user their second token
+	if rec := ping(router, "someone", "192.0.2.2"); rec.Code != http.StatusOK {
+		t.Errorf("status from another address = %d, want 200", rec.Code)
+	}
+}
+
+func TestRateLimiterStoreDown(t *testing.T) {
+	router := limitedRouter(failingStore{}, ratelimit.Policy{
+		PerIP: ratelimit.Limit{Requests: 1, Per: time.Minute},
+	})
+
+	for range 3 {
+		rec := ping(router, "someone", "192.0.2.1")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want requests let through", rec.Code)
+		}
+		if got := rec.Header().Get("RateLimit-Limit"); got != "" {
+			t.Errorf("RateLimit-Limit = %q without a working store", got)
+		}
+	}
+}
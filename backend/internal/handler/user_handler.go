@@ -2,6 +2,7 @@ package handler
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
 	"strconv"
 
@@ -27,7 +28,7 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		badRequest(w, r, "invalid_body", "invalid request body")
 		return
 	}
 
@@ -36,16 +37,17 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		Email:    input.Email,
 		Password: input.Password,
 	}
-	err := h.userService.CreateUser(user)
+	err := h.userService.CreateUser(r.Context(), user)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
+	// The user has the normalized username and email, and no password
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(input)
+	json.NewEncoder(w).Encode(user)
 }
 
 func (h *UserHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
@@ -54,12 +56,12 @@ func (h *UserHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 
 	var profile model.Profile
 	if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		badRequest(w, r, "invalid_body", "invalid request body")
 		return
 	}
 
-	if err := h.userService.UpdateProfile(id, profile); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.userService.UpdateProfile(r.Context(), id, profile); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -72,12 +74,12 @@ func (h *UserHandler) UpdatePreferences(w http.ResponseWriter, r *http.Request)
 
 	var preferences model.Preferences
 	if err := json.NewDecoder(r.Body).Decode(&preferences); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		badRequest(w, r, "invalid_body", "invalid request body")
 		return
 	}
 
-	if err := h.userService.UpdatePreferences(id, preferences); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.userService.UpdatePreferences(r.Context(), id, preferences); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -88,9 +90,9 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	user, err := h.userService.GetUserByID(id)
+	user, err := h.userService.GetPublicUser(r.Context(), id)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 
@@ -108,12 +110,12 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		badRequest(w, r, "invalid_body", "invalid request body")
 		return
 	}
 
-	if err := h.userService.UpdateUser(id, input.Username, input.Email); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.userService.UpdateUser(r.Context(), id, input.Username, input.Email); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -127,13 +129,13 @@ func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		badRequest(w, r, "invalid_body", "invalid request body")
 		return
 	}
 
-	user, err := h.userService.AuthenticateUser(input.Email, input.Password)
+	user, err := h.userService.AuthenticateUser(r.Context(), input.Email, input.Password, ClientIP(r))
 	if err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
@@ -142,6 +144,11 @@ func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
+
+// maxMatchesLimit caps how many matches one page can ask for, since each
+// page is a geo search.
+const maxMatchesLimit = 100
+
 func (h *UserHandler) SearchMatches(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID := vars["id"]
@@ -150,10 +157,18 @@ func (h *UserHandler) SearchMatches(w http.ResponseWriter, r *http.Request) {
 	if limit <= 0 {
 		limit = 20 // Default limit
 	}
+	if limit > maxMatchesLimit {
+		limit = maxMatchesLimit
+	}
+
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
 
-	matches, err := h.userService.SearchMatches(userID, limit)
+	matches, err := h.userService.SearchMatches(r.Context(), userID, limit, offset)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -173,13 +188,13 @@ func (h *UserHandler) UpdateLocation(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&locationData); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		badRequest(w, r, "invalid_body", "invalid request body")
 		return
 	}
 
-	err := h.userService.UpdateLocation(userID, locationData.Latitude, locationData.Longitude)
+	err := h.userService.UpdateLocation(r.Context(), userID, locationData.Latitude, locationData.Longitude)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -187,17 +202,35 @@ func (h *UserHandler) UpdateLocation(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"message": "Location updated successfully"})
 }
 
+func (h *UserHandler) GetLocationHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := primitive.ObjectIDFromHex(vars["id"])
+	if err != nil {
+		badRequest(w, r, "invalid_id", "invalid user ID")
+		return
+	}
+
+	history, err := h.userService.GetLocationHistory(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
+
 func (h *UserHandler) StartSearching(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID, err := primitive.ObjectIDFromHex(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		badRequest(w, r, "invalid_id", "invalid user ID")
 		return
 	}
 
-	err = h.userService.StartSearching(userID)
+	err = h.userService.StartSearching(r.Context(), userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -209,13 +242,13 @@ func (h *UserHandler) StopSearching(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID, err := primitive.ObjectIDFromHex(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		badRequest(w, r, "invalid_id", "invalid user ID")
 		return
 	}
 
-	err = h.userService.StopSearching(userID)
+	err = h.userService.StopSearching(r.Context(), userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -227,13 +260,13 @@ func (h *UserHandler) FindMatch(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID, err := primitive.ObjectIDFromHex(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		badRequest(w, r, "invalid_id", "invalid user ID")
 		return
 	}
 
-	link, err := h.userService.FindMatch(userID)
+	link, err := h.userService.FindMatch(r.Context(), userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -245,13 +278,13 @@ func (h *UserHandler) RespondToLink(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID, err := primitive.ObjectIDFromHex(vars["userId"])
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		badRequest(w, r, "invalid_id", "invalid user ID")
 		return
 	}
 
 	linkID, err := primitive.ObjectIDFromHex(vars["linkId"])
 	if err != nil {
-		http.Error(w, "Invalid link ID", http.StatusBadRequest)
+		badRequest(w, r, "invalid_id", "invalid link ID")
 		return
 	}
 
@@ -259,13 +292,13 @@ func (h *UserHandler) RespondToLink(w http.ResponseWriter, r *http.Request) {
 		Accept bool `json:"accept"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&response); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		badRequest(w, r, "invalid_body", "invalid request body")
 		return
 	}
 
-	err = h.userService.RespondToLink(userID, linkID, response.Accept)
+	err = h.userService.RespondToLink(r.Context(), userID, linkID, response.Accept)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -273,17 +306,41 @@ func (h *UserHandler) RespondToLink(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"message": "Response recorded"})
 }
 
+func (h *UserHandler) GetLinkPreview(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userID, err := primitive.ObjectIDFromHex(vars["userId"])
+	if err != nil {
+		badRequest(w, r, "invalid_id", "invalid user ID")
+		return
+	}
+
+	linkID, err := primitive.ObjectIDFromHex(vars["linkId"])
+	if err != nil {
+		badRequest(w, r, "invalid_id", "invalid link ID")
+		return
+	}
+
+	preview, err := h.userService.GetLinkPreview(r.Context(), userID, linkID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
+
 func (h *UserHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID, err := primitive.ObjectIDFromHex(vars["userId"])
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		badRequest(w, r, "invalid_id", "invalid user ID")
 		return
 	}
 
 	chatroomID, err := primitive.ObjectIDFromHex(vars["chatroomId"])
 	if err != nil {
-		http.Error(w, "Invalid chatroom ID", http.StatusBadRequest)
+		badRequest(w, r, "invalid_id", "invalid chatroom ID")
 		return
 	}
 
@@ -291,13 +348,13 @@ func (h *UserHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
 		Content string `json:"content"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&messageData); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		badRequest(w, r, "invalid_body", "invalid request body")
 		return
 	}
 
-	message, err := h.userService.SendMessage(userID, chatroomID, messageData.Content)
+	message, err := h.userService.SendMessage(r.Context(), userID, chatroomID, messageData.Content)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -309,63 +366,138 @@ func (h *UserHandler) GetMessages(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID, err := primitive.ObjectIDFromHex(vars["userId"])
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		badRequest(w, r, "invalid_id", "invalid user ID")
 		return
 	}
 
 	chatroomID, err := primitive.ObjectIDFromHex(vars["chatroomId"])
 	if err != nil {
-		http.Error(w, "Invalid chatroom ID", http.StatusBadRequest)
+		badRequest(w, r, "invalid_id", "invalid chatroom ID")
 		return
 	}
 
-	messages, err := h.userService.GetMessages(userID, chatroomID)
+	messages, err := h.userService.GetMessages(r.Context(), userID, chatroomID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	json.NewEncoder(w).Encode(messages)
 }
 
-func (h *UserHandler) UnlockChatroom(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	chatroomID, err := primitive.ObjectIDFromHex(vars["chatroomId"])
-	if err != nil {
-		http.Error(w, "Invalid chatroom ID", http.StatusBadRequest)
+func (h *UserHandler) Unmatch(w http.ResponseWriter, r *http.Request) {
+	userID, chatroomID, ok := chatroomVars(w, r)
+	if !ok {
 		return
 	}
 
-	err = h.userService.UnlockChatroom(chatroomID)
+	err := h.userService.Unmatch(r.Context(), userID, chatroomID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Chatroom unlocked"})
+	json.NewEncoder(w).Encode(map[string]string{"message": "Unmatched"})
 }
 
 func (h *UserHandler) VerifyNFCAndUnlockChatroom(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	userID, err := primitive.ObjectIDFromHex(vars["userId"])
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		badRequest(w, r, "invalid_id", "invalid user ID")
 		return
 	}
 
 	chatroomID, err := primitive.ObjectIDFromHex(vars["chatroomId"])
 	if err != nil {
-		http.Error(w, "Invalid chatroom ID", http.StatusBadRequest)
+		badRequest(w, r, "invalid_id", "invalid chatroom ID")
 		return
 	}
 
-	err = h.userService.VerifyNFCAndUnlockChatroom(userID, chatroomID)
+	if _, ok := h.decodeTap(w, r, userID); !ok {
+		return
+	}
+
+	err = h.userService.VerifyNFCAndUnlockChatroom(r.Context(), userID, chatroomID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Chatroom unlocked via NFC"})
 }
+
+type tapRequest struct {
+	Token     string   `json:"token"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+}
+
+// decodeTap reads the optional body sent with an unlock attempt. A tap may
+// carry the phone's current position so the proximity check doesn't depend
+// on an earlier location update.
+func (h *UserHandler) decodeTap(w http.ResponseWriter, r *http.Request, userID primitive.ObjectID) (*tapRequest, bool) {
+	var tap tapRequest
+	if err := json.NewDecoder(r.Body).Decode(&tap); err != nil && err != io.EOF {
+		badRequest(w, r, "invalid_body", "invalid request body")
+		return nil, false
+	}
+
+	if tap.Latitude != nil && tap.Longitude != nil {
+		err := h.userService.UpdateLocation(r.Context(), userID.Hex(), *tap.Latitude, *tap.Longitude)
+		if err != nil {
+			writeError(w, r, err)
+			return nil, false
+		}
+	}
+
+	return &tap, true
+}
+
+func (h *UserHandler) IssueUnlockToken(w http.ResponseWriter, r *http.Request) {
+	userID, chatroomID, ok := chatroomVars(w, r)
+	if !ok {
+		return
+	}
+
+	if _, ok := h.decodeTap(w, r, userID); !ok {
+		return
+	}
+
+	token, err := h.userService.IssueUnlockToken(r.Context(), userID, chatroomID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(token)
+}
+
+func (h *UserHandler) RedeemUnlockToken(w http.ResponseWriter, r *http.Request) {
+	userID, chatroomID, ok := chatroomVars(w, r)
+	if !ok {
+		return
+	}
+
+	tap, ok := h.decodeTap(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if tap.Token == "" {
+		badRequest(w, r, "missing_token", "missing unlock token")
+		return
+	}
+
+	if err := h.userService.RedeemUnlockToken(r.Context(), userID, chatroomID, tap.Token); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Chatroom unlocked via QR code"})
+}
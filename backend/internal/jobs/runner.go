@@ -0,0 +1,273 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+)
+
+var tracer = otel.Tracer("github.com/seunghoon34/linkapp/backend/internal/jobs")
+
+// Job is a named background task run every Interval, plus up to Jitter of
+// random delay so instances don't all wake at once. Only the instance holding
+// the job's lease runs it.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Jitter   time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// leaseTTL outlives the gap between two runs, so the leader keeps its lease
+// from one run to the next and a crashed leader is replaced within two gaps.
+func (j *Job) leaseTTL() time.Duration {
+	ttl := 2*j.Interval + j.Jitter
+	if ttl < 15*time.Second {
+		ttl = 15 * time.Second
+	}
+	return ttl
+}
+
+func (j *Job) nextDelay() time.Duration {
+	if j.Jitter <= 0 {
+		return j.Interval
+	}
+	return j.Interval + time.Duration(rand.Int63n(int64(j.Jitter)))
+}
+
+// Status is how a job has been doing on this instance.
+type Status struct {
+	Name          string        `json:"name"`
+	Interval      time.Duration `json:"interval"`
+	Leader        bool          `json:"leader"`
+	Runs          int           `json:"runs"`
+	Failures      int           `json:"failures"`
+	LastTickAt    time.Time     `json:"last_tick_at,omitempty"`
+	LastRunAt     time.Time     `json:"last_run_at,omitempty"`
+	LastSuccessAt time.Time     `json:"last_success_at,omitempty"`
+	LastDuration  time.Duration `json:"last_duration"`
+	LastError     string        `json:"last_error,omitempty"`
+}
+
+type Runner struct {
+	leases repository.LeaseStore
+	runs   repository.JobRunStore
+	holder string
+
+	mu        sync.Mutex
+	jobs      []*Job
+	status    map[string]*Status
+	startedAt time.Time
+	running   sync.WaitGroup
+}
+
+// NewRunner creates a runner that takes leases and records runs as holder,
+// which must be unique to this instance.
+func NewRunner(leases repository.LeaseStore, runs repository.JobRunStore, holder string) *Runner {
+	return &Runner{
+		leases: leases,
+		runs:   runs,
+		holder: holder,
+		status: make(map[string]*Status),
+	}
+}
+
+func (r *Runner) Holder() string {
+	return r.holder
+}
+
+func (r *Runner) Register(job Job) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.jobs = append(r.jobs, &job)
+	r.status[job.Name] = &Status{Name: job.Name, Interval: job.Interval}
+}
+
+// Start runs every registered job in its own goroutine until ctx is done.
+func (r *Runner) Start(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.startedAt = time.Now()
+	for _, job := range r.jobs {
+		r.running.Add(1)
+		go r.loop(ctx, job)
+	}
+}
+
+// Wait blocks until every job has stopped after Start's ctx is done, which
+// includes finishing the run in progress and releasing the job's lease.
+func (r *Runner) Wait() {
+	r.running.Wait()
+}
+
+func (r *Runner) loop(ctx context.Context, job *Job) {
+	defer r.running.Done()
+	// ctx is done by the time the lease is released
+	defer r.leases.Release(context.WithoutCancel(ctx), job.Name, r.holder)
+
+	// Run straight away so a new leader doesn't wait a full interval
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			r.tick(ctx, job)
+			timer.Reset(job.nextDelay())
+		}
+	}
+}
+
+func (r *Runner) tick(ctx context.Context, job *Job) {
+	r.mu.Lock()
+	r.status[job.Name].LastTickAt = time.Now()
+	r.mu.Unlock()
+
+	leader, err := r.leases.Acquire(ctx, job.Name, r.holder, job.leaseTTL())
+	if err != nil {
+		slog.ErrorContext(ctx, "acquiring job lease failed", "job", job.Name, "error", err)
+		leader = false
+	}
+
+	r.mu.Lock()
+	r.status[job.Name].Leader = leader
+	r.mu.Unlock()
+
+	if !leader {
+		return
+	}
+
+	run := &model.JobRun{
+		Job:       job.Name,
+		Holder:    r.holder,
+		StartedAt: time.Now(),
+	}
+	err = r.safeRun(ctx, job)
+	run.FinishedAt = time.Now()
+
+	r.mu.Lock()
+	status := r.status[job.Name]
+	status.Runs++
+	status.LastRunAt = run.StartedAt
+	status.LastDuration = run.FinishedAt.Sub(run.StartedAt)
+	if err != nil {
+		run.Error = err.Error()
+		status.Failures++
+		status.LastError = run.Error
+	} else {
+		status.LastSuccessAt = run.FinishedAt
+		status.LastError = ""
+	}
+	r.mu.Unlock()
+
+	if err != nil {
+		slog.ErrorContext(ctx, "job failed", "job", job.Name, "duration", status.LastDuration.String(), "error", err)
+	} else {
+		slog.DebugContext(ctx, "job ran", "job", job.Name, "duration", status.LastDuration.String())
+	}
+
+	if err := r.runs.Record(context.WithoutCancel(ctx), run); err != nil {
+		slog.ErrorContext(ctx, "recording job run failed", "job", job.Name, "error", err)
+	}
+}
+
+// safeRun turns a panic in the job into an error so one bad run doesn't
+// take the process down. Each run is a trace of its own.
+func (r *Runner) safeRun(ctx context.Context, job *Job) (err error) {
+	ctx, span := tracer.Start(ctx, "job "+job.Name)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	return job.Run(ctx)
+}
+
+// Status returns a snapshot of every job's status on this instance.
+func (r *Runner) Status() []Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	statuses := make([]Status, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		statuses = append(statuses, *r.status[job.Name])
+	}
+	return statuses
+}
+
+// Stalled lists the jobs whose loop hasn't ticked for longer than their
+// lease lasts, which means it's stuck in a run or has stopped. Nothing has
+// stalled before Start.
+func (r *Runner) Stalled(now time.Time) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.startedAt.IsZero() {
+		return nil
+	}
+
+	var stalled []string
+	for _, job := range r.jobs {
+		last := r.status[job.Name].LastTickAt
+		if last.Before(r.startedAt) {
+			last = r.startedAt
+		}
+		if now.Sub(last) > job.leaseTTL() {
+			stalled = append(stalled, job.Name)
+		}
+	}
+	return stalled
+}
+
+// JobReport combines this instance's view of a job with the lease and the
+// run history shared by all instances.
+type JobReport struct {
+	Status
+	Lease   *model.Lease    `json:"lease"`
+	History []*model.JobRun `json:"history"`
+}
+
+func (r *Runner) Report(ctx context.Context, historyLimit int) ([]JobReport, error) {
+	var reports []JobReport
+	for _, status := range r.Status() {
+		lease, err := r.leases.Get(ctx, status.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		history, err := r.runs.Recent(ctx, status.Name, historyLimit)
+		if err != nil {
+			return nil, err
+		}
+
+		reports = append(reports, JobReport{
+			Status:  status,
+			Lease:   lease,
+			History: history,
+		})
+	}
+
+	return reports, nil
+}
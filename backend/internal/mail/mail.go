@@ -0,0 +1,151 @@
+// Package mail sends the emails the API needs, such as email verification
+// and password reset links, over SMTP or, for local use, to the log or a
+// file.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	netmail "net/mail"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backends Options.Backend can name.
+const (
+	BackendLog  = "log"
+	BackendFile = "file"
+	BackendSMTP = "smtp"
+)
+
+// Options says how mail is sent and who it comes from.
+type Options struct {
+	// Backend is log, file or smtp
+	Backend string
+	// From is the sender, such as "LinkApp <no-reply@example.com>"
+	From string
+	// File is where the file backend appends messages
+	File string
+	SMTP SMTPOptions
+}
+
+var DefaultOptions = Options{
+	Backend: BackendLog,
+	From:    "LinkApp <no-reply@linkapp.local>",
+	SMTP:    SMTPOptions{Port: 587},
+}
+
+// Message is a plain text email to one recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Discard is a Mailer that drops every message.
+var Discard Mailer = discard{}
+
+type discard struct{}
+
+func (discard) Send(ctx context.Context, msg Message) error {
+	return nil
+}
+
+// Open creates the configured Mailer. The returned function releases what
+// it holds, if anything.
+func Open(opts Options) (Mailer, func() error, error) {
+	from, err := netmail.ParseAddress(opts.From)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing sender %q: %w", opts.From, err)
+	}
+
+	switch opts.Backend {
+	case BackendLog:
+		return NewLogMailer(), func() error { return nil }, nil
+	case BackendFile:
+		f, err := os.OpenFile(opts.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, nil, err
+		}
+		return NewFileMailer(f, from), f.Close, nil
+	case BackendSMTP:
+		return NewSMTPMailer(opts.SMTP, from), func() error { return nil }, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown mail backend %q", opts.Backend)
+	}
+}
+
+// LogMailer logs messages instead of sending them, for local use. The
+// recipient is redacted like every email in the logs, but the body isn't,
+// so links in it can be followed.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	slog.InfoContext(ctx, "email", "email", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
+
+// FileMailer appends each message, headers and all, to w, for local use
+// and for tests that need to read what was sent.
+type FileMailer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	from *netmail.Address
+}
+
+func NewFileMailer(w io.Writer, from *netmail.Address) *FileMailer {
+	return &FileMailer{w: w, from: from}
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	data, err := format(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err = fmt.Fprintf(m.w, "%s\r\n\r\n", data)
+	return err
+}
+
+// format renders msg as an RFC 5322 message.
+func format(from *netmail.Address, msg Message, date time.Time) ([]byte, error) {
+	// Line breaks in a header would let its value add headers of its own
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return nil, errors.New("mail: line break in header")
+	}
+	to, err := netmail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, fmt.Errorf("parsing recipient: %w", err)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,92 @@
+package mail_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/seunghoon34/linkapp/backend/internal/mail"
+)
+
+// TestSMTPMailer sends through the SMTP server at SMTP_TEST_ADDR, such as a
+// local MailHog on localhost:1025, and is skipped if the variable isn't set.
+// With MAILHOG_URL set too, it checks MailHog received the message.
+func TestSMTPMailer(t *testing.T) {
+	addr := os.Getenv("SMTP_TEST_ADDR")
+	if addr == "" {
+		t.Skip("SMTP_TEST_ADDR isn't set")
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("parsing SMTP_TEST_ADDR: %v", err)
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatalf("parsing SMTP_TEST_ADDR: %v", err)
+	}
+
+	from := &netmail.Address{Name: "LinkApp", Address: "no-reply@linkapp.local"}
+	mailer := mail.NewSMTPMailer(mail.SMTPOptions{Host: host, Port: portNumber}, from)
+
+	// A fresh recipient so the check below finds only this message
+	to := "test-" + primitive.NewObjectID().Hex() + "@example.com"
+	msg := mail.Message{
+		To:      to,
+		Subject: "Verify your email ✓",
+		Body:    "Follow the link:\nhttps://linkapp.example/verify-email?token=abc",
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mailer.Send(ctx, msg); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	mailhog := os.Getenv("MAILHOG_URL")
+	if mailhog == "" {
+		return
+	}
+
+	res, err := http.Get(mailhog + "/api/v2/search?kind=to&query=" + url.QueryEscape(to))
+	if err != nil {
+		t.Fatalf("searching MailHog: %v", err)
+	}
+	defer res.Body.Close()
+
+	var found struct {
+		Items []struct {
+			Content struct {
+				Headers map[string][]string
+				Body    string
+			}
+		}
+	}
+	if err := json.NewDecoder(res.Body).Decode(&found); err != nil {
+		t.Fatalf("decoding MailHog search: %v", err)
+	}
+	if len(found.Items) != 1 {
+		t.Fatalf("MailHog has %d messages to %s, want 1", len(found.Items), to)
+	}
+
+	content := found.Items[0].Content
+	if got := content.Headers["From"]; len(got) != 1 || !strings.Contains(got[0], "no-reply@linkapp.local") {
+		t.Errorf("From = %v, want the sender", got)
+	}
+	if got := content.Headers["Subject"]; len(got) != 1 || got[0] != "=?utf-8?q?Verify_your_email_=E2=9C=93?=" {
+		t.Errorf("Subject = %v, want it Q-encoded", got)
+	}
+	if !strings.Contains(content.Body, "verify-email?token=abc") || !strings.Contains(content.Body, "\r\n") {
+		t.Errorf("body %q doesn't have the link on CRLF-separated lines", content.Body)
+	}
+}
@@ -0,0 +1,127 @@
+// Package metrics holds the Prometheus collectors the API exports on
+// /metrics. Collectors live on the default registry, next to the Go runtime
+// and process metrics the client library registers there.
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "linkapp"
+
+// Link outcomes counted by Links.
+const (
+	LinkCreated  = "created"
+	LinkAccepted = "accepted"
+	LinkRejected = "rejected"
+	LinkExpired  = "expired"
+)
+
+// Unlock methods counted by UnlockAttempts.
+const (
+	UnlockNFC = "nfc"
+	UnlockQR  = "qr"
+)
+
+// waitBuckets cover waits from a few seconds up to a day, for the human
+// paced parts of the flow.
+var waitBuckets = prometheus.ExponentialBuckets(5, 2.5, 11)
+
+var (
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to serve HTTP requests, by route template.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	Links = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "links_total",
+		Help:      "Links between users, by what happened to them.",
+	}, []string{"outcome"})
+
+	TimeToMatch = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "time_to_match_seconds",
+		Help:      "Time users spent searching before being linked.",
+		Buckets:   waitBuckets,
+	})
+
+	TimeToUnlock = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "time_to_unlock_seconds",
+		Help:      "Time from a chatroom opening to its users unlocking it in person.",
+		Buckets:   waitBuckets,
+	})
+
+	MessagesSent = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_sent_total",
+		Help:      "Chat messages sent.",
+	})
+
+	UnlockAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "unlock_attempts_total",
+		Help:      "In-person unlock attempts, by method and outcome.",
+	}, []string{"method", "outcome"})
+
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests turned away by rate limits, by route and whether the IP or user limit was hit.",
+	}, []string{"route", "key"})
+
+	SecurityEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "security_events_total",
+		Help:      "Security events such as failed logins and lockouts, by type.",
+	}, []string{"type"})
+
+	DBOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_operation_duration_seconds",
+		Help:      "Time taken by storage calls, by store and repository method.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"store", "method"})
+)
+
+// searcherCountTimeout bounds the count behind the searchers gauge, so a
+// slow database can't hold up a scrape.
+const searcherCountTimeout = 5 * time.Second
+
+// RegisterSearchers exports the number of users searching right now, read
+// from the database on each scrape so every instance reports the same
+// figure.
+func RegisterSearchers(count func(ctx context.Context) (int64, error)) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_searchers",
+		Help:      "Users currently searching for a match.",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), searcherCountTimeout)
+		defer cancel()
+
+		n, err := count(ctx)
+		if err != nil {
+			slog.Error("counting searching users failed", "error", err)
+			return math.NaN()
+		}
+		return float64(n)
+	})
+}
+
+// ObserveSince records the time elapsed since start, if start is known.
+func ObserveSince(h prometheus.Observer, start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	h.Observe(time.Since(start).Seconds())
+}
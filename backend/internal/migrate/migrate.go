@@ -0,0 +1,179 @@
+// Package migrate applies versioned schema migrations to Postgres and
+// MongoDB and records which versions each database has applied.
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Migration is one versioned, reversible schema change.
+type Migration struct {
+	Version int
+	Name    string
+}
+
+// Target is a database migrations can be applied to.
+type Target interface {
+	// Migrations lists every known migration, oldest first.
+	Migrations() []Migration
+	// Applied returns the versions recorded as applied. It only reads, so
+	// it's safe in a dry run or on a database never migrated before.
+	Applied(ctx context.Context) (map[int]bool, error)
+	// Up applies the migration and records it in one step where the
+	// database allows.
+	Up(ctx context.Context, m Migration) error
+	// Down reverts the migration and forgets it.
+	Down(ctx context.Context, m Migration) error
+}
+
+type Migrator struct {
+	target Target
+	dryRun bool
+	out    io.Writer
+}
+
+// NewMigrator creates a migrator that reports progress to out. In dry-run
+// mode it only reports what it would do.
+func NewMigrator(target Target, dryRun bool, out io.Writer) *Migrator {
+	return &Migrator{
+		target: target,
+		dryRun: dryRun,
+		out:    out,
+	}
+}
+
+// Up applies up to steps pending migrations in version order, or all of them
+// if steps is zero.
+func (m *Migrator) Up(ctx context.Context, steps int) error {
+	pending, err := Pending(ctx, m.target)
+	if err != nil {
+		return err
+	}
+	if steps > 0 && steps < len(pending) {
+		pending = pending[:steps]
+	}
+
+	if len(pending) == 0 {
+		fmt.Fprintln(m.out, "No pending migrations")
+		return nil
+	}
+
+	for _, migration := range pending {
+		if err := m.run(ctx, "up", migration, m.target.Up); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Pending lists the target's migrations that haven't been applied yet,
+// oldest first.
+func Pending(ctx context.Context, target Target) ([]Migration, error) {
+	applied, err := target.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, migration := range sorted(target.Migrations()) {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// CheckApplied returns an error if any of the target's migrations is still
+// pending, so the API doesn't start against a schema older than it expects.
+// Migrations it doesn't know about, from a newer build, are fine.
+func CheckApplied(ctx context.Context, target Target) error {
+	pending, err := Pending(ctx, target)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d migrations are pending, starting with %06d %s; run cmd/migrate up first",
+			len(pending), pending[0].Version, pending[0].Name)
+	}
+
+	return nil
+}
+
+// Down reverts up to steps applied migrations, newest first. Zero reverts
+// just the latest one, so a whole schema is never dropped by accident.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	if steps <= 0 {
+		steps = 1
+	}
+
+	applied, err := m.target.Applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	migrations := sorted(m.target.Migrations())
+	var reverting []Migration
+	for i := len(migrations) - 1; i >= 0 && len(reverting) < steps; i-- {
+		if applied[migrations[i].Version] {
+			reverting = append(reverting, migrations[i])
+		}
+	}
+
+	if len(reverting) == 0 {
+		fmt.Fprintln(m.out, "No applied migrations")
+		return nil
+	}
+
+	for _, migration := range reverting {
+		if err := m.run(ctx, "down", migration, m.target.Down); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Status prints every migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) error {
+	applied, err := m.target.Applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range sorted(m.target.Migrations()) {
+		state := "pending"
+		if applied[migration.Version] {
+			state = "applied"
+		}
+		fmt.Fprintf(m.out, "%06d %-40s %s\n", migration.Version, migration.Name, state)
+	}
+
+	return nil
+}
+
+func (m *Migrator) run(ctx context.Context, direction string, migration Migration, apply func(context.Context, Migration) error) error {
+	if m.dryRun {
+		fmt.Fprintf(m.out, "Would migrate %s %06d %s\n", direction, migration.Version, migration.Name)
+		return nil
+	}
+
+	fmt.Fprintf(m.out, "Migrating %s %06d %s\n", direction, migration.Version, migration.Name)
+	if err := apply(ctx, migration); err != nil {
+		return fmt.Errorf("migration %06d %s %s: %w", migration.Version, migration.Name, direction, err)
+	}
+
+	return nil
+}
+
+func sorted(migrations []Migration) []Migration {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	return sorted
+}
@@ -0,0 +1,286 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+)
+
+// mongoIndex is an index on one collection. Names match the ones MongoDB
+// generates from the keys, so indexes created before migrations existed are
+// recognised rather than duplicated.
+type mongoIndex struct {
+	collection string
+	name       string
+	keys       bson.D
+	options    *options.IndexOptions
+}
+
+// mongoMigration creates its indexes on the way up and drops them on the way
+// down. Data changes go in up and down, which run after the indexes are
+// created and before they're dropped.
+type mongoMigration struct {
+	Migration
+	indexes []mongoIndex
+	up      func(ctx context.Context, db *mongo.Database) error
+	down    func(ctx context.Context, db *mongo.Database) error
+}
+
+func ttl(d time.Duration) *options.IndexOptions {
+	return options.Index().SetExpireAfterSeconds(int32(d.Seconds()))
+}
+
+var mongoMigrations = []mongoMigration{
+	{
+		Migration: Migration{Version: 1, Name: "create_geo_and_retention_indexes"},
+		indexes: []mongoIndex{
+			{collection: "users", name: "location_2dsphere", keys: bson.D{{Key: "location", Value: "2dsphere"}}},
+			{collection: "location_history", name: "created_at_1", keys: bson.D{{Key: "created_at", Value: 1}}, options: ttl(repository.LocationHistoryRetention)},
+			{collection: "location_history", name: "user_id_1_created_at_-1", keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{collection: "chatroom_locations", name: "updated_at_1", keys: bson.D{{Key: "updated_at", Value: 1}}, options: ttl(repository.SharedLocationRetention)},
+			{collection: "job_runs", name: "started_at_1", keys: bson.D{{Key: "started_at", Value: 1}}, options: ttl(repository.JobRunRetention)},
+			{collection: "job_runs", name: "job_1_started_at_-1", keys: bson.D{{Key: "job", Value: 1}, {Key: "started_at", Value: -1}}},
+		},
+	},
+	{
+		Migration: Migration{Version: 2, Name: "create_user_unique_indexes"},
+		indexes: []mongoIndex{
+			{collection: "users", name: repository.UserEmailIndex, keys: bson.D{{Key: "email", Value: 1}}, options: options.Index().SetUnique(true)},
+			{collection: "users", name: repository.UserUsernameIndex, keys: bson.D{{Key: "username", Value: 1}}, options: options.Index().SetUnique(true)},
+		},
+	},
+	{
+		Migration: Migration{Version: 3, Name: "create_link_indexes"},
+		indexes: []mongoIndex{
+			{collection: "links", name: "status_1_expires_at_1", keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}},
+		},
+	},
+	{
+		Migration: Migration{Version: 4, Name: "create_chat_indexes"},
+		indexes: []mongoIndex{
+			{collection: "messages", name: "chatroom_id_1_created_at_1", keys: bson.D{{Key: "chatroom_id", Value: 1}, {Key: "created_at", Value: 1}}},
+			{collection: "chatrooms", name: "user_a_id_1", keys: bson.D{{Key: "user_a_id", Value: 1}}},
+			{collection: "chatrooms", name: "user_b_id_1", keys: bson.D{{Key: "user_b_id", Value: 1}}},
+			{collection: "chatroom_locations", name: "chatroom_id_1_user_id_1", keys: bson.D{{Key: "chatroom_id", Value: 1}, {Key: "user_id", Value: 1}}, options: options.Index().SetUnique(true)},
+		},
+	},
+	{
+		// Emails are stored trimmed and lowercased from now on; bring existing
+		// accounts in line so they can still log in. Fails on accounts that
+		// only differed by case, which have to be merged by hand. The original
+		// spelling isn't kept, so there's nothing to undo.
+		Migration: Migration{Version: 5, Name: "normalize_user_emails"},
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("users").UpdateMany(ctx, bson.M{}, mongo.Pipeline{
+				{{Key: "$set", Value: bson.M{
+					"email": bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$email"}}},
+				}}},
+			})
+			return err
+		},
+	},
+	{
+		// Backs the count of searching users behind the active searchers metric
+		Migration: Migration{Version: 6, Name: "create_user_state_index"},
+		indexes: []mongoIndex{
+			{collection: "users", name: "state_1", keys: bson.D{{Key: "state", Value: 1}}},
+		},
+	},
+	{
+		// Rate limit buckets go once they've refilled, when they'd behave the
+		// same as missing ones
+		Migration: Migration{Version: 7, Name: "create_rate_limit_ttl_index"},
+		indexes: []mongoIndex{
+			{collection: "rate_limits", name: "expires_at_1", keys: bson.D{{Key: "expires_at", Value: 1}}, options: ttl(0)},
+		},
+	},
+	{
+		Migration: Migration{Version: 8, Name: "create_login_security_indexes"},
+		indexes: []mongoIndex{
+			{collection: "login_failures", name: "expires_at_1", keys: bson.D{{Key: "expires_at", Value: 1}}, options: ttl(0)},
+			{collection: "security_events", name: "created_at_1", keys: bson.D{{Key: "created_at", Value: 1}}, options: ttl(repository.SecurityEventRetention)},
+			{collection: "security_events", name: "user_id_1_created_at_-1", keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+	},
+	{
+		// Unverified users can't search. Accounts made before emails were
+		// verified are taken as verified rather than shut out.
+		Migration: Migration{Version: 9, Name: "verify_existing_emails"},
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("users").UpdateMany(ctx,
+				bson.M{"email_verified_at": bson.M{"$exists": false}},
+				mongo.Pipeline{{{Key: "$set", Value: bson.M{"email_verified_at": "$created_at"}}}},
+			)
+			return err
+		},
+		down: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("users").UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"email_verified_at": ""}})
+			return err
+		},
+	},
+	{
+		// Usernames are stored trimmed and in NFKC form from now on, like
+		// service.NormalizeUsername makes them, so the unique index also
+		// catches names that only differ in encoding. MongoDB can't
+		// normalize Unicode itself, so each changed name is rewritten here.
+		// Fails on names that collide once normalized, which have to be
+		// renamed by hand. The original spelling isn't kept, so there's
+		// nothing to undo.
+		Migration: Migration{Version: 10, Name: "normalize_usernames"},
+		up: func(ctx context.Context, db *mongo.Database) error {
+			users := db.Collection("users")
+			cursor, err := users.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"username": 1}))
+			if err != nil {
+				return err
+			}
+			defer cursor.Close(ctx)
+
+			for cursor.Next(ctx) {
+				var user struct {
+					ID       interface{} `bson:"_id"`
+					Username string      `bson:"username"`
+				}
+				if err := cursor.Decode(&user); err != nil {
+					return err
+				}
+
+				normalized := norm.NFKC.String(strings.TrimSpace(user.Username))
+				if normalized == user.Username {
+					continue
+				}
+				_, err := users.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"username": normalized}})
+				if err != nil {
+					return fmt.Errorf("normalizing username %q: %w", user.Username, err)
+				}
+			}
+
+			return cursor.Err()
+		},
+	},
+	{
+		// Location events only matter while they're being delivered
+		Migration: Migration{Version: 11, Name: "create_location_event_ttl_index"},
+		indexes: []mongoIndex{
+			{collection: "location_events", name: "created_at_1", keys: bson.D{{Key: "created_at", Value: 1}}, options: ttl(repository.LocationEventRetention)},
+		},
+	},
+}
+
+// Mongo applies the migrations above, recording applied versions in
+// the schema_migrations collection.
+type Mongo struct {
+	db *mongo.Database
+}
+
+func NewMongo(db *mongo.Database) *Mongo {
+	return &Mongo{db: db}
+}
+
+func (m *Mongo) Migrations() []Migration {
+	migrations := make([]Migration, len(mongoMigrations))
+	for i, migration := range mongoMigrations {
+		migrations[i] = migration.Migration
+	}
+	return migrations
+}
+
+func (m *Mongo) Applied(ctx context.Context) (map[int]bool, error) {
+	cursor, err := m.db.Collection("schema_migrations").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []struct {
+		Version int `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]bool)
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+
+	return applied, nil
+}
+
+// Up creates the migration's indexes. Creating an index that already exists
+// with the same keys and options is a no-op, so a migration that failed
+// halfway can simply be run again.
+func (m *Mongo) Up(ctx context.Context, migration Migration) error {
+	for _, index := range lookup(migration).indexes {
+		opts := index.options
+		if opts == nil {
+			opts = options.Index()
+		}
+
+		_, err := m.db.Collection(index.collection).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    index.keys,
+			Options: opts.SetName(index.name),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if up := lookup(migration).up; up != nil {
+		if err := up(ctx, m.db); err != nil {
+			return err
+		}
+	}
+
+	_, err := m.db.Collection("schema_migrations").InsertOne(ctx, bson.M{
+		"_id":        migration.Version,
+		"name":       migration.Name,
+		"applied_at": time.Now(),
+	})
+	return err
+}
+
+func (m *Mongo) Down(ctx context.Context, migration Migration) error {
+	if down := lookup(migration).down; down != nil {
+		if err := down(ctx, m.db); err != nil {
+			return err
+		}
+	}
+
+	for _, index := range lookup(migration).indexes {
+		_, err := m.db.Collection(index.collection).Indexes().DropOne(ctx, index.name)
+		if err != nil && !isIndexNotFound(err) {
+			return err
+		}
+	}
+
+	_, err := m.db.Collection("schema_migrations").DeleteOne(ctx, bson.M{"_id": migration.Version})
+	return err
+}
+
+func lookup(migration Migration) mongoMigration {
+	for _, m := range mongoMigrations {
+		if m.Version == migration.Version {
+			return m
+		}
+	}
+	return mongoMigration{Migration: migration}
+}
+
+// isIndexNotFound reports whether dropping failed only because the index or
+// its collection doesn't exist, which leaves nothing to undo.
+func isIndexNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code == 27 || cmdErr.Code == 26 // IndexNotFound, NamespaceNotFound
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strconv"
+)
+
+// Postgres applies the SQL migrations in a directory laid out as
+// NNNNNN_name.up.sql and NNNNNN_name.down.sql, recording applied versions in
+// the schema_migrations table.
+type Postgres struct {
+	db         *sql.DB
+	migrations []Migration
+	up         map[int]string
+	down       map[int]string
+}
+
+var sqlFilePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// NewPostgres reads the migrations in dir. Every version needs both an up
+// and a down file.
+func NewPostgres(db *sql.DB, dir fs.FS) (*Postgres, error) {
+	p := &Postgres{
+		db:   db,
+		up:   make(map[int]string),
+		down: make(map[int]string),
+	}
+
+	entries, err := fs.ReadDir(dir, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[int]string)
+	for _, entry := range entries {
+		match := sqlFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+		if name, ok := names[version]; ok && name != match[2] {
+			return nil, fmt.Errorf("migration %06d has two names: %s and %s", version, name, match[2])
+		}
+		names[version] = match[2]
+
+		contents, err := fs.ReadFile(dir, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			p.up[version] = string(contents)
+		} else {
+			p.down[version] = string(contents)
+		}
+	}
+
+	for version, name := range names {
+		if _, ok := p.up[version]; !ok {
+			return nil, fmt.Errorf("migration %06d %s has no up file", version, name)
+		}
+		if _, ok := p.down[version]; !ok {
+			return nil, fmt.Errorf("migration %06d %s has no down file", version, name)
+		}
+		p.migrations = append(p.migrations, Migration{Version: version, Name: name})
+	}
+	p.migrations = sorted(p.migrations)
+
+	return p, nil
+}
+
+func (p *Postgres) Migrations() []Migration {
+	return p.migrations
+}
+
+// Applied reads schema_migrations. A database without the table has had
+// nothing applied; the table is only created by the first Up.
+func (p *Postgres) Applied(ctx context.Context) (map[int]bool, error) {
+	var exists bool
+	err := p.db.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return map[int]bool{}, nil
+	}
+
+	rows, err := p.db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+
+	return applied, rows.Err()
+}
+
+// Up runs the migration and records it in the same transaction, so a failed
+// migration leaves nothing behind.
+func (p *Postgres) Up(ctx context.Context, m Migration) error {
+	return p.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS schema_migrations (
+				version BIGINT PRIMARY KEY,
+				name TEXT NOT NULL,
+				applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, p.up[m.Version]); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`,
+			m.Version, m.Name,
+		)
+		return err
+	})
+}
+
+func (p *Postgres) Down(ctx context.Context, m Migration) error {
+	return p.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, p.down[m.Version]); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+		return err
+	})
+}
+
+func (p *Postgres) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -1,21 +1,41 @@
 package model
 
 import (
+	"log/slog"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Chatroom struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	LinkID    primitive.ObjectID `bson:"link_id" json:"link_id"`
-	UserAID   primitive.ObjectID `bson:"user_a_id" json:"user_a_id"`
-	UserBID   primitive.ObjectID `bson:"user_b_id" json:"user_b_id"`
-	IsLocked  bool               `bson:"is_locked" json:"is_locked"`
-	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+	ID               primitive.ObjectID   `bson:"_id,omitempty" json:"id,omitempty"`
+	LinkID           primitive.ObjectID   `bson:"link_id" json:"link_id"`
+	UserAID          primitive.ObjectID   `bson:"user_a_id" json:"user_a_id"`
+	UserBID          primitive.ObjectID   `bson:"user_b_id" json:"user_b_id"`
+	IsLocked         bool                 `bson:"is_locked" json:"is_locked"`
+	LocationPausedBy []primitive.ObjectID `bson:"location_paused_by,omitempty" json:"location_paused_by,omitempty"`
+	UnlockTaps       map[string]time.Time `bson:"unlock_taps,omitempty" json:"-"`
+	UsedUnlockNonces []string             `bson:"used_unlock_nonces,omitempty" json:"-"`
+	Status           ChatroomStatus       `bson:"status" json:"status"`
+	ClosedAt         time.Time            `bson:"closed_at,omitempty" json:"closed_at,omitempty"`
+	CreatedAt        time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt        time.Time            `bson:"updated_at" json:"updated_at"`
 }
 
+// IsClosed reports whether the chatroom has expired or been unmatched. Closed
+// chatrooms are read-only.
+func (c *Chatroom) IsClosed() bool {
+	return !c.ClosedAt.IsZero()
+}
+
+type ChatroomStatus string
+
+const (
+	ChatroomStatusActive    ChatroomStatus = "active"
+	ChatroomStatusExpired   ChatroomStatus = "expired"
+	ChatroomStatusUnmatched ChatroomStatus = "unmatched"
+)
+
 type Message struct {
 	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	ChatroomID primitive.ObjectID `bson:"chatroom_id" json:"chatroom_id"`
@@ -23,3 +43,28 @@ type Message struct {
 	Content    string             `bson:"content" json:"content"`
 	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
 }
+
+// LogValue keeps what was said out of the logs.
+func (m Message) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", m.ID.Hex()),
+		slog.String("chatroom_id", m.ChatroomID.Hex()),
+		slog.String("sender_id", m.SenderID.Hex()),
+	)
+}
+
+type SharedLocation struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	ChatroomID primitive.ObjectID `bson:"chatroom_id" json:"chatroom_id"`
+	UserID     primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Location   GeoLocation        `bson:"location" json:"location"`
+	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// LogValue keeps the position itself out of the logs.
+func (l SharedLocation) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("chatroom_id", l.ChatroomID.Hex()),
+		slog.String("user_id", l.UserID.Hex()),
+	)
+}
@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// Lease gives one API instance exclusive ownership of a named background job
+// until ExpiresAt, unless the holder renews it first.
+type Lease struct {
+	Name      string    `bson:"_id" json:"name"`
+	Holder    string    `bson:"holder" json:"holder"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+	RenewedAt time.Time `bson:"renewed_at" json:"renewed_at"`
+}
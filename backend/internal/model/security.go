@@ -0,0 +1,37 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LoginFailures counts the failed logins in a row for one key, such as an
+// account or an IP address. The count is forgotten at ExpiresAt.
+type LoginFailures struct {
+	Key           string    `bson:"_id" json:"key"`
+	Failures      int       `bson:"failures" json:"failures"`
+	LastFailureAt time.Time `bson:"last_failure_at" json:"last_failure_at"`
+	ExpiresAt     time.Time `bson:"expires_at" json:"expires_at"`
+}
+
+// SecurityEvent is an entry in the security audit trail.
+type SecurityEvent struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Type   SecurityEventType  `bson:"type" json:"type"`
+	UserID primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	IP     string             `bson:"ip" json:"ip"`
+	// Failures is the failure count that led to the event, if any
+	Failures  int       `bson:"failures,omitempty" json:"failures,omitempty"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+type SecurityEventType string
+
+const (
+	SecurityEventLoginFailed    SecurityEventType = "login_failed"
+	SecurityEventLoginThrottled SecurityEventType = "login_throttled"
+	SecurityEventAccountLocked  SecurityEventType = "account_locked"
+	SecurityEventIPBlocked      SecurityEventType = "ip_blocked"
+	SecurityEventPasswordReset  SecurityEventType = "password_reset"
+)
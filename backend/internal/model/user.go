@@ -1,30 +1,78 @@
 package model
 
 import (
+	"log/slog"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type User struct {
-	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	Username      string             `bson:"username" json:"username"`
-	Email         string             `bson:"email" json:"email"`
-	Password      string             `bson:"password" json:"-"`
-	Profile       Profile            `bson:"profile" json:"profile"`
-	Preferences   Preferences        `bson:"preferences" json:"preferences"`
-	Location      GeoLocation        `bson:"location" json:"location"`
-	IsSearching   bool               `bson:"is_searching" json:"is_searching"`
-	CurrentLinkID primitive.ObjectID `bson:"current_link_id,omitempty" json:"current_link_id,omitempty"`
-	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Username          string             `bson:"username" json:"username"`
+	Email             string             `bson:"email" json:"email"`
+	Password          string             `bson:"password" json:"-"`
+	EmailVerifiedAt   time.Time          `bson:"email_verified_at,omitempty" json:"email_verified_at,omitempty"`
+	Profile           Profile            `bson:"profile" json:"profile"`
+	Preferences       Preferences        `bson:"preferences" json:"preferences"`
+	Location          GeoLocation        `bson:"location,omitempty" json:"location"`
+	LocationUpdatedAt time.Time          `bson:"location_updated_at,omitempty" json:"location_updated_at,omitempty"`
+	State             UserState          `bson:"state" json:"state"`
+	IsSearching       bool               `bson:"is_searching" json:"is_searching"`
+	CurrentLinkID     primitive.ObjectID `bson:"current_link_id,omitempty" json:"current_link_id,omitempty"`
+	StateChangedAt    time.Time          `bson:"state_changed_at,omitempty" json:"-"`
+	CreatedAt         time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt         time.Time          `bson:"updated_at" json:"updated_at"`
 }
 
+// LogValue keeps a user's email, password and whereabouts out of the logs.
+func (u User) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", u.ID.Hex()),
+		slog.String("state", string(u.State)),
+	)
+}
+
+// EmailVerified reports whether the user has proved they own their email.
+func (u *User) EmailVerified() bool {
+	return !u.EmailVerifiedAt.IsZero()
+}
+
+// UserState is where a user is in the match flow. Moves between states go
+// through UserService so that invalid ones are rejected.
+type UserState string
+
+const (
+	UserStateIdle      UserState = "idle"
+	UserStateSearching UserState = "searching"
+	UserStateLinked    UserState = "linked"
+	UserStateInChat    UserState = "in_chat"
+	UserStateSuspended UserState = "suspended"
+)
+
 type GeoLocation struct {
 	Type        string    `bson:"type" json:"type"`
 	Coordinates []float64 `bson:"coordinates" json:"coordinates"`
 }
 
+// LogValue keeps positions out of the logs.
+func (g GeoLocation) LogValue() slog.Value {
+	return slog.StringValue("[redacted]")
+}
+
+// IsZero reports whether no position has been recorded, so that an empty
+// location is left out of documents instead of breaking the 2dsphere index.
+func (g GeoLocation) IsZero() bool {
+	return len(g.Coordinates) == 0
+}
+
+type LocationUpdate struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Location  GeoLocation        `bson:"location" json:"location"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
 type Profile struct {
 	FirstName     string    `bson:"first_name" json:"first_name"`
 	LastName      string    `bson:"last_name" json:"last_name"`
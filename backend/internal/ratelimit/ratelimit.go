@@ -0,0 +1,111 @@
+// Package ratelimit keeps token buckets that cap how often a client can
+// call an endpoint. Buckets live in a Store: in memory for a single
+// instance, or in MongoDB or Redis so every instance shares them.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Requests calls per Per on average, and a burst of up to
+// Requests at once. The zero Limit means no limit.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseLimit reads a limit written as requests/period, such as 10/1m, or
+// "off" for no limit.
+func ParseLimit(s string) (Limit, error) {
+	if s == "" || s == "off" {
+		return Limit{}, nil
+	}
+
+	requests, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("%q is not a limit such as 10/1m or off", s)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("%q is not a limit such as 10/1m or off", s)
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("%q is not a limit such as 10/1m or off", s)
+	}
+
+	return Limit{Requests: n, Per: d}, nil
+}
+
+func (l Limit) String() string {
+	if l.IsZero() {
+		return "off"
+	}
+	// Drop the zero units time.Duration prints, so 1h0m0s reads 1h
+	per := l.Per.String()
+	if strings.HasSuffix(per, "m0s") {
+		per = strings.TrimSuffix(per, "0s")
+	}
+	if strings.HasSuffix(per, "h0m") {
+		per = strings.TrimSuffix(per, "0m")
+	}
+	return fmt.Sprintf("%d/%s", l.Requests, per)
+}
+
+func (l Limit) IsZero() bool {
+	return l.Requests == 0
+}
+
+// rate is how many tokens the bucket gains per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// Result is the state of a bucket after a Take.
+type Result struct {
+	Allowed   bool
+	Limit     Limit
+	Remaining int
+	// RetryAfter is how long until the next call would be allowed; zero if
+	// it would be now
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// Store takes tokens from named buckets. Take must be atomic, so that
+// concurrent calls for one key never spend the same token twice.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// result works out a Result from the tokens left in a bucket after a take.
+func result(limit Limit, tokens float64, allowed bool) Result {
+	rate := limit.rate()
+	res := Result{
+		Allowed:   allowed,
+		Limit:     limit,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((float64(limit.Requests) - tokens) / rate),
+	}
+	if tokens < 1 {
+		res.RetryAfter = seconds((1 - tokens) / rate)
+	}
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
+
+// Policy limits one route per client IP and per user. A zero Limit leaves
+// that side unlimited.
+type Policy struct {
+	PerIP   Limit
+	PerUser Limit
+}
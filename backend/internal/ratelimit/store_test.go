@@ -0,0 +1,173 @@
+package ratelimit_test
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/seunghoon34/linkapp/backend/internal/ratelimit"
+)
+
+// testStore checks the token bucket behaviour every store has to share.
+// Each check uses its own key, so they can run against one store.
+func testStore(t *testing.T, store ratelimit.Store) {
+	ctx := context.Background()
+	key := func() string { return primitive.NewObjectID().Hex() }
+
+	take := func(t *testing.T, key string, limit ratelimit.Limit) ratelimit.Result {
+		t.Helper()
+		res, err := store.Take(ctx, key, limit)
+		if err != nil {
+			t.Fatalf("Take() error = %v", err)
+		}
+		return res
+	}
+
+	t.Run("burst", func(t *testing.T) {
+		limit := ratelimit.Limit{Requests: 3, Per: time.Minute}
+		key := key()
+
+		for want := 2; want >= 0; want-- {
+			res := take(t, key, limit)
+			if !res.Allowed || res.Remaining != want {
+				t.Fatalf("take = allowed %v, %d remaining; want allowed, %d remaining", res.Allowed, res.Remaining, want)
+			}
+			// Taking the last token means waiting for the next
+			if (res.RetryAfter == 0) != (want > 0) {
+				t.Errorf("retry after %v with %d remaining", res.RetryAfter, want)
+			}
+			if res.Limit != limit {
+				t.Errorf("result limit = %v, want %v", res.Limit, limit)
+			}
+		}
+
+		res := take(t, key, limit)
+		if res.Allowed || res.Remaining != 0 {
+			t.Fatalf("take past the burst = allowed %v, %d remaining; want refused", res.Allowed, res.Remaining)
+		}
+		// A token comes back every 20s, and the bucket is full again in a minute
+		if res.RetryAfter <= 0 || res.RetryAfter > 20*time.Second {
+			t.Errorf("retry after %v, want up to 20s", res.RetryAfter)
+		}
+		if res.Reset <= 40*time.Second || res.Reset > time.Minute {
+			t.Errorf("reset in %v, want about a minute", res.Reset)
+		}
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		limit := ratelimit.Limit{Requests: 1, Per: time.Minute}
+		first, second := key(), key()
+
+		take(t, first, limit)
+		if res := take(t, first, limit); res.Allowed {
+			t.Fatal("second take from a bucket of one was allowed")
+		}
+		if res := take(t, second, limit); !res.Allowed {
+			t.Error("take from another key was refused")
+		}
+	})
+
+	t.Run("refill", func(t *testing.T) {
+		limit := ratelimit.Limit{Requests: 2, Per: 400 * time.Millisecond}
+		key := key()
+
+		take(t, key, limit)
+		take(t, key, limit)
+		if res := take(t, key, limit); res.Allowed {
+			t.Fatal("take from an empty bucket was allowed")
+		}
+
+		// One token back every 200ms
+		time.Sleep(250 * time.Millisecond)
+		if res := take(t, key, limit); !res.Allowed {
+			t.Fatal("take after a refill was refused")
+		}
+		if res := take(t, key, limit); res.Allowed {
+			t.Error("take beyond the refill was allowed")
+		}
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		limit := ratelimit.Limit{Requests: 5, Per: time.Hour}
+		key := key()
+
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			allowed int
+		)
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res, err := store.Take(ctx, key, limit)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if res.Allowed {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		if allowed != limit.Requests {
+			t.Errorf("%d of 20 concurrent takes allowed, want %d", allowed, limit.Requests)
+		}
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, ratelimit.NewMemoryStore())
+}
+
+// TestRedisStore runs against the Redis server at REDIS_URL, such as a local
+// container, and is skipped if the variable isn't set.
+func TestRedisStore(t *testing.T) {
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		t.Skip("REDIS_URL isn't set")
+	}
+
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		t.Fatalf("parsing REDIS_URL: %v", err)
+	}
+	client := redis.NewClient(opts)
+	t.Cleanup(func() { client.Close() })
+
+	// Buckets expire on their own once they've refilled
+	prefix := "ratelimit_test_" + primitive.NewObjectID().Hex() + ":"
+	testStore(t, ratelimit.NewRedisStore(client, prefix))
+}
+
+// TestMongoStore runs against a fresh database on the MongoDB server at
+// MONGO_TEST_URI, and is skipped if the variable isn't set.
+func TestMongoStore(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI isn't set")
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connecting to MongoDB: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(ctx) })
+
+	db := client.Database("linkapp_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() { db.Drop(ctx) })
+
+	testStore(t, ratelimit.NewMongoStore(db, 5*time.Second))
+}
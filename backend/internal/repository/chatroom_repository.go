@@ -2,28 +2,38 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/seunghoon34/linkapp/backend/internal/model"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type ChatroomRepository struct {
 	chatroomCollection *mongo.Collection
 	messageCollection  *mongo.Collection
+	locationCollection *mongo.Collection
+	timeouts           Timeouts
 }
 
-func NewChatroomRepository(db *mongo.Database) *ChatroomRepository {
+// SharedLocationRetention is how long a position shared in a chatroom is
+// kept before MongoDB's TTL monitor deletes it.
+const SharedLocationRetention = 24 * time.Hour
+
+func NewChatroomRepository(db *mongo.Database, timeouts Timeouts) *ChatroomRepository {
 	return &ChatroomRepository{
 		chatroomCollection: db.Collection("chatrooms"),
 		messageCollection:  db.Collection("messages"),
+		locationCollection: db.Collection("chatroom_locations"),
+		timeouts:           timeouts,
 	}
 }
 
-func (r *ChatroomRepository) CreateChatroom(linkID, userAID, userBID primitive.ObjectID) (*model.Chatroom, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *ChatroomRepository) CreateChatroom(ctx context.Context, linkID, userAID, userBID primitive.ObjectID) (*model.Chatroom, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
 	defer cancel()
 
 	chatroom := &model.Chatroom{
@@ -31,6 +41,7 @@ func (r *ChatroomRepository) CreateChatroom(linkID, userAID, userBID primitive.O
 		UserAID:   userAID,
 		UserBID:   userBID,
 		IsLocked:  true,
+		Status:    model.ChatroomStatusActive,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -44,27 +55,113 @@ func (r *ChatroomRepository) CreateChatroom(linkID, userAID, userBID primitive.O
 	return chatroom, nil
 }
 
-func (r *ChatroomRepository) GetChatroom(chatroomID primitive.ObjectID) (*model.Chatroom, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *ChatroomRepository) GetChatroom(ctx context.Context, chatroomID primitive.ObjectID) (*model.Chatroom, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
 	defer cancel()
 
 	var chatroom model.Chatroom
 	err := r.chatroomCollection.FindOne(ctx, bson.M{"_id": chatroomID}).Decode(&chatroom)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrChatroomNotFound
+		}
 		return nil, err
 	}
 
 	return &chatroom, nil
 }
 
-func (r *ChatroomRepository) UnlockChatroom(chatroomID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// UnlockChatroom unlocks the chatroom if it's still locked, reporting whether
+// this call did. A QR unlock's nonce is recorded in the same update. Since a
+// chatroom only unlocks once, the nonce replaces any already recorded rather
+// than adding to them.
+func (r *ChatroomRepository) UnlockChatroom(ctx context.Context, chatroomID primitive.ObjectID, nonce string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
 	defer cancel()
 
+	filter := bson.M{
+		"_id":       chatroomID,
+		"is_locked": true,
+	}
+	set := bson.M{
+		"is_locked":  false,
+		"updated_at": time.Now(),
+	}
+	if nonce != "" {
+		filter["used_unlock_nonces"] = bson.M{"$ne": nonce}
+		set["used_unlock_nonces"] = bson.A{nonce}
+	}
+
+	result, err := r.chatroomCollection.UpdateOne(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+// CloseChatroom moves an open chatroom to a closed status. It reports whether
+// this call closed it, so concurrent closes only clean up once.
+func (r *ChatroomRepository) CloseChatroom(ctx context.Context, chatroomID primitive.ObjectID, status model.ChatroomStatus) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"_id":       chatroomID,
+		"closed_at": bson.M{"$exists": false},
+	}
 	update := bson.M{
 		"$set": bson.M{
-			"is_locked":  false,
-			"updated_at": time.Now(),
+			"status":     status,
+			"closed_at":  now,
+			"updated_at": now,
+		},
+	}
+
+	result, err := r.chatroomCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+// GetExpiredLockedChatrooms returns open chatrooms that are still locked and
+// were created before the given time.
+func (r *ChatroomRepository) GetExpiredLockedChatrooms(ctx context.Context, createdBefore time.Time) ([]*model.Chatroom, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Search)
+	defer cancel()
+
+	filter := bson.M{
+		"is_locked":  true,
+		"closed_at":  bson.M{"$exists": false},
+		"created_at": bson.M{"$lt": createdBefore},
+	}
+
+	cursor, err := r.chatroomCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var chatrooms []*model.Chatroom
+	if err = cursor.All(ctx, &chatrooms); err != nil {
+		return nil, err
+	}
+
+	return chatrooms, nil
+}
+
+// RecordUnlockTap stores when userID last tapped to unlock the chatroom.
+func (r *ChatroomRepository) RecordUnlockTap(ctx context.Context, chatroomID, userID primitive.ObjectID, tappedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	update := bson.M{
+		"$set": bson.M{
+			"unlock_taps." + userID.Hex(): tappedAt,
+			"updated_at":                  time.Now(),
 		},
 	}
 
@@ -72,8 +169,8 @@ func (r *ChatroomRepository) UnlockChatroom(chatroomID primitive.ObjectID) error
 	return err
 }
 
-func (r *ChatroomRepository) AddMessage(chatroomID, senderID primitive.ObjectID, content string) (*model.Message, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *ChatroomRepository) AddMessage(ctx context.Context, chatroomID, senderID primitive.ObjectID, content string) (*model.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
 	defer cancel()
 
 	message := &model.Message{
@@ -92,11 +189,13 @@ func (r *ChatroomRepository) AddMessage(chatroomID, senderID primitive.ObjectID,
 	return message, nil
 }
 
-func (r *ChatroomRepository) GetMessages(chatroomID primitive.ObjectID) ([]*model.Message, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *ChatroomRepository) GetMessages(ctx context.Context, chatroomID primitive.ObjectID) ([]*model.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
 	defer cancel()
 
-	cursor, err := r.messageCollection.Find(ctx, bson.M{"chatroom_id": chatroomID})
+	// Oldest first, like the conversation reads
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.messageCollection.Find(ctx, bson.M{"chatroom_id": chatroomID}, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -109,3 +208,77 @@ func (r *ChatroomRepository) GetMessages(chatroomID primitive.ObjectID) ([]*mode
 
 	return messages, nil
 }
+
+func (r *ChatroomRepository) SetLocationSharingPaused(ctx context.Context, chatroomID, userID primitive.ObjectID, paused bool) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	op := "$pull"
+	if paused {
+		op = "$addToSet"
+	}
+
+	update := bson.M{
+		op:     bson.M{"location_paused_by": userID},
+		"$set": bson.M{"updated_at": time.Now()},
+	}
+
+	_, err := r.chatroomCollection.UpdateOne(ctx, bson.M{"_id": chatroomID}, update)
+	return err
+}
+
+// SaveSharedLocation keeps only the latest position per participant.
+func (r *ChatroomRepository) SaveSharedLocation(ctx context.Context, chatroomID, userID primitive.ObjectID, latitude, longitude float64) (*model.SharedLocation, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	location := &model.SharedLocation{
+		ChatroomID: chatroomID,
+		UserID:     userID,
+		Location: model.GeoLocation{
+			Type:        "Point",
+			Coordinates: []float64{longitude, latitude},
+		},
+		UpdatedAt: time.Now(),
+	}
+
+	filter := bson.M{"chatroom_id": chatroomID, "user_id": userID}
+	update := bson.M{
+		"$set": bson.M{
+			"location":   location.Location,
+			"updated_at": location.UpdatedAt,
+		},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	err := r.locationCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(location)
+	if err != nil {
+		return nil, err
+	}
+
+	return location, nil
+}
+
+func (r *ChatroomRepository) GetSharedLocation(ctx context.Context, chatroomID, userID primitive.ObjectID) (*model.SharedLocation, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	var location model.SharedLocation
+	err := r.locationCollection.FindOne(ctx, bson.M{"chatroom_id": chatroomID, "user_id": userID}).Decode(&location)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &location, nil
+}
+
+func (r *ChatroomRepository) DeleteSharedLocations(ctx context.Context, chatroomID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	_, err := r.locationCollection.DeleteMany(ctx, bson.M{"chatroom_id": chatroomID})
+	return err
+}
@@ -0,0 +1,60 @@
+package repository_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/seunghoon34/linkapp/backend/internal/migrate"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+	"github.com/seunghoon34/linkapp/backend/internal/repository/repotest"
+)
+
+// mongoDatabase returns a freshly migrated database on the MongoDB server at
+// MONGO_TEST_URI, dropped again when the test ends. The test is skipped if
+// the variable isn't set.
+func mongoDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI isn't set")
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connecting to MongoDB: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(ctx) })
+
+	db := client.Database("linkapp_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() { db.Drop(ctx) })
+
+	if err := migrate.NewMigrator(migrate.NewMongo(db), false, io.Discard).Up(ctx, 0); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+
+	return db
+}
+
+// TestConformance needs MONGO_TEST_URI to point at a replica set, since
+// TransitionPair runs in a transaction.
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Stores {
+		db := mongoDatabase(t)
+		if err := repository.CheckTransactions(context.Background(), db.Client()); err != nil {
+			t.Fatal(err)
+		}
+		return repotest.Stores{
+			Users:     repository.NewUserRepository(db, repository.DefaultTimeouts),
+			Links:     repository.NewLinkRepository(db, repository.DefaultTimeouts),
+			Chatrooms: repository.NewChatroomRepository(db, repository.DefaultTimeouts),
+		}
+	})
+}
@@ -0,0 +1,395 @@
+// Package instrumented wraps the repository stores to time each storage call
+// and trace it as a span, by store and method, whichever backend is in use.
+package instrumented
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel"
+
+	"github.com/seunghoon34/linkapp/backend/internal/metrics"
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+)
+
+var tracer = otel.Tracer("github.com/seunghoon34/linkapp/backend/internal/repository/instrumented")
+
+// start begins timing a storage call. Spans the database driver creates
+// for the call nest under the one started here.
+func start(ctx context.Context, store, method string) (context.Context, func()) {
+	begun := time.Now()
+	ctx, span := tracer.Start(ctx, store+"."+method)
+
+	return ctx, func() {
+		span.End()
+		metrics.DBOperationDuration.WithLabelValues(store, method).Observe(time.Since(begun).Seconds())
+	}
+}
+
+// Users times and traces every call to a UserStore.
+type Users struct {
+	store repository.UserStore
+}
+
+func NewUsers(store repository.UserStore) *Users {
+	return &Users{store: store}
+}
+
+func (s *Users) Create(ctx context.Context, user *model.User) error {
+	ctx, done := start(ctx, "users", "Create")
+	defer done()
+	return s.store.Create(ctx, user)
+}
+
+func (s *Users) GetByID(ctx context.Context, id string) (*model.User, error) {
+	ctx, done := start(ctx, "users", "GetByID")
+	defer done()
+	return s.store.GetByID(ctx, id)
+}
+
+func (s *Users) GetByEmail(ctx context.Context, email string) (*model.User, error) {
+	ctx, done := start(ctx, "users", "GetByEmail")
+	defer done()
+	return s.store.GetByEmail(ctx, email)
+}
+
+func (s *Users) Update(ctx context.Context, user *model.User) error {
+	ctx, done := start(ctx, "users", "Update")
+	defer done()
+	return s.store.Update(ctx, user)
+}
+
+func (s *Users) UpdateProfile(ctx context.Context, id string, profile model.Profile) error {
+	ctx, done := start(ctx, "users", "UpdateProfile")
+	defer done()
+	return s.store.UpdateProfile(ctx, id, profile)
+}
+
+func (s *Users) UpdatePreferences(ctx context.Context, id string, preferences model.Preferences) error {
+	ctx, done := start(ctx, "users", "UpdatePreferences")
+	defer done()
+	return s.store.UpdatePreferences(ctx, id, preferences)
+}
+
+func (s *Users) UpdateLocation(ctx context.Context, userID string, latitude, longitude float64) error {
+	ctx, done := start(ctx, "users", "UpdateLocation")
+	defer done()
+	return s.store.UpdateLocation(ctx, userID, latitude, longitude)
+}
+
+func (s *Users) GetLocationHistory(ctx context.Context, userID primitive.ObjectID) ([]*model.LocationUpdate, error) {
+	ctx, done := start(ctx, "users", "GetLocationHistory")
+	defer done()
+	return s.store.GetLocationHistory(ctx, userID)
+}
+
+func (s *Users) ClearStaleLocations(ctx context.Context, before time.Time) (int64, error) {
+	ctx, done := start(ctx, "users", "ClearStaleLocations")
+	defer done()
+	return s.store.ClearStaleLocations(ctx, before)
+}
+
+func (s *Users) StopStaleSearches(ctx context.Context, freshSince time.Time) (int64, error) {
+	ctx, done := start(ctx, "users", "StopStaleSearches")
+	defer done()
+	return s.store.StopStaleSearches(ctx, freshSince)
+}
+
+func (s *Users) CountByState(ctx context.Context, state model.UserState) (int64, error) {
+	ctx, done := start(ctx, "users", "CountByState")
+	defer done()
+	return s.store.CountByState(ctx, state)
+}
+
+func (s *Users) GetUsersInStateSince(ctx context.Context, state model.UserState, changedBefore time.Time) ([]*model.User, error) {
+	ctx, done := start(ctx, "users", "GetUsersInStateSince")
+	defer done()
+	return s.store.GetUsersInStateSince(ctx, state, changedBefore)
+}
+
+func (s *Users) TransitionState(ctx context.Context, userID primitive.ObjectID, t repository.StateTransition) (bool, error) {
+	ctx, done := start(ctx, "users", "TransitionState")
+	defer done()
+	return s.store.TransitionState(ctx, userID, t)
+}
+
+func (s *Users) TransitionPair(ctx context.Context, userAID, userBID primitive.ObjectID, t repository.StateTransition) (bool, error) {
+	ctx, done := start(ctx, "users", "TransitionPair")
+	defer done()
+	return s.store.TransitionPair(ctx, userAID, userBID, t)
+}
+
+func (s *Users) MarkEmailVerified(ctx context.Context, userID primitive.ObjectID, email string) (bool, error) {
+	ctx, done := start(ctx, "users", "MarkEmailVerified")
+	defer done()
+	return s.store.MarkEmailVerified(ctx, userID, email)
+}
+
+func (s *Users) ChangePassword(ctx context.Context, userID primitive.ObjectID, oldHash, newHash string) (bool, error) {
+	ctx, done := start(ctx, "users", "ChangePassword")
+	defer done()
+	return s.store.ChangePassword(ctx, userID, oldHash, newHash)
+}
+
+func (s *Users) SearchMatches(ctx context.Context, user *model.User, freshSince time.Time, radius float64, limit, offset int) ([]*model.User, error) {
+	ctx, done := start(ctx, "users", "SearchMatches")
+	defer done()
+	return s.store.SearchMatches(ctx, user, freshSince, radius, limit, offset)
+}
+
+func (s *Users) FindPotentialMatch(ctx context.Context, user *model.User, freshSince time.Time, radius float64) (*model.User, error) {
+	ctx, done := start(ctx, "users", "FindPotentialMatch")
+	defer done()
+	return s.store.FindPotentialMatch(ctx, user, freshSince, radius)
+}
+
+// Links times and traces every call to a LinkStore.
+type Links struct {
+	store repository.LinkStore
+}
+
+func NewLinks(store repository.LinkStore) *Links {
+	return &Links{store: store}
+}
+
+func (s *Links) CreateLink(ctx context.Context, userAID, userBID primitive.ObjectID, ttl time.Duration) (*model.Link, error) {
+	ctx, done := start(ctx, "links", "CreateLink")
+	defer done()
+	return s.store.CreateLink(ctx, userAID, userBID, ttl)
+}
+
+func (s *Links) GetLink(ctx context.Context, linkID primitive.ObjectID) (*model.Link, error) {
+	ctx, done := start(ctx, "links", "GetLink")
+	defer done()
+	return s.store.GetLink(ctx, linkID)
+}
+
+func (s *Links) UpdateLinkStatus(ctx context.Context, linkID primitive.ObjectID, status model.LinkStatus) error {
+	ctx, done := start(ctx, "links", "UpdateLinkStatus")
+	defer done()
+	return s.store.UpdateLinkStatus(ctx, linkID, status)
+}
+
+func (s *Links) ExpireLink(ctx context.Context, linkID primitive.ObjectID) (bool, error) {
+	ctx, done := start(ctx, "links", "ExpireLink")
+	defer done()
+	return s.store.ExpireLink(ctx, linkID)
+}
+
+func (s *Links) AnswerLink(ctx context.Context, linkID primitive.ObjectID, status model.LinkStatus) (bool, error) {
+	ctx, done := start(ctx, "links", "AnswerLink")
+	defer done()
+	return s.store.AnswerLink(ctx, linkID, status)
+}
+
+func (s *Links) GetPendingLinks(ctx context.Context) ([]*model.Link, error) {
+	ctx, done := start(ctx, "links", "GetPendingLinks")
+	defer done()
+	return s.store.GetPendingLinks(ctx)
+}
+
+// Chatrooms times and traces every call to a ChatroomStore.
+type Chatrooms struct {
+	store repository.ChatroomStore
+}
+
+func NewChatrooms(store repository.ChatroomStore) *Chatrooms {
+	return &Chatrooms{store: store}
+}
+
+func (s *Chatrooms) CreateChatroom(ctx context.Context, linkID, userAID, userBID primitive.ObjectID) (*model.Chatroom, error) {
+	ctx, done := start(ctx, "chatrooms", "CreateChatroom")
+	defer done()
+	return s.store.CreateChatroom(ctx, linkID, userAID, userBID)
+}
+
+func (s *Chatrooms) GetChatroom(ctx context.Context, chatroomID primitive.ObjectID) (*model.Chatroom, error) {
+	ctx, done := start(ctx, "chatrooms", "GetChatroom")
+	defer done()
+	return s.store.GetChatroom(ctx, chatroomID)
+}
+
+func (s *Chatrooms) UnlockChatroom(ctx context.Context, chatroomID primitive.ObjectID, nonce string) (bool, error) {
+	ctx, done := start(ctx, "chatrooms", "UnlockChatroom")
+	defer done()
+	return s.store.UnlockChatroom(ctx, chatroomID, nonce)
+}
+
+func (s *Chatrooms) CloseChatroom(ctx context.Context, chatroomID primitive.ObjectID, status model.ChatroomStatus) (bool, error) {
+	ctx, done := start(ctx, "chatrooms", "CloseChatroom")
+	defer done()
+	return s.store.CloseChatroom(ctx, chatroomID, status)
+}
+
+func (s *Chatrooms) GetExpiredLockedChatrooms(ctx context.Context, createdBefore time.Time) ([]*model.Chatroom, error) {
+	ctx, done := start(ctx, "chatrooms", "GetExpiredLockedChatrooms")
+	defer done()
+	return s.store.GetExpiredLockedChatrooms(ctx, createdBefore)
+}
+
+func (s *Chatrooms) RecordUnlockTap(ctx context.Context, chatroomID, userID primitive.ObjectID, tappedAt time.Time) error {
+	ctx, done := start(ctx, "chatrooms", "RecordUnlockTap")
+	defer done()
+	return s.store.RecordUnlockTap(ctx, chatroomID, userID, tappedAt)
+}
+
+func (s *Chatrooms) AddMessage(ctx context.Context, chatroomID, senderID primitive.ObjectID, content string) (*model.Message, error) {
+	ctx, done := start(ctx, "chatrooms", "AddMessage")
+	defer done()
+	return s.store.AddMessage(ctx, chatroomID, senderID, content)
+}
+
+func (s *Chatrooms) GetMessages(ctx context.Context, chatroomID primitive.ObjectID) ([]*model.Message, error) {
+	ctx, done := start(ctx, "chatrooms", "GetMessages")
+	defer done()
+	return s.store.GetMessages(ctx, chatroomID)
+}
+
+func (s *Chatrooms) SetLocationSharingPaused(ctx context.Context, chatroomID, userID primitive.ObjectID, paused bool) error {
+	ctx, done := start(ctx, "chatrooms", "SetLocationSharingPaused")
+	defer done()
+	return s.store.SetLocationSharingPaused(ctx, chatroomID, userID, paused)
+}
+
+func (s *Chatrooms) SaveSharedLocation(ctx context.Context, chatroomID, userID primitive.ObjectID, latitude, longitude float64) (*model.SharedLocation, error) {
+	ctx, done := start(ctx, "chatrooms", "SaveSharedLocation")
+	defer done()
+	return s.store.SaveSharedLocation(ctx, chatroomID, userID, latitude, longitude)
+}
+
+func (s *Chatrooms) GetSharedLocation(ctx context.Context, chatroomID, userID primitive.ObjectID) (*model.SharedLocation, error) {
+	ctx, done := start(ctx, "chatrooms", "GetSharedLocation")
+	defer done()
+	return s.store.GetSharedLocation(ctx, chatroomID, userID)
+}
+
+func (s *Chatrooms) DeleteSharedLocations(ctx context.Context, chatroomID primitive.ObjectID) error {
+	ctx, done := start(ctx, "chatrooms", "DeleteSharedLocations")
+	defer done()
+	return s.store.DeleteSharedLocations(ctx, chatroomID)
+}
+
+// Leases times and traces every call to a LeaseStore.
+type Leases struct {
+	store repository.LeaseStore
+}
+
+func NewLeases(store repository.LeaseStore) *Leases {
+	return &Leases{store: store}
+}
+
+func (s *Leases) Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	ctx, done := start(ctx, "leases", "Acquire")
+	defer done()
+	return s.store.Acquire(ctx, name, holder, ttl)
+}
+
+func (s *Leases) Get(ctx context.Context, name string) (*model.Lease, error) {
+	ctx, done := start(ctx, "leases", "Get")
+	defer done()
+	return s.store.Get(ctx, name)
+}
+
+func (s *Leases) Release(ctx context.Context, name, holder string) error {
+	ctx, done := start(ctx, "leases", "Release")
+	defer done()
+	return s.store.Release(ctx, name, holder)
+}
+
+// JobRuns times and traces every call to a JobRunStore.
+type JobRuns struct {
+	store repository.JobRunStore
+}
+
+func NewJobRuns(store repository.JobRunStore) *JobRuns {
+	return &JobRuns{store: store}
+}
+
+func (s *JobRuns) Record(ctx context.Context, run *model.JobRun) error {
+	ctx, done := start(ctx, "job_runs", "Record")
+	defer done()
+	return s.store.Record(ctx, run)
+}
+
+func (s *JobRuns) Recent(ctx context.Context, job string, limit int) ([]*model.JobRun, error) {
+	ctx, done := start(ctx, "job_runs", "Recent")
+	defer done()
+	return s.store.Recent(ctx, job, limit)
+}
+
+// LoginAttempts times and traces every call to a LoginAttemptStore.
+type LoginAttempts struct {
+	store repository.LoginAttemptStore
+}
+
+func NewLoginAttempts(store repository.LoginAttemptStore) *LoginAttempts {
+	return &LoginAttempts{store: store}
+}
+
+func (s *LoginAttempts) Failures(ctx context.Context, key string) (*model.LoginFailures, error) {
+	ctx, done := start(ctx, "login_attempts", "Failures")
+	defer done()
+	return s.store.Failures(ctx, key)
+}
+
+func (s *LoginAttempts) RecordFailure(ctx context.Context, key string, forgetAfter time.Duration) (*model.LoginFailures, error) {
+	ctx, done := start(ctx, "login_attempts", "RecordFailure")
+	defer done()
+	return s.store.RecordFailure(ctx, key, forgetAfter)
+}
+
+func (s *LoginAttempts) Clear(ctx context.Context, key string) error {
+	ctx, done := start(ctx, "login_attempts", "Clear")
+	defer done()
+	return s.store.Clear(ctx, key)
+}
+
+// SecurityEvents times and traces every call to a SecurityEventStore.
+type SecurityEvents struct {
+	store repository.SecurityEventStore
+}
+
+func NewSecurityEvents(store repository.SecurityEventStore) *SecurityEvents {
+	return &SecurityEvents{store: store}
+}
+
+func (s *SecurityEvents) Record(ctx context.Context, event *model.SecurityEvent) error {
+	ctx, done := start(ctx, "security_events", "Record")
+	defer done()
+	return s.store.Record(ctx, event)
+}
+
+// LocationEvents times and traces every event published to a
+// LocationEventStore. Listening lasts as long as the process, so it isn't
+// timed.
+type LocationEvents struct {
+	store repository.LocationEventStore
+}
+
+func NewLocationEvents(store repository.LocationEventStore) *LocationEvents {
+	return &LocationEvents{store: store}
+}
+
+func (s *LocationEvents) Publish(ctx context.Context, event repository.LocationEvent) error {
+	ctx, done := start(ctx, "location_events", "Publish")
+	defer done()
+	return s.store.Publish(ctx, event)
+}
+
+func (s *LocationEvents) Listen(ctx context.Context, handle func(repository.LocationEvent)) error {
+	return s.store.Listen(ctx, handle)
+}
+
+var (
+	_ repository.UserStore     = (*Users)(nil)
+	_ repository.LinkStore     = (*Links)(nil)
+	_ repository.ChatroomStore = (*Chatrooms)(nil)
+	_ repository.LeaseStore    = (*Leases)(nil)
+	_ repository.JobRunStore   = (*JobRuns)(nil)
+
+	_ repository.LoginAttemptStore  = (*LoginAttempts)(nil)
+	_ repository.SecurityEventStore = (*SecurityEvents)(nil)
+	_ repository.LocationEventStore = (*LocationEvents)(nil)
+)
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// JobRunRetention is how long background job run history is kept.
+const JobRunRetention = 7 * 24 * time.Hour
+
+type JobRunRepository struct {
+	collection *mongo.Collection
+	timeouts   Timeouts
+}
+
+func NewJobRunRepository(db *mongo.Database, timeouts Timeouts) *JobRunRepository {
+	return &JobRunRepository{collection: db.Collection("job_runs"), timeouts: timeouts}
+}
+
+func (r *JobRunRepository) Record(ctx context.Context, run *model.JobRun) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	result, err := r.collection.InsertOne(ctx, run)
+	if err != nil {
+		return err
+	}
+
+	run.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// Recent returns the job's latest runs across all instances, newest first.
+func (r *JobRunRepository) Recent(ctx context.Context, job string, limit int) ([]*model.JobRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "started_at", Value: -1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := r.collection.Find(ctx, bson.M{"job": job}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var runs []*model.JobRun
+	if err = cursor.All(ctx, &runs); err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type LeaseRepository struct {
+	collection *mongo.Collection
+	timeouts   Timeouts
+}
+
+func NewLeaseRepository(db *mongo.Database, timeouts Timeouts) *LeaseRepository {
+	return &LeaseRepository{
+		collection: db.Collection("leases"),
+		timeouts:   timeouts,
+	}
+}
+
+// Acquire takes or renews the named lease for holder. It reports false when
+// another holder has a lease that hasn't expired yet.
+func (r *LeaseRepository) Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"_id": name,
+		"$or": bson.A{
+			bson.M{"holder": holder},
+			bson.M{"expires_at": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"holder":     holder,
+			"expires_at": now.Add(ttl),
+			"renewed_at": now,
+		},
+	}
+
+	// If someone else holds the lease the filter misses, and the upsert
+	// collides with their document on _id
+	_, err := r.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *LeaseRepository) Get(ctx context.Context, name string) (*model.Lease, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	var lease model.Lease
+	err := r.collection.FindOne(ctx, bson.M{"_id": name}).Decode(&lease)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &lease, nil
+}
+
+// Release gives up the named lease if holder still owns it.
+func (r *LeaseRepository) Release(ctx context.Context, name, holder string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": name, "holder": holder})
+	return err
+}
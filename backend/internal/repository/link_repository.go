@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/seunghoon34/linkapp/backend/internal/model"
@@ -12,16 +13,18 @@ import (
 
 type LinkRepository struct {
 	collection *mongo.Collection
+	timeouts   Timeouts
 }
 
-func NewLinkRepository(db *mongo.Database) *LinkRepository {
+func NewLinkRepository(db *mongo.Database, timeouts Timeouts) *LinkRepository {
 	return &LinkRepository{
 		collection: db.Collection("links"),
+		timeouts:   timeouts,
 	}
 }
 
-func (r *LinkRepository) CreateLink(userAID, userBID primitive.ObjectID) (*model.Link, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *LinkRepository) CreateLink(ctx context.Context, userAID, userBID primitive.ObjectID, ttl time.Duration) (*model.Link, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
 	defer cancel()
 
 	now := time.Now()
@@ -30,7 +33,7 @@ func (r *LinkRepository) CreateLink(userAID, userBID primitive.ObjectID) (*model
 		UserBID:   userBID,
 		Status:    model.LinkStatusPending,
 		CreatedAt: now,
-		ExpiresAt: now.Add(30 * time.Second),
+		ExpiresAt: now.Add(ttl),
 	}
 
 	result, err := r.collection.InsertOne(ctx, link)
@@ -42,8 +45,8 @@ func (r *LinkRepository) CreateLink(userAID, userBID primitive.ObjectID) (*model
 	return link, nil
 }
 
-func (r *LinkRepository) UpdateLinkStatus(linkID primitive.ObjectID, status model.LinkStatus) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *LinkRepository) UpdateLinkStatus(ctx context.Context, linkID primitive.ObjectID, status model.LinkStatus) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
 	defer cancel()
 
 	update := bson.M{
@@ -57,47 +60,80 @@ func (r *LinkRepository) UpdateLinkStatus(linkID primitive.ObjectID, status mode
 	return err
 }
 
-func (r *LinkRepository) GetLink(linkID primitive.ObjectID) (*model.Link, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *LinkRepository) GetLink(ctx context.Context, linkID primitive.ObjectID) (*model.Link, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
 	defer cancel()
 
 	var link model.Link
 	err := r.collection.FindOne(ctx, bson.M{"_id": linkID}).Decode(&link)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrLinkNotFound
+		}
 		return nil, err
 	}
 
 	return &link, nil
 }
 
-func (r *LinkRepository) ExpireLinks() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+// ExpireLink marks the link expired if it's still pending. It reports whether
+// this call expired it, so each link is only handled once.
+func (r *LinkRepository) ExpireLink(ctx context.Context, linkID primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
 	defer cancel()
 
-	now := time.Now()
 	filter := bson.M{
-		"status":     model.LinkStatusPending,
-		"expires_at": bson.M{"$lt": now},
+		"_id":    linkID,
+		"status": model.LinkStatusPending,
 	}
 	update := bson.M{
-		"$set": bson.M{"status": model.LinkStatusExpired},
+		"$set": bson.M{
+			"status":     model.LinkStatusExpired,
+			"updated_at": time.Now(),
+		},
 	}
 
-	_, err := r.collection.UpdateMany(ctx, filter, update)
-	return err
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
 }
 
-func (r *LinkRepository) GetExpiredLinks() ([]*model.Link, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+// AnswerLink sets the link's status if it's still pending and hasn't
+// expired yet, reporting whether it did. A link whose timer hasn't fired
+// can't be answered late.
+func (r *LinkRepository) AnswerLink(ctx context.Context, linkID primitive.ObjectID, status model.LinkStatus) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
 	defer cancel()
 
 	now := time.Now()
 	filter := bson.M{
-		"status":     model.LinkStatusExpired,
-		"expires_at": bson.M{"$lt": now},
+		"_id":        linkID,
+		"status":     model.LinkStatusPending,
+		"expires_at": bson.M{"$gt": now},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":     status,
+			"updated_at": now,
+		},
 	}
 
-	cursor, err := r.collection.Find(ctx, filter)
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+func (r *LinkRepository) GetPendingLinks(ctx context.Context) ([]*model.Link, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Search)
+	defer cancel()
+
+	cursor, err := r.collection.Find(ctx, bson.M{"status": model.LinkStatusPending})
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// LocationEventRetention is how long a location event is kept after it's
+// published. Listeners only read events as they're inserted, so this just
+// bounds the collection.
+const LocationEventRetention = time.Minute
+
+// LocationEventRepository publishes events by inserting them into the
+// location_events collection and listens with a change stream on it, which
+// needs a replica set.
+type LocationEventRepository struct {
+	collection *mongo.Collection
+	timeouts   Timeouts
+}
+
+func NewLocationEventRepository(db *mongo.Database, timeouts Timeouts) *LocationEventRepository {
+	return &LocationEventRepository{collection: db.Collection("location_events"), timeouts: timeouts}
+}
+
+type locationEventDocument struct {
+	ChatroomID primitive.ObjectID    `bson:"chatroom_id"`
+	Location   *model.SharedLocation `bson:"location,omitempty"`
+	Closed     bool                  `bson:"closed"`
+	CreatedAt  time.Time             `bson:"created_at"`
+}
+
+func (r *LocationEventRepository) Publish(ctx context.Context, event LocationEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	_, err := r.collection.InsertOne(ctx, locationEventDocument{
+		ChatroomID: event.ChatroomID,
+		Location:   event.Location,
+		Closed:     event.Closed,
+		CreatedAt:  time.Now(),
+	})
+	return err
+}
+
+func (r *LocationEventRepository) Listen(ctx context.Context, handle func(LocationEvent)) error {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"operationType": "insert"}}}}
+	stream, err := r.collection.Watch(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.WithoutCancel(ctx))
+
+	for stream.Next(ctx) {
+		var change struct {
+			Document locationEventDocument `bson:"fullDocument"`
+		}
+		if err := stream.Decode(&change); err != nil {
+			return err
+		}
+		handle(LocationEvent{
+			ChatroomID: change.Document.ChatroomID,
+			Location:   change.Document.Location,
+			Closed:     change.Document.Closed,
+		})
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	return stream.Err()
+}
@@ -0,0 +1,241 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type sharedLocationKey struct {
+	chatroomID primitive.ObjectID
+	userID     primitive.ObjectID
+}
+
+type ChatroomRepository struct {
+	mu        sync.RWMutex
+	chatrooms map[primitive.ObjectID]*model.Chatroom
+	messages  []*model.Message
+	locations map[sharedLocationKey]*model.SharedLocation
+}
+
+func NewChatroomRepository() *ChatroomRepository {
+	return &ChatroomRepository{
+		chatrooms: make(map[primitive.ObjectID]*model.Chatroom),
+		locations: make(map[sharedLocationKey]*model.SharedLocation),
+	}
+}
+
+func cloneChatroom(chatroom *model.Chatroom) *model.Chatroom {
+	c := *chatroom
+	c.LocationPausedBy = append([]primitive.ObjectID(nil), chatroom.LocationPausedBy...)
+	c.UsedUnlockNonces = append([]string(nil), chatroom.UsedUnlockNonces...)
+	if chatroom.UnlockTaps != nil {
+		c.UnlockTaps = make(map[string]time.Time, len(chatroom.UnlockTaps))
+		for userID, tappedAt := range chatroom.UnlockTaps {
+			c.UnlockTaps[userID] = tappedAt
+		}
+	}
+	return &c
+}
+
+func (r *ChatroomRepository) CreateChatroom(ctx context.Context, linkID, userAID, userBID primitive.ObjectID) (*model.Chatroom, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chatroom := &model.Chatroom{
+		ID:        primitive.NewObjectID(),
+		LinkID:    linkID,
+		UserAID:   userAID,
+		UserBID:   userBID,
+		IsLocked:  true,
+		Status:    model.ChatroomStatusActive,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	r.chatrooms[chatroom.ID] = cloneChatroom(chatroom)
+	return chatroom, nil
+}
+
+func (r *ChatroomRepository) GetChatroom(ctx context.Context, chatroomID primitive.ObjectID) (*model.Chatroom, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	chatroom, ok := r.chatrooms[chatroomID]
+	if !ok {
+		return nil, repository.ErrChatroomNotFound
+	}
+
+	return cloneChatroom(chatroom), nil
+}
+
+// update applies fn to the stored chatroom, doing nothing if there's no such
+// chatroom.
+func (r *ChatroomRepository) update(chatroomID primitive.ObjectID, fn func(chatroom *model.Chatroom)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if chatroom, ok := r.chatrooms[chatroomID]; ok {
+		fn(chatroom)
+		chatroom.UpdatedAt = time.Now()
+	}
+}
+
+func (r *ChatroomRepository) UnlockChatroom(ctx context.Context, chatroomID primitive.ObjectID, nonce string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chatroom, ok := r.chatrooms[chatroomID]
+	if !ok || !chatroom.IsLocked || (nonce != "" && slices.Contains(chatroom.UsedUnlockNonces, nonce)) {
+		return false, nil
+	}
+
+	chatroom.IsLocked = false
+	if nonce != "" {
+		chatroom.UsedUnlockNonces = []string{nonce}
+	}
+	chatroom.UpdatedAt = time.Now()
+	return true, nil
+}
+
+func (r *ChatroomRepository) CloseChatroom(ctx context.Context, chatroomID primitive.ObjectID, status model.ChatroomStatus) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chatroom, ok := r.chatrooms[chatroomID]
+	if !ok || chatroom.IsClosed() {
+		return false, nil
+	}
+
+	chatroom.Status = status
+	chatroom.ClosedAt = time.Now()
+	chatroom.UpdatedAt = chatroom.ClosedAt
+	return true, nil
+}
+
+func (r *ChatroomRepository) GetExpiredLockedChatrooms(ctx context.Context, createdBefore time.Time) ([]*model.Chatroom, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var chatrooms []*model.Chatroom
+	for _, chatroom := range r.chatrooms {
+		if chatroom.IsLocked && !chatroom.IsClosed() && chatroom.CreatedAt.Before(createdBefore) {
+			chatrooms = append(chatrooms, cloneChatroom(chatroom))
+		}
+	}
+
+	return chatrooms, nil
+}
+
+func (r *ChatroomRepository) RecordUnlockTap(ctx context.Context, chatroomID, userID primitive.ObjectID, tappedAt time.Time) error {
+	r.update(chatroomID, func(chatroom *model.Chatroom) {
+		if chatroom.UnlockTaps == nil {
+			chatroom.UnlockTaps = make(map[string]time.Time)
+		}
+		chatroom.UnlockTaps[userID.Hex()] = tappedAt
+	})
+	return nil
+}
+
+func (r *ChatroomRepository) AddMessage(ctx context.Context, chatroomID, senderID primitive.ObjectID, content string) (*model.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	message := &model.Message{
+		ID:         primitive.NewObjectID(),
+		ChatroomID: chatroomID,
+		SenderID:   senderID,
+		Content:    content,
+		CreatedAt:  time.Now(),
+	}
+
+	c := *message
+	r.messages = append(r.messages, &c)
+	return message, nil
+}
+
+func (r *ChatroomRepository) GetMessages(ctx context.Context, chatroomID primitive.ObjectID) ([]*model.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var messages []*model.Message
+	for _, message := range r.messages {
+		if message.ChatroomID == chatroomID {
+			c := *message
+			messages = append(messages, &c)
+		}
+	}
+
+	return messages, nil
+}
+
+func (r *ChatroomRepository) SetLocationSharingPaused(ctx context.Context, chatroomID, userID primitive.ObjectID, paused bool) error {
+	r.update(chatroomID, func(chatroom *model.Chatroom) {
+		var pausedBy []primitive.ObjectID
+		for _, id := range chatroom.LocationPausedBy {
+			if id != userID {
+				pausedBy = append(pausedBy, id)
+			}
+		}
+		if paused {
+			pausedBy = append(pausedBy, userID)
+		}
+		chatroom.LocationPausedBy = pausedBy
+	})
+	return nil
+}
+
+func (r *ChatroomRepository) SaveSharedLocation(ctx context.Context, chatroomID, userID primitive.ObjectID, latitude, longitude float64) (*model.SharedLocation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := sharedLocationKey{chatroomID: chatroomID, userID: userID}
+	location, ok := r.locations[key]
+	if !ok {
+		location = &model.SharedLocation{
+			ID:         primitive.NewObjectID(),
+			ChatroomID: chatroomID,
+			UserID:     userID,
+		}
+		r.locations[key] = location
+	}
+
+	location.Location = model.GeoLocation{
+		Type:        "Point",
+		Coordinates: []float64{longitude, latitude},
+	}
+	location.UpdatedAt = time.Now()
+
+	c := *location
+	return &c, nil
+}
+
+func (r *ChatroomRepository) GetSharedLocation(ctx context.Context, chatroomID, userID primitive.ObjectID) (*model.SharedLocation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	location, ok := r.locations[sharedLocationKey{chatroomID: chatroomID, userID: userID}]
+	if !ok || time.Since(location.UpdatedAt) > repository.SharedLocationRetention {
+		return nil, nil
+	}
+
+	c := *location
+	return &c, nil
+}
+
+func (r *ChatroomRepository) DeleteSharedLocations(ctx context.Context, chatroomID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key := range r.locations {
+		if key.chatroomID == chatroomID {
+			delete(r.locations, key)
+		}
+	}
+	return nil
+}
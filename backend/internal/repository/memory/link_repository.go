@@ -0,0 +1,105 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type LinkRepository struct {
+	mu    sync.RWMutex
+	links map[primitive.ObjectID]*model.Link
+}
+
+func NewLinkRepository() *LinkRepository {
+	return &LinkRepository{
+		links: make(map[primitive.ObjectID]*model.Link),
+	}
+}
+
+func (r *LinkRepository) CreateLink(ctx context.Context, userAID, userBID primitive.ObjectID, ttl time.Duration) (*model.Link, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	link := &model.Link{
+		ID:        primitive.NewObjectID(),
+		UserAID:   userAID,
+		UserBID:   userBID,
+		Status:    model.LinkStatusPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	c := *link
+	r.links[link.ID] = &c
+	return link, nil
+}
+
+func (r *LinkRepository) GetLink(ctx context.Context, linkID primitive.ObjectID) (*model.Link, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	link, ok := r.links[linkID]
+	if !ok {
+		return nil, repository.ErrLinkNotFound
+	}
+
+	c := *link
+	return &c, nil
+}
+
+func (r *LinkRepository) UpdateLinkStatus(ctx context.Context, linkID primitive.ObjectID, status model.LinkStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if link, ok := r.links[linkID]; ok {
+		link.Status = status
+	}
+	return nil
+}
+
+func (r *LinkRepository) ExpireLink(ctx context.Context, linkID primitive.ObjectID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	link, ok := r.links[linkID]
+	if !ok || link.Status != model.LinkStatusPending {
+		return false, nil
+	}
+
+	link.Status = model.LinkStatusExpired
+	return true, nil
+}
+
+func (r *LinkRepository) AnswerLink(ctx context.Context, linkID primitive.ObjectID, status model.LinkStatus) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	link, ok := r.links[linkID]
+	if !ok || link.Status != model.LinkStatusPending || !time.Now().Before(link.ExpiresAt) {
+		return false, nil
+	}
+
+	link.Status = status
+	return true, nil
+}
+
+func (r *LinkRepository) GetPendingLinks(ctx context.Context) ([]*model.Link, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var links []*model.Link
+	for _, link := range r.links {
+		if link.Status == model.LinkStatusPending {
+			c := *link
+			links = append(links, &c)
+		}
+	}
+
+	return links, nil
+}
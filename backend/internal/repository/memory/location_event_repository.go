@@ -0,0 +1,50 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+)
+
+// LocationEventRepository hands each event straight to the listeners, before
+// Publish returns. Services sharing one stand in for instances sharing a
+// database.
+type LocationEventRepository struct {
+	mu        sync.Mutex
+	listeners map[int]func(repository.LocationEvent)
+	next      int
+}
+
+func NewLocationEventRepository() *LocationEventRepository {
+	return &LocationEventRepository{listeners: make(map[int]func(repository.LocationEvent))}
+}
+
+func (r *LocationEventRepository) Publish(ctx context.Context, event repository.LocationEvent) error {
+	r.mu.Lock()
+	listeners := make([]func(repository.LocationEvent), 0, len(r.listeners))
+	for _, handle := range r.listeners {
+		listeners = append(listeners, handle)
+	}
+	r.mu.Unlock()
+
+	for _, handle := range listeners {
+		handle(event)
+	}
+	return nil
+}
+
+func (r *LocationEventRepository) Listen(ctx context.Context, handle func(repository.LocationEvent)) error {
+	r.mu.Lock()
+	id := r.next
+	r.next++
+	r.listeners[id] = handle
+	r.mu.Unlock()
+
+	<-ctx.Done()
+
+	r.mu.Lock()
+	delete(r.listeners, id)
+	r.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,16 @@
+// Package memory provides in-memory implementations of the repository
+// stores, for running the service layer without MongoDB. They follow the
+// MongoDB repositories' behaviour closely but aren't meant for production.
+package memory
+
+import "github.com/seunghoon34/linkapp/backend/internal/repository"
+
+var (
+	_ repository.UserStore     = (*UserRepository)(nil)
+	_ repository.LinkStore     = (*LinkRepository)(nil)
+	_ repository.ChatroomStore = (*ChatroomRepository)(nil)
+
+	_ repository.LoginAttemptStore  = (*LoginAttemptRepository)(nil)
+	_ repository.SecurityEventStore = (*SecurityEventRepository)(nil)
+	_ repository.LocationEventStore = (*LocationEventRepository)(nil)
+)
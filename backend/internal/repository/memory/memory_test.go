@@ -0,0 +1,18 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/seunghoon34/linkapp/backend/internal/repository/memory"
+	"github.com/seunghoon34/linkapp/backend/internal/repository/repotest"
+)
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Stores {
+		return repotest.Stores{
+			Users:     memory.NewUserRepository(),
+			Links:     memory.NewLinkRepository(),
+			Chatrooms: memory.NewChatroomRepository(),
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type LoginAttemptRepository struct {
+	mu       sync.Mutex
+	failures map[string]*model.LoginFailures
+}
+
+func NewLoginAttemptRepository() *LoginAttemptRepository {
+	return &LoginAttemptRepository{failures: make(map[string]*model.LoginFailures)}
+}
+
+func (r *LoginAttemptRepository) Failures(ctx context.Context, key string) (*model.LoginFailures, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	failures, ok := r.failures[key]
+	if !ok || !failures.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+
+	c := *failures
+	return &c, nil
+}
+
+func (r *LoginAttemptRepository) RecordFailure(ctx context.Context, key string, forgetAfter time.Duration) (*model.LoginFailures, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	failures, ok := r.failures[key]
+	if !ok || !failures.ExpiresAt.After(now) {
+		failures = &model.LoginFailures{Key: key}
+		r.failures[key] = failures
+	}
+	failures.Failures++
+	failures.LastFailureAt = now
+	failures.ExpiresAt = now.Add(forgetAfter)
+
+	c := *failures
+	return &c, nil
+}
+
+func (r *LoginAttemptRepository) Clear(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.failures, key)
+	return nil
+}
+
+type SecurityEventRepository struct {
+	mu     sync.Mutex
+	events []*model.SecurityEvent
+}
+
+func NewSecurityEventRepository() *SecurityEventRepository {
+	return &SecurityEventRepository{}
+}
+
+func (r *SecurityEventRepository) Record(ctx context.Context, event *model.SecurityEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	event.ID = primitive.NewObjectID()
+	c := *event
+	r.events = append(r.events, &c)
+	return nil
+}
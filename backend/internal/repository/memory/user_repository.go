@@ -0,0 +1,444 @@
+package memory
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type UserRepository struct {
+	mu      sync.RWMutex
+	users   map[primitive.ObjectID]*model.User
+	history []*model.LocationUpdate
+}
+
+func NewUserRepository() *UserRepository {
+	return &UserRepository{
+		users: make(map[primitive.ObjectID]*model.User),
+	}
+}
+
+func cloneUser(user *model.User) *model.User {
+	c := *user
+	c.Location.Coordinates = append([]float64(nil), user.Location.Coordinates...)
+	c.Preferences.Gender = append([]string(nil), user.Preferences.Gender...)
+	return &c
+}
+
+func (r *UserRepository) Create(ctx context.Context, user *model.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkUnique(user); err != nil {
+		return err
+	}
+
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = time.Now()
+	if user.State == "" {
+		user.State = model.UserStateIdle
+	}
+
+	r.users[user.ID] = cloneUser(user)
+	return nil
+}
+
+func (r *UserRepository) GetByID(ctx context.Context, id string) (*model.User, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[objectID]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+
+	return cloneUser(user), nil
+}
+
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*model.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if user.Email == email {
+			return cloneUser(user), nil
+		}
+	}
+
+	return nil, repository.ErrUserNotFound
+}
+
+// Update saves only the user's username, email and email verification,
+// like the other stores.
+func (r *UserRepository) Update(ctx context.Context, user *model.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.users[user.ID]
+	if !ok {
+		return nil
+	}
+	if err := r.checkUnique(user); err != nil {
+		return err
+	}
+
+	user.UpdatedAt = time.Now()
+	stored.Username = user.Username
+	stored.Email = user.Email
+	stored.EmailVerifiedAt = user.EmailVerifiedAt
+	stored.UpdatedAt = user.UpdatedAt
+	return nil
+}
+
+// checkUnique stands in for the unique indexes on email and username.
+// Callers hold the lock.
+func (r *UserRepository) checkUnique(user *model.User) error {
+	for id, other := range r.users {
+		if id == user.ID {
+			continue
+		}
+		if other.Email == user.Email {
+			return &repository.ConflictError{Field: "email"}
+		}
+		if other.Username == user.Username {
+			return &repository.ConflictError{Field: "username"}
+		}
+	}
+	return nil
+}
+
+// update applies fn to the stored user, doing nothing if there's no such user.
+func (r *UserRepository) update(id string, fn func(user *model.User)) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user, ok := r.users[objectID]; ok {
+		fn(user)
+		user.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (r *UserRepository) UpdateProfile(ctx context.Context, id string, profile model.Profile) error {
+	return r.update(id, func(user *model.User) {
+		user.Profile = profile
+	})
+}
+
+func (r *UserRepository) UpdatePreferences(ctx context.Context, id string, preferences model.Preferences) error {
+	return r.update(id, func(user *model.User) {
+		user.Preferences = preferences
+		user.Preferences.Gender = append([]string(nil), preferences.Gender...)
+	})
+}
+
+func (r *UserRepository) UpdateLocation(ctx context.Context, userID string, latitude, longitude float64) error {
+	now := time.Now()
+	location := model.GeoLocation{
+		Type:        "Point",
+		Coordinates: []float64{longitude, latitude},
+	}
+
+	err := r.update(userID, func(user *model.User) {
+		user.Location = location
+		user.LocationUpdatedAt = now
+	})
+	if err != nil {
+		return err
+	}
+
+	objectID, _ := primitive.ObjectIDFromHex(userID)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.history = append(r.history, &model.LocationUpdate{
+		ID:        primitive.NewObjectID(),
+		UserID:    objectID,
+		Location:  location,
+		CreatedAt: now,
+	})
+	return nil
+}
+
+func (r *UserRepository) GetLocationHistory(ctx context.Context, userID primitive.ObjectID) ([]*model.LocationUpdate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cutoff := time.Now().Add(-repository.LocationHistoryRetention)
+
+	var history []*model.LocationUpdate
+	for i := len(r.history) - 1; i >= 0; i-- {
+		update := r.history[i]
+		if update.UserID == userID && update.CreatedAt.After(cutoff) {
+			c := *update
+			history = append(history, &c)
+		}
+	}
+
+	return history, nil
+}
+
+func (r *UserRepository) ClearStaleLocations(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var cleared int64
+	for _, user := range r.users {
+		if !user.LocationUpdatedAt.IsZero() && user.LocationUpdatedAt.Before(before) {
+			user.Location = model.GeoLocation{}
+			user.LocationUpdatedAt = time.Time{}
+			user.UpdatedAt = time.Now()
+			cleared++
+		}
+	}
+
+	return cleared, nil
+}
+
+func (r *UserRepository) StopStaleSearches(ctx context.Context, freshSince time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var stopped int64
+	for _, user := range r.users {
+		if user.State == model.UserStateSearching && user.LocationUpdatedAt.Before(freshSince) {
+			user.State = model.UserStateIdle
+			user.IsSearching = false
+			user.StateChangedAt = time.Now()
+			user.UpdatedAt = user.StateChangedAt
+			stopped++
+		}
+	}
+
+	return stopped, nil
+}
+
+func (r *UserRepository) CountByState(ctx context.Context, state model.UserState) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var count int64
+	for _, user := range r.users {
+		if user.State == state {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (r *UserRepository) GetUsersInStateSince(ctx context.Context, state model.UserState, changedBefore time.Time) ([]*model.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var users []*model.User
+	for _, user := range r.users {
+		if user.State == state && user.StateChangedAt.Before(changedBefore) {
+			users = append(users, cloneUser(user))
+		}
+	}
+
+	return users, nil
+}
+
+func (r *UserRepository) TransitionState(ctx context.Context, userID primitive.ObjectID, t repository.StateTransition) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userID]
+	if !ok || !canTransition(user, t) {
+		return false, nil
+	}
+
+	applyTransition(user, t)
+	return true, nil
+}
+
+func (r *UserRepository) TransitionPair(ctx context.Context, userAID, userBID primitive.ObjectID, t repository.StateTransition) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	userA, okA := r.users[userAID]
+	userB, okB := r.users[userBID]
+	if !okA || !okB || userAID == userBID || !canTransition(userA, t) || !canTransition(userB, t) {
+		return false, nil
+	}
+
+	applyTransition(userA, t)
+	applyTransition(userB, t)
+	return true, nil
+}
+
+// canTransition reports whether the user's state and current link allow t.
+func canTransition(user *model.User, t repository.StateTransition) bool {
+	if !t.OnLink.IsZero() && user.CurrentLinkID != t.OnLink {
+		return false
+	}
+
+	for _, state := range t.From {
+		if user.State == state || (user.State == "" && state == model.UserStateIdle) {
+			return true
+		}
+	}
+	return false
+}
+
+func applyTransition(user *model.User, t repository.StateTransition) {
+	user.State = t.To
+	user.IsSearching = t.To == model.UserStateSearching
+	user.CurrentLinkID = t.LinkID
+	user.StateChangedAt = time.Now()
+	user.UpdatedAt = user.StateChangedAt
+}
+
+type candidate struct {
+	user     *model.User
+	distance float64
+}
+
+// candidates applies the same filters as the MongoDB match pipelines, using
+// a great-circle distance in place of $geoNear.
+func (r *UserRepository) candidates(user *model.User, freshSince time.Time, radius float64) []candidate {
+	if user.Location.IsZero() {
+		return nil
+	}
+
+	minBirthDate := time.Now().AddDate(-user.Preferences.MaxAge-1, 0, 0)
+	maxBirthDate := time.Now().AddDate(-user.Preferences.MinAge, 0, 0)
+	userAge := age(user.Profile.DateOfBirth)
+
+	var matches []candidate
+	for _, other := range r.users {
+		if other.ID == user.ID || other.State != model.UserStateSearching || other.Location.IsZero() {
+			continue
+		}
+		if other.LocationUpdatedAt.Before(freshSince) {
+			continue
+		}
+		if !contains(user.Preferences.Gender, other.Profile.Gender) || !contains(other.Preferences.Gender, user.Profile.Gender) {
+			continue
+		}
+		if other.Profile.DateOfBirth.Before(minBirthDate) || other.Profile.DateOfBirth.After(maxBirthDate) {
+			continue
+		}
+		if other.Preferences.MinAge > userAge || other.Preferences.MaxAge < userAge {
+			continue
+		}
+
+		distance := distanceMeters(user.Location, other.Location)
+		if distance > radius {
+			continue
+		}
+
+		matches = append(matches, candidate{user: other, distance: distance})
+	}
+
+	return matches
+}
+
+func (r *UserRepository) SearchMatches(ctx context.Context, user *model.User, freshSince time.Time, radius float64, limit, offset int) ([]*model.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	matches := r.candidates(user, freshSince, radius)
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].user.ID.Hex() < matches[j].user.ID.Hex()
+	})
+
+	var page []*model.User
+	for i := offset; i < len(matches) && len(page) < limit; i++ {
+		page = append(page, cloneUser(matches[i].user))
+	}
+
+	return page, nil
+}
+
+func (r *UserRepository) FindPotentialMatch(ctx context.Context, user *model.User, freshSince time.Time, radius float64) (*model.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	matches := r.candidates(user, freshSince, radius)
+	if len(matches) == 0 {
+		return nil, nil // No match found
+	}
+
+	return cloneUser(matches[rand.Intn(len(matches))].user), nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func age(birthDate time.Time) int {
+	return int(time.Since(birthDate).Hours() / 24 / 365)
+}
+
+const earthRadiusMeters = 6371000
+
+func distanceMeters(a, b model.GeoLocation) float64 {
+	lng1, lat1 := a.Coordinates[0]*math.Pi/180, a.Coordinates[1]*math.Pi/180
+	lng2, lat2 := b.Coordinates[0]*math.Pi/180, b.Coordinates[1]*math.Pi/180
+
+	sinLat := math.Sin((lat2 - lat1) / 2)
+	sinLng := math.Sin((lng2 - lng1) / 2)
+	h := sinLat*sinLat + math.Cos(lat1)*math.Cos(lat2)*sinLng*sinLng
+
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(h))
+}
+
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, userID primitive.ObjectID, email string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userID]
+	if !ok || user.Email != email || user.EmailVerified() {
+		return false, nil
+	}
+
+	user.EmailVerifiedAt = time.Now()
+	user.UpdatedAt = user.EmailVerifiedAt
+	return true, nil
+}
+
+func (r *UserRepository) ChangePassword(ctx context.Context, userID primitive.ObjectID, oldHash, newHash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userID]
+	if !ok || user.Password != oldHash {
+		return false, nil
+	}
+
+	user.Password = newHash
+	user.UpdatedAt = time.Now()
+	return true, nil
+}
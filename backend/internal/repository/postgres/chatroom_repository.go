@@ -0,0 +1,346 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+)
+
+type ChatroomRepository struct {
+	db       *sql.DB
+	timeouts repository.Timeouts
+}
+
+func NewChatroomRepository(db *sql.DB, timeouts repository.Timeouts) *ChatroomRepository {
+	return &ChatroomRepository{db: db, timeouts: timeouts}
+}
+
+const chatroomColumns = `id, link_id, user_a_id, user_b_id, is_locked,
+	location_paused_by, unlock_taps, used_unlock_nonces,
+	status, closed_at, created_at, updated_at`
+
+func scanChatroom(row scanner) (*model.Chatroom, error) {
+	var (
+		chatroom                 model.Chatroom
+		id, linkID, userA, userB string
+		pausedBy                 []string
+		unlockTaps               []byte
+		closedAt                 sql.NullTime
+	)
+
+	err := row.Scan(
+		&id, &linkID, &userA, &userB, &chatroom.IsLocked,
+		pq.Array(&pausedBy), &unlockTaps, pq.Array(&chatroom.UsedUnlockNonces),
+		&chatroom.Status, &closedAt, &chatroom.CreatedAt, &chatroom.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := parseIDs(id, linkID, userA, userB)
+	if err != nil {
+		return nil, err
+	}
+	chatroom.ID, chatroom.LinkID, chatroom.UserAID, chatroom.UserBID = ids[0], ids[1], ids[2], ids[3]
+
+	if len(pausedBy) > 0 {
+		if chatroom.LocationPausedBy, err = parseIDs(pausedBy...); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(unlockTaps, &chatroom.UnlockTaps); err != nil {
+		return nil, err
+	}
+	if len(chatroom.UnlockTaps) == 0 {
+		chatroom.UnlockTaps = nil
+	}
+	if len(chatroom.UsedUnlockNonces) == 0 {
+		chatroom.UsedUnlockNonces = nil
+	}
+	chatroom.ClosedAt = closedAt.Time
+
+	return &chatroom, nil
+}
+
+func (r *ChatroomRepository) CreateChatroom(ctx context.Context, linkID, userAID, userBID primitive.ObjectID) (*model.Chatroom, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	chatroom := &model.Chatroom{
+		ID:        primitive.NewObjectID(),
+		LinkID:    linkID,
+		UserAID:   userAID,
+		UserBID:   userBID,
+		IsLocked:  true,
+		Status:    model.ChatroomStatusActive,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO chatrooms (id, link_id, user_a_id, user_b_id, is_locked, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		chatroom.ID.Hex(), linkID.Hex(), userAID.Hex(), userBID.Hex(),
+		chatroom.IsLocked, chatroom.Status, chatroom.CreatedAt, chatroom.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return chatroom, nil
+}
+
+func (r *ChatroomRepository) GetChatroom(ctx context.Context, chatroomID primitive.ObjectID) (*model.Chatroom, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	row := r.db.QueryRowContext(ctx, `SELECT `+chatroomColumns+` FROM chatrooms WHERE id = $1`, chatroomID.Hex())
+	chatroom, err := scanChatroom(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, repository.ErrChatroomNotFound
+	}
+
+	return chatroom, err
+}
+
+// UnlockChatroom unlocks the chatroom if it's still locked, reporting whether
+// this call did. A QR unlock's nonce replaces any already recorded, since a
+// chatroom only unlocks once.
+func (r *ChatroomRepository) UnlockChatroom(ctx context.Context, chatroomID primitive.ObjectID, nonce string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE chatrooms SET
+			is_locked = FALSE,
+			used_unlock_nonces = CASE WHEN $2 = '' THEN used_unlock_nonces ELSE ARRAY[$2::text] END,
+			updated_at = $3
+		WHERE id = $1 AND is_locked AND NOT ($2::text = ANY(used_unlock_nonces))`,
+		chatroomID.Hex(), nonce, time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return affectedOne(result)
+}
+
+// CloseChatroom moves an open chatroom to a closed status. It reports whether
+// this call closed it, so concurrent closes only clean up once.
+func (r *ChatroomRepository) CloseChatroom(ctx context.Context, chatroomID primitive.ObjectID, status model.ChatroomStatus) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE chatrooms SET status = $2, closed_at = $3, updated_at = $3
+		WHERE id = $1 AND closed_at IS NULL`,
+		chatroomID.Hex(), status, time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return affectedOne(result)
+}
+
+// GetExpiredLockedChatrooms returns open chatrooms that are still locked and
+// were created before the given time.
+func (r *ChatroomRepository) GetExpiredLockedChatrooms(ctx context.Context, createdBefore time.Time) ([]*model.Chatroom, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Search)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+chatroomColumns+` FROM chatrooms
+		WHERE is_locked AND closed_at IS NULL AND created_at < $1`,
+		createdBefore,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chatrooms []*model.Chatroom
+	for rows.Next() {
+		chatroom, err := scanChatroom(rows)
+		if err != nil {
+			return nil, err
+		}
+		chatrooms = append(chatrooms, chatroom)
+	}
+
+	return chatrooms, rows.Err()
+}
+
+// RecordUnlockTap stores when userID last tapped to unlock the chatroom.
+func (r *ChatroomRepository) RecordUnlockTap(ctx context.Context, chatroomID, userID primitive.ObjectID, tappedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	// The tap time is formatted here so it decodes back into a time.Time
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE chatrooms SET unlock_taps = unlock_taps || jsonb_build_object($2::text, $3::text), updated_at = $4
+		WHERE id = $1`,
+		chatroomID.Hex(), userID.Hex(), tappedAt.Format(time.RFC3339Nano), time.Now(),
+	)
+	return err
+}
+
+func (r *ChatroomRepository) AddMessage(ctx context.Context, chatroomID, senderID primitive.ObjectID, content string) (*model.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	message := &model.Message{
+		ID:         primitive.NewObjectID(),
+		ChatroomID: chatroomID,
+		SenderID:   senderID,
+		Content:    content,
+		CreatedAt:  time.Now(),
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO messages (id, chatroom_id, sender_id, content, created_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		message.ID.Hex(), chatroomID.Hex(), senderID.Hex(), content, message.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+func (r *ChatroomRepository) GetMessages(ctx context.Context, chatroomID primitive.ObjectID) ([]*model.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, sender_id, content, created_at FROM messages
+		WHERE chatroom_id = $1
+		ORDER BY created_at, id`,
+		chatroomID.Hex(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*model.Message
+	for rows.Next() {
+		var id, senderID string
+		message := &model.Message{ChatroomID: chatroomID}
+		if err := rows.Scan(&id, &senderID, &message.Content, &message.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		ids, err := parseIDs(id, senderID)
+		if err != nil {
+			return nil, err
+		}
+		message.ID, message.SenderID = ids[0], ids[1]
+
+		messages = append(messages, message)
+	}
+
+	return messages, rows.Err()
+}
+
+func (r *ChatroomRepository) SetLocationSharingPaused(ctx context.Context, chatroomID, userID primitive.ObjectID, paused bool) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	// Removing the user first keeps the array free of duplicates
+	pausedBy := `array_remove(location_paused_by, $2::text)`
+	if paused {
+		pausedBy = `array_append(` + pausedBy + `, $2::text)`
+	}
+
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE chatrooms SET location_paused_by = `+pausedBy+`, updated_at = $3 WHERE id = $1`,
+		chatroomID.Hex(), userID.Hex(), time.Now(),
+	)
+	return err
+}
+
+// SaveSharedLocation keeps only the latest position per participant.
+func (r *ChatroomRepository) SaveSharedLocation(ctx context.Context, chatroomID, userID primitive.ObjectID, latitude, longitude float64) (*model.SharedLocation, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	location := &model.SharedLocation{
+		ChatroomID: chatroomID,
+		UserID:     userID,
+		Location: model.GeoLocation{
+			Type:        "Point",
+			Coordinates: []float64{longitude, latitude},
+		},
+		UpdatedAt: time.Now(),
+	}
+
+	var id string
+	err := r.db.QueryRowContext(ctx, `
+		INSERT INTO chatroom_locations (id, chatroom_id, user_id, location, updated_at)
+		VALUES ($1, $2, $3, `+geographyPoint("$4", "$5")+`, $6)
+		ON CONFLICT (chatroom_id, user_id)
+		DO UPDATE SET location = EXCLUDED.location, updated_at = EXCLUDED.updated_at
+		RETURNING id`,
+		primitive.NewObjectID().Hex(), chatroomID.Hex(), userID.Hex(), longitude, latitude, location.UpdatedAt,
+	).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	if location.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+
+	return location, nil
+}
+
+// GetSharedLocation returns the participant's latest position, or nil if
+// there isn't one within the retention window.
+func (r *ChatroomRepository) GetSharedLocation(ctx context.Context, chatroomID, userID primitive.ObjectID) (*model.SharedLocation, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	var (
+		id       string
+		lng, lat sql.NullFloat64
+	)
+	location := &model.SharedLocation{ChatroomID: chatroomID, UserID: userID}
+
+	err := r.db.QueryRowContext(ctx, `
+		SELECT id, ST_X(location::geometry), ST_Y(location::geometry), updated_at
+		FROM chatroom_locations
+		WHERE chatroom_id = $1 AND user_id = $2 AND updated_at > $3`,
+		chatroomID.Hex(), userID.Hex(), time.Now().Add(-repository.SharedLocationRetention),
+	).Scan(&id, &lng, &lat, &location.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if location.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	location.Location = geoLocation(lng, lat)
+
+	return location, nil
+}
+
+func (r *ChatroomRepository) DeleteSharedLocations(ctx context.Context, chatroomID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `DELETE FROM chatroom_locations WHERE chatroom_id = $1`, chatroomID.Hex())
+	return err
+}
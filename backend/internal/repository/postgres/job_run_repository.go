@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+)
+
+type JobRunRepository struct {
+	db       *sql.DB
+	timeouts repository.Timeouts
+}
+
+func NewJobRunRepository(db *sql.DB, timeouts repository.Timeouts) *JobRunRepository {
+	return &JobRunRepository{db: db, timeouts: timeouts}
+}
+
+// Record saves the run and, in place of a TTL index, deletes the job's runs
+// that have fallen out of the retention window.
+func (r *JobRunRepository) Record(ctx context.Context, run *model.JobRun) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	run.ID = primitive.NewObjectID()
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO job_runs (id, job, holder, started_at, finished_at, error)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		run.ID.Hex(), run.Job, run.Holder, run.StartedAt, run.FinishedAt, run.Error,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx,
+		`DELETE FROM job_runs WHERE job = $1 AND started_at < $2`,
+		run.Job, time.Now().Add(-repository.JobRunRetention),
+	)
+	return err
+}
+
+// Recent returns the job's latest runs across all instances, newest first.
+func (r *JobRunRepository) Recent(ctx context.Context, job string, limit int) ([]*model.JobRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, holder, started_at, finished_at, error FROM job_runs
+		WHERE job = $1
+		ORDER BY started_at DESC
+		LIMIT $2`,
+		job, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []*model.JobRun
+	for rows.Next() {
+		var id string
+		run := &model.JobRun{Job: job}
+		if err := rows.Scan(&id, &run.Holder, &run.StartedAt, &run.FinishedAt, &run.Error); err != nil {
+			return nil, err
+		}
+		if run.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
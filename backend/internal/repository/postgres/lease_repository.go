@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+)
+
+type LeaseRepository struct {
+	db       *sql.DB
+	timeouts repository.Timeouts
+}
+
+func NewLeaseRepository(db *sql.DB, timeouts repository.Timeouts) *LeaseRepository {
+	return &LeaseRepository{db: db, timeouts: timeouts}
+}
+
+// Acquire takes or renews the named lease for holder. It reports false when
+// another holder has a lease that hasn't expired yet.
+func (r *LeaseRepository) Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	// If someone else holds an unexpired lease the conflict update is
+	// skipped and no row is affected
+	now := time.Now()
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO leases (name, holder, expires_at, renewed_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (name) DO UPDATE
+		SET holder = EXCLUDED.holder, expires_at = EXCLUDED.expires_at, renewed_at = EXCLUDED.renewed_at
+		WHERE leases.holder = EXCLUDED.holder OR leases.expires_at < EXCLUDED.renewed_at`,
+		name, holder, now.Add(ttl), now,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return affectedOne(result)
+}
+
+func (r *LeaseRepository) Get(ctx context.Context, name string) (*model.Lease, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	lease := &model.Lease{Name: name}
+	err := r.db.QueryRowContext(ctx,
+		`SELECT holder, expires_at, renewed_at FROM leases WHERE name = $1`, name,
+	).Scan(&lease.Holder, &lease.ExpiresAt, &lease.RenewedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return lease, nil
+}
+
+// Release gives up the named lease if holder still owns it.
+func (r *LeaseRepository) Release(ctx context.Context, name, holder string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `DELETE FROM leases WHERE name = $1 AND holder = $2`, name, holder)
+	return err
+}
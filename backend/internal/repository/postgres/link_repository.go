@@ -0,0 +1,153 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+)
+
+type LinkRepository struct {
+	db       *sql.DB
+	timeouts repository.Timeouts
+}
+
+func NewLinkRepository(db *sql.DB, timeouts repository.Timeouts) *LinkRepository {
+	return &LinkRepository{db: db, timeouts: timeouts}
+}
+
+const linkColumns = `id, user_a_id, user_b_id, status, created_at, expires_at`
+
+func scanLink(row scanner) (*model.Link, error) {
+	var (
+		link             model.Link
+		id, userA, userB string
+	)
+
+	err := row.Scan(&id, &userA, &userB, &link.Status, &link.CreatedAt, &link.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := parseIDs(id, userA, userB)
+	if err != nil {
+		return nil, err
+	}
+	link.ID, link.UserAID, link.UserBID = ids[0], ids[1], ids[2]
+
+	return &link, nil
+}
+
+func (r *LinkRepository) CreateLink(ctx context.Context, userAID, userBID primitive.ObjectID, ttl time.Duration) (*model.Link, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	now := time.Now()
+	link := &model.Link{
+		ID:        primitive.NewObjectID(),
+		UserAID:   userAID,
+		UserBID:   userBID,
+		Status:    model.LinkStatusPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO links (id, user_a_id, user_b_id, status, created_at, expires_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $5)`,
+		link.ID.Hex(), userAID.Hex(), userBID.Hex(), link.Status, link.CreatedAt, link.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
+func (r *LinkRepository) UpdateLinkStatus(ctx context.Context, linkID primitive.ObjectID, status model.LinkStatus) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE links SET status = $2, updated_at = $3 WHERE id = $1`,
+		linkID.Hex(), status, time.Now(),
+	)
+	return err
+}
+
+func (r *LinkRepository) GetLink(ctx context.Context, linkID primitive.ObjectID) (*model.Link, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	row := r.db.QueryRowContext(ctx, `SELECT `+linkColumns+` FROM links WHERE id = $1`, linkID.Hex())
+	link, err := scanLink(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, repository.ErrLinkNotFound
+	}
+
+	return link, err
+}
+
+// ExpireLink marks the link expired if it's still pending. It reports whether
+// this call expired it, so each link is only handled once.
+func (r *LinkRepository) ExpireLink(ctx context.Context, linkID primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE links SET status = $2, updated_at = $4 WHERE id = $1 AND status = $3`,
+		linkID.Hex(), model.LinkStatusExpired, model.LinkStatusPending, time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return affectedOne(result)
+}
+
+// AnswerLink sets the link's status if it's still pending and hasn't
+// expired yet, reporting whether it did.
+func (r *LinkRepository) AnswerLink(ctx context.Context, linkID primitive.ObjectID, status model.LinkStatus) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE links SET status = $2, updated_at = $4 WHERE id = $1 AND status = $3 AND expires_at > $4`,
+		linkID.Hex(), status, model.LinkStatusPending, time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return affectedOne(result)
+}
+
+func (r *LinkRepository) GetPendingLinks(ctx context.Context) ([]*model.Link, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Search)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+linkColumns+` FROM links WHERE status = $1`,
+		model.LinkStatusPending,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []*model.Link
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+
+	return links, rows.Err()
+}
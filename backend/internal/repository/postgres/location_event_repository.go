@@ -0,0 +1,80 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+)
+
+// locationEventChannel is the NOTIFY channel location events go out on.
+const locationEventChannel = "location_events"
+
+// LocationEventRepository publishes events with NOTIFY and listens on a
+// connection of its own, since LISTEN doesn't work through a pool.
+// Notifications sent while that connection is down are lost.
+type LocationEventRepository struct {
+	db         *sql.DB
+	connString string
+	timeouts   repository.Timeouts
+}
+
+// NewLocationEventRepository publishes through db and listens by opening
+// connString, which should name the same database.
+func NewLocationEventRepository(db *sql.DB, connString string, timeouts repository.Timeouts) *LocationEventRepository {
+	return &LocationEventRepository{db: db, connString: connString, timeouts: timeouts}
+}
+
+func (r *LocationEventRepository) Publish(ctx context.Context, event repository.LocationEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, locationEventChannel, string(payload))
+	return err
+}
+
+func (r *LocationEventRepository) Listen(ctx context.Context, handle func(repository.LocationEvent)) error {
+	listener := pq.NewListener(r.connString, time.Second, time.Minute, nil)
+	defer listener.Close()
+	// Listen waits for a connection, however long that takes
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+
+	if err := listener.Listen(locationEventChannel); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	// A quiet connection isn't noticed dropping without a ping now and then
+	ping := time.NewTicker(time.Minute)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ping.C:
+			go listener.Ping()
+		case notification := <-listener.Notify:
+			// Sent after reconnecting
+			if notification == nil {
+				continue
+			}
+			var event repository.LocationEvent
+			if err := json.Unmarshal([]byte(notification.Extra), &event); err != nil {
+				return err
+			}
+			handle(event)
+		}
+	}
+}
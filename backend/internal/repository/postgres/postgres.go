@@ -0,0 +1,97 @@
+// Package postgres provides PostgreSQL implementations of the repository
+// stores. Positions are stored as PostGIS geography points, so radius
+// queries are measured in metres just like MongoDB's 2dsphere index. IDs are
+// kept as ObjectID hex strings so both backends hand out the same kind of ID.
+//
+// The schema lives in db/migrations and is applied with cmd/migrate.
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	_ repository.UserStore     = (*UserRepository)(nil)
+	_ repository.LinkStore     = (*LinkRepository)(nil)
+	_ repository.ChatroomStore = (*ChatroomRepository)(nil)
+	_ repository.LeaseStore    = (*LeaseRepository)(nil)
+	_ repository.JobRunStore   = (*JobRunRepository)(nil)
+
+	_ repository.LoginAttemptStore  = (*LoginAttemptRepository)(nil)
+	_ repository.SecurityEventStore = (*SecurityEventRepository)(nil)
+	_ repository.LocationEventStore = (*LocationEventRepository)(nil)
+)
+
+// geographyPoint builds a PostGIS point from two longitude/latitude
+// parameters. A NULL longitude or latitude gives a NULL point.
+func geographyPoint(lng, lat string) string {
+	return "ST_SetSRID(ST_MakePoint(" + lng + ", " + lat + "), 4326)::geography"
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// nullID stores the nil ObjectID as NULL.
+func nullID(id primitive.ObjectID) sql.NullString {
+	if id.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: id.Hex(), Valid: true}
+}
+
+func parseNullID(s sql.NullString) (primitive.ObjectID, error) {
+	if !s.Valid {
+		return primitive.NilObjectID, nil
+	}
+	return primitive.ObjectIDFromHex(s.String)
+}
+
+// parseIDs parses ObjectID hex strings, stopping at the first bad one.
+func parseIDs(hexes ...string) ([]primitive.ObjectID, error) {
+	ids := make([]primitive.ObjectID, len(hexes))
+	for i, hex := range hexes {
+		id, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+func geoLocation(lng, lat sql.NullFloat64) model.GeoLocation {
+	if !lng.Valid || !lat.Valid {
+		return model.GeoLocation{}
+	}
+	return model.GeoLocation{
+		Type:        "Point",
+		Coordinates: []float64{lng.Float64, lat.Float64},
+	}
+}
+
+// coordinates splits a location into NULL-able longitude and latitude.
+func coordinates(location model.GeoLocation) (sql.NullFloat64, sql.NullFloat64) {
+	if len(location.Coordinates) < 2 {
+		return sql.NullFloat64{}, sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: location.Coordinates[0], Valid: true},
+		sql.NullFloat64{Float64: location.Coordinates[1], Valid: true}
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func affectedOne(result sql.Result) (bool, error) {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
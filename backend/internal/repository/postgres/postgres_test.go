@@ -0,0 +1,81 @@
+package postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/url"
+	"os"
+	"testing"
+
+	_ "github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/seunghoon34/linkapp/backend/db/migrations"
+	"github.com/seunghoon34/linkapp/backend/internal/migrate"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+	"github.com/seunghoon34/linkapp/backend/internal/repository/postgres"
+	"github.com/seunghoon34/linkapp/backend/internal/repository/repotest"
+)
+
+// postgresDatabase returns a connection to a freshly migrated schema on the
+// PostGIS server at POSTGRES_TEST_URL, a postgres:// URL. The schema is
+// dropped again when the test ends, and the test is skipped if the variable
+// isn't set.
+func postgresDatabase(t *testing.T) *sql.DB {
+	t.Helper()
+
+	rawURL := os.Getenv("POSTGRES_TEST_URL")
+	if rawURL == "" {
+		t.Skip("POSTGRES_TEST_URL isn't set")
+	}
+
+	ctx := context.Background()
+	admin, err := sql.Open("postgres", rawURL)
+	if err != nil {
+		t.Fatalf("connecting to Postgres: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := "linkapp_test_" + primitive.NewObjectID().Hex()
+	if _, err := admin.ExecContext(ctx, `CREATE SCHEMA `+schema); err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	t.Cleanup(func() { admin.ExecContext(ctx, `DROP SCHEMA `+schema+` CASCADE`) })
+
+	// PostGIS lives in public, so keep it on the path behind the new schema
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("parsing POSTGRES_TEST_URL: %v", err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema+",public")
+	u.RawQuery = query.Encode()
+
+	db, err := sql.Open("postgres", u.String())
+	if err != nil {
+		t.Fatalf("connecting to Postgres: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	target, err := migrate.NewPostgres(db, migrations.FS)
+	if err != nil {
+		t.Fatalf("reading migrations: %v", err)
+	}
+	if err := migrate.NewMigrator(target, false, io.Discard).Up(ctx, 0); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+
+	return db
+}
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Stores {
+		db := postgresDatabase(t)
+		return repotest.Stores{
+			Users:     postgres.NewUserRepository(db, repository.DefaultTimeouts),
+			Links:     postgres.NewLinkRepository(db, repository.DefaultTimeouts),
+			Chatrooms: postgres.NewChatroomRepository(db, repository.DefaultTimeouts),
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+)
+
+type LoginAttemptRepository struct {
+	db       *sql.DB
+	timeouts repository.Timeouts
+}
+
+func NewLoginAttemptRepository(db *sql.DB, timeouts repository.Timeouts) *LoginAttemptRepository {
+	return &LoginAttemptRepository{db: db, timeouts: timeouts}
+}
+
+func (r *LoginAttemptRepository) Failures(ctx context.Context, key string) (*model.LoginFailures, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	failures := &model.LoginFailures{Key: key}
+	err := r.db.QueryRowContext(ctx, `
+		SELECT failures, last_failure_at, expires_at FROM login_failures
+		WHERE key = $1 AND expires_at > $2`,
+		key, time.Now(),
+	).Scan(&failures.Failures, &failures.LastFailureAt, &failures.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return failures, nil
+}
+
+// RecordFailure counts the failure in a single upsert, so concurrent
+// failures for the same key are all counted. In place of a TTL index it
+// also deletes the counts that have been forgotten.
+func (r *LoginAttemptRepository) RecordFailure(ctx context.Context, key string, forgetAfter time.Duration) (*model.LoginFailures, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	now := time.Now()
+	failures := &model.LoginFailures{Key: key}
+	err := r.db.QueryRowContext(ctx, `
+		INSERT INTO login_failures (key, failures, last_failure_at, expires_at)
+		VALUES ($1, 1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_failures.expires_at > $2 THEN login_failures.failures + 1 ELSE 1 END,
+			last_failure_at = $2,
+			expires_at = $3
+		RETURNING failures, last_failure_at, expires_at`,
+		key, now, now.Add(forgetAfter),
+	).Scan(&failures.Failures, &failures.LastFailureAt, &failures.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.db.ExecContext(ctx, `DELETE FROM login_failures WHERE expires_at < $1`, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return failures, nil
+}
+
+func (r *LoginAttemptRepository) Clear(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `DELETE FROM login_failures WHERE key = $1`, key)
+	return err
+}
+
+type SecurityEventRepository struct {
+	db       *sql.DB
+	timeouts repository.Timeouts
+}
+
+func NewSecurityEventRepository(db *sql.DB, timeouts repository.Timeouts) *SecurityEventRepository {
+	return &SecurityEventRepository{db: db, timeouts: timeouts}
+}
+
+// Record saves the event and, in place of a TTL index, deletes the events
+// that have fallen out of the retention window.
+func (r *SecurityEventRepository) Record(ctx context.Context, event *model.SecurityEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	event.ID = primitive.NewObjectID()
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO security_events (id, type, user_id, ip, failures, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		event.ID.Hex(), event.Type, nullID(event.UserID), event.IP, event.Failures, event.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx,
+		`DELETE FROM security_events WHERE created_at < $1`,
+		time.Now().Add(-repository.SecurityEventRetention),
+	)
+	return err
+}
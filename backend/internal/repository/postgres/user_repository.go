@@ -0,0 +1,545 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+)
+
+type UserRepository struct {
+	db       *sql.DB
+	timeouts repository.Timeouts
+}
+
+func NewUserRepository(db *sql.DB, timeouts repository.Timeouts) *UserRepository {
+	return &UserRepository{db: db, timeouts: timeouts}
+}
+
+const userColumns = `id, username, email, password, email_verified_at,
+	first_name, last_name, date_of_birth, gender, bio, profile_pic_url,
+	min_age, max_age, preferred_genders,
+	ST_X(location::geometry), ST_Y(location::geometry), location_updated_at,
+	state, current_link_id, state_changed_at, created_at, updated_at`
+
+func scanUser(row scanner) (*model.User, error) {
+	var (
+		user              model.User
+		id                string
+		lng, lat          sql.NullFloat64
+		locationUpdatedAt sql.NullTime
+		currentLinkID     sql.NullString
+		stateChangedAt    sql.NullTime
+		emailVerifiedAt   sql.NullTime
+	)
+
+	err := row.Scan(
+		&id, &user.Username, &user.Email, &user.Password, &emailVerifiedAt,
+		&user.Profile.FirstName, &user.Profile.LastName, &user.Profile.DateOfBirth,
+		&user.Profile.Gender, &user.Profile.Bio, &user.Profile.ProfilePicURL,
+		&user.Preferences.MinAge, &user.Preferences.MaxAge, pq.Array(&user.Preferences.Gender),
+		&lng, &lat, &locationUpdatedAt,
+		&user.State, &currentLinkID, &stateChangedAt, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	if user.CurrentLinkID, err = parseNullID(currentLinkID); err != nil {
+		return nil, err
+	}
+	user.Location = geoLocation(lng, lat)
+	user.LocationUpdatedAt = locationUpdatedAt.Time
+	user.StateChangedAt = stateChangedAt.Time
+	user.EmailVerifiedAt = emailVerifiedAt.Time
+	user.IsSearching = user.State == model.UserStateSearching
+
+	return &user, nil
+}
+
+func scanUsers(rows *sql.Rows) ([]*model.User, error) {
+	defer rows.Close()
+
+	var users []*model.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func (r *UserRepository) Create(ctx context.Context, user *model.User) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = time.Now()
+	if user.State == "" {
+		user.State = model.UserStateIdle
+	}
+	user.IsSearching = user.State == model.UserStateSearching
+
+	lng, lat := coordinates(user.Location)
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO users (
+			id, username, email, password,
+			first_name, last_name, date_of_birth, gender, bio, profile_pic_url,
+			min_age, max_age, preferred_genders,
+			location, location_updated_at,
+			state, current_link_id, created_at, updated_at, email_verified_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13,
+			`+geographyPoint("$14", "$15")+`, $16, $17, $18, $19, $20, $21)`,
+		user.ID.Hex(), user.Username, user.Email, user.Password,
+		user.Profile.FirstName, user.Profile.LastName, user.Profile.DateOfBirth,
+		user.Profile.Gender, user.Profile.Bio, user.Profile.ProfilePicURL,
+		user.Preferences.MinAge, user.Preferences.MaxAge, pq.Array(user.Preferences.Gender),
+		lng, lat, nullTime(user.LocationUpdatedAt),
+		user.State, nullID(user.CurrentLinkID), user.CreatedAt, user.UpdatedAt, nullTime(user.EmailVerifiedAt),
+	)
+	return conflictError(err)
+}
+
+// conflictError turns a unique violation on users.email or users.username
+// into a ConflictError naming the field.
+func conflictError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+		return err
+	}
+
+	switch pqErr.Constraint {
+	case "users_email_key":
+		return &repository.ConflictError{Field: "email"}
+	case "users_username_key":
+		return &repository.ConflictError{Field: "username"}
+	}
+
+	return err
+}
+
+func (r *UserRepository) GetByID(ctx context.Context, id string) (*model.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	row := r.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, objectID.Hex())
+	user, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, repository.ErrUserNotFound
+	}
+
+	return user, err
+}
+
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*model.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	row := r.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE email = $1`, email)
+	user, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, repository.ErrUserNotFound
+	}
+
+	return user, err
+}
+
+// Update saves the user's account details: username, email and whether the
+// email is verified. Everything else has its own narrower update.
+func (r *UserRepository) Update(ctx context.Context, user *model.User) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	user.UpdatedAt = time.Now()
+
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE users SET
+			username = $2, email = $3, email_verified_at = $4, updated_at = $5
+		WHERE id = $1`,
+		user.ID.Hex(), user.Username, user.Email, nullTime(user.EmailVerifiedAt), user.UpdatedAt,
+	)
+	return conflictError(err)
+}
+
+func (r *UserRepository) UpdateProfile(ctx context.Context, id string, profile model.Profile) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, `
+		UPDATE users SET
+			first_name = $2, last_name = $3, date_of_birth = $4,
+			gender = $5, bio = $6, profile_pic_url = $7,
+			updated_at = $8
+		WHERE id = $1`,
+		objectID.Hex(), profile.FirstName, profile.LastName, profile.DateOfBirth,
+		profile.Gender, profile.Bio, profile.ProfilePicURL,
+		time.Now(),
+	)
+	return err
+}
+
+func (r *UserRepository) UpdatePreferences(ctx context.Context, id string, preferences model.Preferences) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, `
+		UPDATE users SET min_age = $2, max_age = $3, preferred_genders = $4, updated_at = $5
+		WHERE id = $1`,
+		objectID.Hex(), preferences.MinAge, preferences.MaxAge, pq.Array(preferences.Gender), time.Now(),
+	)
+	return err
+}
+
+func (r *UserRepository) UpdateLocation(ctx context.Context, userID string, latitude, longitude float64) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET location = `+geographyPoint("$2", "$3")+`,
+			location_updated_at = $4, updated_at = $4
+		WHERE id = $1`,
+		objectID.Hex(), longitude, latitude, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO location_history (id, user_id, location, created_at)
+		VALUES ($1, $2, `+geographyPoint("$3", "$4")+`, $5)`,
+		primitive.NewObjectID().Hex(), objectID.Hex(), longitude, latitude, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetLocationHistory returns the user's retained location updates, newest
+// first. Entries past the retention window are skipped even if
+// ClearStaleLocations hasn't deleted them yet.
+func (r *UserRepository) GetLocationHistory(ctx context.Context, userID primitive.ObjectID) ([]*model.LocationUpdate, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, ST_X(location::geometry), ST_Y(location::geometry), created_at
+		FROM location_history
+		WHERE user_id = $1 AND created_at > $2
+		ORDER BY created_at DESC`,
+		userID.Hex(), time.Now().Add(-repository.LocationHistoryRetention),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []*model.LocationUpdate
+	for rows.Next() {
+		var (
+			id       string
+			lng, lat sql.NullFloat64
+		)
+		update := &model.LocationUpdate{UserID: userID}
+		if err := rows.Scan(&id, &lng, &lat, &update.CreatedAt); err != nil {
+			return nil, err
+		}
+		if update.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+			return nil, err
+		}
+		update.Location = geoLocation(lng, lat)
+		history = append(history, update)
+	}
+
+	return history, rows.Err()
+}
+
+// ClearStaleLocations forgets the coordinates of every user whose last
+// location update is older than before. Postgres has no TTL indexes, so it
+// also deletes location history past the retention window.
+func (r *UserRepository) ClearStaleLocations(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Search)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx,
+		`DELETE FROM location_history WHERE created_at < $1`,
+		time.Now().Add(-repository.LocationHistoryRetention),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE users SET location = NULL, location_updated_at = NULL, updated_at = $2
+		WHERE location_updated_at < $1`,
+		before, time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// StopStaleSearches moves every searching user whose location hasn't been
+// updated since freshSince back to idle.
+func (r *UserRepository) StopStaleSearches(ctx context.Context, freshSince time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Search)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE users SET state = $1, state_changed_at = $4, updated_at = $4
+		WHERE state = $2 AND (location_updated_at IS NULL OR location_updated_at < $3)`,
+		model.UserStateIdle, model.UserStateSearching, freshSince, time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// CountByState counts the users currently in state.
+func (r *UserRepository) CountByState(ctx context.Context, state model.UserState) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	var count int64
+	err := r.db.QueryRowContext(ctx, `SELECT count(*) FROM users WHERE state = $1`, state).Scan(&count)
+	return count, err
+}
+
+// GetUsersInStateSince lists the users that entered state before
+// changedBefore and are still in it.
+func (r *UserRepository) GetUsersInStateSince(ctx context.Context, state model.UserState, changedBefore time.Time) ([]*model.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Search)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+userColumns+` FROM users WHERE state = $1 AND state_changed_at < $2`,
+		state, changedBefore,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanUsers(rows)
+}
+
+// TransitionState applies the transition in a single guarded update. It
+// reports whether the user was in an allowed state and so was moved.
+func (r *UserRepository) TransitionState(ctx context.Context, userID primitive.ObjectID, t repository.StateTransition) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	from := make([]string, len(t.From))
+	for i, state := range t.From {
+		from[i] = string(state)
+	}
+
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE users SET state = $2, current_link_id = $3, state_changed_at = $4, updated_at = $4
+		WHERE id = $1
+			AND state = ANY($5)
+			AND ($6::text IS NULL OR current_link_id = $6)`,
+		userID.Hex(), t.To, nullID(t.LinkID), time.Now(), pq.Array(from), nullID(t.OnLink),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return affectedOne(result)
+}
+
+// TransitionPair applies the same transition to both users in a single
+// statement: both rows are locked and checked first, and only updated if
+// both are allowed to move. It reports whether they were.
+func (r *UserRepository) TransitionPair(ctx context.Context, userAID, userBID primitive.ObjectID, t repository.StateTransition) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	from := make([]string, len(t.From))
+	for i, state := range t.From {
+		from[i] = string(state)
+	}
+
+	result, err := r.db.ExecContext(ctx, `
+		WITH allowed AS (
+			SELECT id FROM users
+			WHERE id IN ($1, $2)
+				AND state = ANY($6)
+				AND ($7::text IS NULL OR current_link_id = $7)
+			FOR UPDATE
+		)
+		UPDATE users SET state = $3, current_link_id = $4, state_changed_at = $5, updated_at = $5
+		WHERE id IN (SELECT id FROM allowed)
+			AND (SELECT count(*) FROM allowed) = 2`,
+		userAID.Hex(), userBID.Hex(), t.To, nullID(t.LinkID), time.Now(), pq.Array(from), nullID(t.OnLink),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 2, nil
+}
+
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, userID primitive.ObjectID, email string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE users SET email_verified_at = $3, updated_at = $3
+		WHERE id = $1 AND email = $2 AND email_verified_at IS NULL`,
+		userID.Hex(), email, time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return affectedOne(result)
+}
+
+func (r *UserRepository) ChangePassword(ctx context.Context, userID primitive.ObjectID, oldHash, newHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE users SET password = $3, updated_at = $4
+		WHERE id = $1 AND password = $2`,
+		userID.Hex(), oldHash, newHash, time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return affectedOne(result)
+}
+
+// matchConditions selects searching users near the searcher who fit their
+// preferences and whose preferences the searcher fits in turn. The first
+// eleven parameters come from matchArgs.
+var matchConditions = `
+	id <> $3
+	AND state = $4
+	AND location_updated_at >= $5
+	AND ST_DWithin(location, ` + geographyPoint("$1", "$2") + `, $6)
+	AND gender = ANY($7)
+	AND date_of_birth BETWEEN $8 AND $9
+	AND $10 = ANY(preferred_genders)
+	AND min_age <= $11 AND max_age >= $11`
+
+func matchArgs(user *model.User, freshSince time.Time, radius float64) []any {
+	// Calculate min and max birth dates based on age preferences
+	minBirthDate := time.Now().AddDate(-user.Preferences.MaxAge-1, 0, 0)
+	maxBirthDate := time.Now().AddDate(-user.Preferences.MinAge, 0, 0)
+
+	return []any{
+		user.Location.Coordinates[0], user.Location.Coordinates[1],
+		user.ID.Hex(),
+		model.UserStateSearching,
+		freshSince,
+		radius,
+		pq.Array(user.Preferences.Gender),
+		minBirthDate, maxBirthDate,
+		user.Profile.Gender,
+		age(user.Profile.DateOfBirth),
+	}
+}
+
+func age(birthDate time.Time) int {
+	return int(time.Since(birthDate).Hours() / 24 / 365)
+}
+
+func (r *UserRepository) SearchMatches(ctx context.Context, user *model.User, freshSince time.Time, radius float64, limit, offset int) ([]*model.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Search)
+	defer cancel()
+
+	if user.Location.IsZero() {
+		return nil, nil
+	}
+
+	// Break ties between equidistant users so pages are stable
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+userColumns+` FROM users
+		WHERE `+matchConditions+`
+		ORDER BY ST_Distance(location, `+geographyPoint("$1", "$2")+`), id
+		LIMIT $12 OFFSET $13`,
+		append(matchArgs(user, freshSince, radius), limit, offset)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanUsers(rows)
+}
+
+func (r *UserRepository) FindPotentialMatch(ctx context.Context, user *model.User, freshSince time.Time, radius float64) (*model.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Search)
+	defer cancel()
+
+	if user.Location.IsZero() {
+		return nil, nil
+	}
+
+	row := r.db.QueryRowContext(ctx, `
+		SELECT `+userColumns+` FROM users
+		WHERE `+matchConditions+`
+		ORDER BY random()
+		LIMIT 1`,
+		matchArgs(user, freshSince, radius)...,
+	)
+	match, err := scanUser(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil // No match found
+	}
+
+	return match, err
+}
@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrLinkNotFound     = errors.New("link not found")
+	ErrChatroomNotFound = errors.New("chatroom not found")
+	ErrConflict         = errors.New("already taken")
+)
+
+// ConflictError reports that a write would give a unique field, such as a
+// user's email, a value another record already has. It matches ErrConflict.
+type ConflictError struct {
+	Field string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Field + " is already taken"
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// Timeouts caps how long a single storage call may run. The deadline is
+// added to the caller's context, so a call still stops early when the
+// request behind it is cancelled.
+type Timeouts struct {
+	// Query bounds reads and writes of a few documents
+	Query time.Duration
+	// Search bounds geo searches and sweeps over whole collections
+	Search time.Duration
+}
+
+var DefaultTimeouts = Timeouts{
+	Query:  10 * time.Second,
+	Search: 30 * time.Second,
+}
+
+// UserStore is the storage the service layer needs for users.
+type UserStore interface {
+	Create(ctx context.Context, user *model.User) error
+	GetByID(ctx context.Context, id string) (*model.User, error)
+	GetByEmail(ctx context.Context, email string) (*model.User, error)
+	Update(ctx context.Context, user *model.User) error
+	UpdateProfile(ctx context.Context, id string, profile model.Profile) error
+	UpdatePreferences(ctx context.Context, id string, preferences model.Preferences) error
+	UpdateLocation(ctx context.Context, userID string, latitude, longitude float64) error
+	GetLocationHistory(ctx context.Context, userID primitive.ObjectID) ([]*model.LocationUpdate, error)
+	ClearStaleLocations(ctx context.Context, before time.Time) (int64, error)
+	StopStaleSearches(ctx context.Context, freshSince time.Time) (int64, error)
+	CountByState(ctx context.Context, state model.UserState) (int64, error)
+	// GetUsersInStateSince lists the users that entered state before
+	// changedBefore and are still in it
+	GetUsersInStateSince(ctx context.Context, state model.UserState, changedBefore time.Time) ([]*model.User, error)
+	TransitionState(ctx context.Context, userID primitive.ObjectID, t StateTransition) (bool, error)
+	// TransitionPair applies t to both users atomically, reporting whether
+	// both were allowed to move; if either wasn't, neither is changed
+	TransitionPair(ctx context.Context, userAID, userBID primitive.ObjectID, t StateTransition) (bool, error)
+	// MarkEmailVerified verifies the user's email if it's still email and
+	// isn't verified yet, reporting whether it did
+	MarkEmailVerified(ctx context.Context, userID primitive.ObjectID, email string) (bool, error)
+	// ChangePassword replaces the user's password hash if it's still
+	// oldHash, reporting whether it did
+	ChangePassword(ctx context.Context, userID primitive.ObjectID, oldHash, newHash string) (bool, error)
+	SearchMatches(ctx context.Context, user *model.User, freshSince time.Time, radius float64, limit, offset int) ([]*model.User, error)
+	FindPotentialMatch(ctx context.Context, user *model.User, freshSince time.Time, radius float64) (*model.User, error)
+}
+
+// LinkStore is the storage the service layer needs for links.
+type LinkStore interface {
+	CreateLink(ctx context.Context, userAID, userBID primitive.ObjectID, ttl time.Duration) (*model.Link, error)
+	GetLink(ctx context.Context, linkID primitive.ObjectID) (*model.Link, error)
+	UpdateLinkStatus(ctx context.Context, linkID primitive.ObjectID, status model.LinkStatus) error
+	ExpireLink(ctx context.Context, linkID primitive.ObjectID) (bool, error)
+	// AnswerLink sets the status of a link that's still pending and hasn't
+	// reached its ExpiresAt, reporting whether it did
+	AnswerLink(ctx context.Context, linkID primitive.ObjectID, status model.LinkStatus) (bool, error)
+	GetPendingLinks(ctx context.Context) ([]*model.Link, error)
+}
+
+// ChatroomStore is the storage the service layer needs for chatrooms, their
+// messages and the locations shared in them.
+type ChatroomStore interface {
+	CreateChatroom(ctx context.Context, linkID, userAID, userBID primitive.ObjectID) (*model.Chatroom, error)
+	GetChatroom(ctx context.Context, chatroomID primitive.ObjectID) (*model.Chatroom, error)
+	// UnlockChatroom unlocks the chatroom if it's still locked and, for a QR
+	// unlock, nonce hasn't been used on it, reporting whether it did. A
+	// non-empty nonce is recorded in the same update
+	UnlockChatroom(ctx context.Context, chatroomID primitive.ObjectID, nonce string) (bool, error)
+	CloseChatroom(ctx context.Context, chatroomID primitive.ObjectID, status model.ChatroomStatus) (bool, error)
+	GetExpiredLockedChatrooms(ctx context.Context, createdBefore time.Time) ([]*model.Chatroom, error)
+	RecordUnlockTap(ctx context.Context, chatroomID, userID primitive.ObjectID, tappedAt time.Time) error
+	AddMessage(ctx context.Context, chatroomID, senderID primitive.ObjectID, content string) (*model.Message, error)
+	GetMessages(ctx context.Context, chatroomID primitive.ObjectID) ([]*model.Message, error)
+	SetLocationSharingPaused(ctx context.Context, chatroomID, userID primitive.ObjectID, paused bool) error
+	SaveSharedLocation(ctx context.Context, chatroomID, userID primitive.ObjectID, latitude, longitude float64) (*model.SharedLocation, error)
+	GetSharedLocation(ctx context.Context, chatroomID, userID primitive.ObjectID) (*model.SharedLocation, error)
+	DeleteSharedLocations(ctx context.Context, chatroomID primitive.ObjectID) error
+}
+
+// LeaseStore hands out the named leases that elect a leader for each
+// background job.
+type LeaseStore interface {
+	Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	Get(ctx context.Context, name string) (*model.Lease, error)
+	Release(ctx context.Context, name, holder string) error
+}
+
+// JobRunStore keeps the run history of background jobs.
+type JobRunStore interface {
+	Record(ctx context.Context, run *model.JobRun) error
+	Recent(ctx context.Context, job string, limit int) ([]*model.JobRun, error)
+}
+
+// LoginAttemptStore counts failed logins by key, such as an account or an
+// IP address, forgetting a count once no failure has been added to it for a
+// while.
+type LoginAttemptStore interface {
+	// Failures returns the key's count, or nil if it has none
+	Failures(ctx context.Context, key string) (*model.LoginFailures, error)
+	// RecordFailure adds a failure to the key's count, which is forgotten
+	// after forgetAfter unless another failure comes first
+	RecordFailure(ctx context.Context, key string, forgetAfter time.Duration) (*model.LoginFailures, error)
+	Clear(ctx context.Context, key string) error
+}
+
+// SecurityEventStore keeps the security audit trail.
+type SecurityEventStore interface {
+	Record(ctx context.Context, event *model.SecurityEvent) error
+}
+
+// LocationEvent is a change to a chatroom's live location sharing: a
+// participant's new position, or, when Closed is set, the end of sharing.
+type LocationEvent struct {
+	ChatroomID primitive.ObjectID    `json:"chatroom_id"`
+	Location   *model.SharedLocation `json:"location,omitempty"`
+	Closed     bool                  `json:"closed,omitempty"`
+}
+
+// LocationEventStore carries location events between the instances of the
+// API, so a stream open on one sees positions shared through any other.
+type LocationEventStore interface {
+	Publish(ctx context.Context, event LocationEvent) error
+	// Listen calls handle with every event published from here on, by any
+	// instance, until ctx is done or the feed fails. Events published while
+	// nobody is listening are lost.
+	Listen(ctx context.Context, handle func(LocationEvent)) error
+}
+
+var (
+	_ UserStore     = (*UserRepository)(nil)
+	_ LinkStore     = (*LinkRepository)(nil)
+	_ ChatroomStore = (*ChatroomRepository)(nil)
+	_ LeaseStore    = (*LeaseRepository)(nil)
+	_ JobRunStore   = (*JobRunRepository)(nil)
+
+	_ LoginAttemptStore  = (*LoginAttemptRepository)(nil)
+	_ SecurityEventStore = (*SecurityEventRepository)(nil)
+	_ LocationEventStore = (*LocationEventRepository)(nil)
+)
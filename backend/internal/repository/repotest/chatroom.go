@@ -0,0 +1,237 @@
+package repotest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+)
+
+var chatroomTests = []conformanceTest{
+	{"CreateChatroom", testCreateChatroom},
+	{"UnlockChatroomWithNonce", testUnlockChatroomWithNonce},
+	{"UnlockChatroomWithoutNonce", testUnlockChatroomWithoutNonce},
+	{"CloseChatroom", testCloseChatroom},
+	{"RecordUnlockTap", testRecordUnlockTap},
+	{"Messages", testMessages},
+	{"SharedLocations", testSharedLocations},
+}
+
+func testCreateChatroom(t *testing.T, s Stores) {
+	ctx := context.Background()
+	created := createChatroom(t, s)
+
+	chatroom := getChatroom(t, s, created.ID)
+	if chatroom.LinkID != created.LinkID || chatroom.UserAID != created.UserAID || chatroom.UserBID != created.UserBID {
+		t.Errorf("chatroom %+v doesn't match the one created, %+v", chatroom, created)
+	}
+	if !chatroom.IsLocked || chatroom.IsClosed() || chatroom.Status != model.ChatroomStatusActive {
+		t.Errorf("new chatroom is locked %v, closed %v, %s; want locked and active", chatroom.IsLocked, chatroom.IsClosed(), chatroom.Status)
+	}
+	if len(chatroom.UsedUnlockNonces) != 0 || len(chatroom.UnlockTaps) != 0 || len(chatroom.LocationPausedBy) != 0 {
+		t.Errorf("new chatroom has nonces %v, taps %v, paused %v", chatroom.UsedUnlockNonces, chatroom.UnlockTaps, chatroom.LocationPausedBy)
+	}
+
+	if _, err := s.Chatrooms.GetChatroom(ctx, primitive.NewObjectID()); !errors.Is(err, repository.ErrChatroomNotFound) {
+		t.Errorf("GetChatroom() for an unknown chatroom error = %v, want ErrChatroomNotFound", err)
+	}
+}
+
+func testUnlockChatroomWithNonce(t *testing.T, s Stores) {
+	ctx := context.Background()
+	chatroom := createChatroom(t, s)
+
+	if unlocked, err := s.Chatrooms.UnlockChatroom(ctx, chatroom.ID, "first"); err != nil || !unlocked {
+		t.Fatalf("UnlockChatroom() = %v, %v; want true, nil", unlocked, err)
+	}
+
+	got := getChatroom(t, s, chatroom.ID)
+	if got.IsLocked {
+		t.Error("chatroom is still locked")
+	}
+	if !slices.Equal(got.UsedUnlockNonces, []string{"first"}) {
+		t.Errorf("used nonces = %v, want the one it was unlocked with", got.UsedUnlockNonces)
+	}
+
+	for _, nonce := range []string{"first", "second", ""} {
+		if unlocked, err := s.Chatrooms.UnlockChatroom(ctx, chatroom.ID, nonce); err != nil || unlocked {
+			t.Errorf("UnlockChatroom(%q) once unlocked = %v, %v; want false, nil", nonce, unlocked, err)
+		}
+	}
+	if got := getChatroom(t, s, chatroom.ID); !slices.Equal(got.UsedUnlockNonces, []string{"first"}) {
+		t.Errorf("used nonces = %v after failed unlocks, want them unchanged", got.UsedUnlockNonces)
+	}
+
+	if unlocked, err := s.Chatrooms.UnlockChatroom(ctx, primitive.NewObjectID(), "first"); err != nil || unlocked {
+		t.Errorf("UnlockChatroom() for an unknown chatroom = %v, %v; want false, nil", unlocked, err)
+	}
+}
+
+func testUnlockChatroomWithoutNonce(t *testing.T, s Stores) {
+	ctx := context.Background()
+	chatroom := createChatroom(t, s)
+
+	if unlocked, err := s.Chatrooms.UnlockChatroom(ctx, chatroom.ID, ""); err != nil || !unlocked {
+		t.Fatalf("UnlockChatroom() = %v, %v; want true, nil", unlocked, err)
+	}
+
+	got := getChatroom(t, s, chatroom.ID)
+	if got.IsLocked || len(got.UsedUnlockNonces) != 0 {
+		t.Errorf("chatroom is locked %v with nonces %v, want unlocked with none", got.IsLocked, got.UsedUnlockNonces)
+	}
+
+	if unlocked, err := s.Chatrooms.UnlockChatroom(ctx, chatroom.ID, ""); err != nil || unlocked {
+		t.Errorf("second UnlockChatroom() = %v, %v; want false, nil", unlocked, err)
+	}
+}
+
+func testCloseChatroom(t *testing.T, s Stores) {
+	ctx := context.Background()
+	closing := createChatroom(t, s)
+	unlocked := createChatroom(t, s)
+	locked := createChatroom(t, s)
+
+	if ok, err := s.Chatrooms.UnlockChatroom(ctx, unlocked.ID, ""); err != nil || !ok {
+		t.Fatalf("unlocking: %v, %v", ok, err)
+	}
+
+	if closed, err := s.Chatrooms.CloseChatroom(ctx, closing.ID, model.ChatroomStatusExpired); err != nil || !closed {
+		t.Fatalf("CloseChatroom() = %v, %v; want true, nil", closed, err)
+	}
+	if closed, err := s.Chatrooms.CloseChatroom(ctx, closing.ID, model.ChatroomStatusUnmatched); err != nil || closed {
+		t.Errorf("second CloseChatroom() = %v, %v; want false, nil", closed, err)
+	}
+
+	got := getChatroom(t, s, closing.ID)
+	if !got.IsClosed() || got.Status != model.ChatroomStatusExpired {
+		t.Errorf("chatroom is closed %v, %s; want closed and expired", got.IsClosed(), got.Status)
+	}
+
+	expired, err := s.Chatrooms.GetExpiredLockedChatrooms(ctx, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("GetExpiredLockedChatrooms() error = %v", err)
+	}
+	if len(expired) != 1 || expired[0].ID != locked.ID {
+		t.Errorf("got %d expired locked chatrooms, want only the open locked one", len(expired))
+	}
+
+	expired, err = s.Chatrooms.GetExpiredLockedChatrooms(ctx, time.Now().Add(-time.Minute))
+	if err != nil || len(expired) != 0 {
+		t.Errorf("GetExpiredLockedChatrooms() before any were created = %d, %v; want none", len(expired), err)
+	}
+}
+
+func testRecordUnlockTap(t *testing.T, s Stores) {
+	chatroom := createChatroom(t, s)
+	tappedAt := time.Now().Add(-time.Second)
+
+	if err := s.Chatrooms.RecordUnlockTap(context.Background(), chatroom.ID, chatroom.UserAID, tappedAt); err != nil {
+		t.Fatalf("RecordUnlockTap() error = %v", err)
+	}
+
+	taps := getChatroom(t, s, chatroom.ID).UnlockTaps
+	got, ok := taps[chatroom.UserAID.Hex()]
+	if len(taps) != 1 || !ok {
+		t.Fatalf("taps = %v, want one by the first user", taps)
+	}
+	// Stores keep times at millisecond precision or better
+	if d := got.Sub(tappedAt); d < -time.Millisecond || d > time.Millisecond {
+		t.Errorf("tapped at %v, want %v", got, tappedAt)
+	}
+}
+
+func testMessages(t *testing.T, s Stores) {
+	ctx := context.Background()
+	chatroom := createChatroom(t, s)
+	other := createChatroom(t, s)
+
+	if _, err := s.Chatrooms.AddMessage(ctx, other.ID, other.UserAID, "elsewhere"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		sender  primitive.ObjectID
+		content string
+	}{
+		{chatroom.UserAID, "hi"},
+		{chatroom.UserBID, "hello"},
+		{chatroom.UserAID, "how are you?"},
+	}
+	for _, m := range want {
+		message, err := s.Chatrooms.AddMessage(ctx, chatroom.ID, m.sender, m.content)
+		if err != nil {
+			t.Fatalf("AddMessage() error = %v", err)
+		}
+		if message.ID.IsZero() || message.ChatroomID != chatroom.ID {
+			t.Errorf("AddMessage() = %+v, want a message in the chatroom", message)
+		}
+	}
+
+	messages, err := s.Chatrooms.GetMessages(ctx, chatroom.ID)
+	if err != nil {
+		t.Fatalf("GetMessages() error = %v", err)
+	}
+	if len(messages) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(messages), len(want))
+	}
+	// Oldest first
+	for i, message := range messages {
+		if message.SenderID != want[i].sender || message.Content != want[i].content {
+			t.Errorf("message %d is %q from %s, want %q from %s", i, message.Content, message.SenderID.Hex(), want[i].content, want[i].sender.Hex())
+		}
+	}
+}
+
+func testSharedLocations(t *testing.T, s Stores) {
+	ctx := context.Background()
+	chatroom := createChatroom(t, s)
+
+	for _, paused := range []bool{true, true} {
+		if err := s.Chatrooms.SetLocationSharingPaused(ctx, chatroom.ID, chatroom.UserAID, paused); err != nil {
+			t.Fatalf("SetLocationSharingPaused() error = %v", err)
+		}
+	}
+	if got := getChatroom(t, s, chatroom.ID).LocationPausedBy; !slices.Equal(got, []primitive.ObjectID{chatroom.UserAID}) {
+		t.Errorf("paused by %v, want only the first user", got)
+	}
+	if err := s.Chatrooms.SetLocationSharingPaused(ctx, chatroom.ID, chatroom.UserAID, false); err != nil {
+		t.Fatalf("SetLocationSharingPaused() error = %v", err)
+	}
+	if got := getChatroom(t, s, chatroom.ID).LocationPausedBy; len(got) != 0 {
+		t.Errorf("paused by %v after resuming, want nobody", got)
+	}
+
+	if location, err := s.Chatrooms.GetSharedLocation(ctx, chatroom.ID, chatroom.UserAID); err != nil || location != nil {
+		t.Fatalf("GetSharedLocation() before sharing = %v, %v; want nil, nil", location, err)
+	}
+
+	// Only the latest position is kept
+	for _, point := range [][]float64{{10, 20}, {11, 21}} {
+		if _, err := s.Chatrooms.SaveSharedLocation(ctx, chatroom.ID, chatroom.UserAID, point[1], point[0]); err != nil {
+			t.Fatalf("SaveSharedLocation() error = %v", err)
+		}
+	}
+	location, err := s.Chatrooms.GetSharedLocation(ctx, chatroom.ID, chatroom.UserAID)
+	if err != nil || location == nil {
+		t.Fatalf("GetSharedLocation() = %v, %v; want the shared location", location, err)
+	}
+	if !slices.Equal(location.Location.Coordinates, []float64{11, 21}) {
+		t.Errorf("shared location = %v, want the latest", location.Location.Coordinates)
+	}
+	if peer, err := s.Chatrooms.GetSharedLocation(ctx, chatroom.ID, chatroom.UserBID); err != nil || peer != nil {
+		t.Errorf("GetSharedLocation() for the other user = %v, %v; want nil, nil", peer, err)
+	}
+
+	if err := s.Chatrooms.DeleteSharedLocations(ctx, chatroom.ID); err != nil {
+		t.Fatalf("DeleteSharedLocations() error = %v", err)
+	}
+	if location, err := s.Chatrooms.GetSharedLocation(ctx, chatroom.ID, chatroom.UserAID); err != nil || location != nil {
+		t.Errorf("GetSharedLocation() after deleting = %v, %v; want nil, nil", location, err)
+	}
+}
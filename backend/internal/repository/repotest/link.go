@@ -0,0 +1,109 @@
+package repotest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+)
+
+var linkTests = []conformanceTest{
+	{"CreateLink", testCreateLink},
+	{"AnswerLink", testAnswerLink},
+	{"AnswerExpiredLink", testAnswerExpiredLink},
+	{"UpdateLinkStatus", testUpdateLinkStatus},
+}
+
+func testCreateLink(t *testing.T, s Stores) {
+	ctx := context.Background()
+	created := createLink(t, s, time.Minute)
+
+	link := getLink(t, s, created.ID)
+	if link.UserAID != created.UserAID || link.UserBID != created.UserBID {
+		t.Errorf("link is between %s and %s, want %s and %s", link.UserAID.Hex(), link.UserBID.Hex(), created.UserAID.Hex(), created.UserBID.Hex())
+	}
+	if link.Status != model.LinkStatusPending {
+		t.Errorf("new link is %s, want pending", link.Status)
+	}
+	if d := link.ExpiresAt.Sub(link.CreatedAt); d < time.Minute-time.Second || d > time.Minute+time.Second {
+		t.Errorf("link expires %v after it was created, want a minute", d)
+	}
+
+	pending, err := s.Links.GetPendingLinks(ctx)
+	if err != nil {
+		t.Fatalf("GetPendingLinks() error = %v", err)
+	}
+	if len(pending) != 1 || pending[0].ID != created.ID {
+		t.Errorf("got %d pending links, want the new one", len(pending))
+	}
+
+	if _, err := s.Links.GetLink(ctx, primitive.NewObjectID()); !errors.Is(err, repository.ErrLinkNotFound) {
+		t.Errorf("GetLink() for an unknown link error = %v, want ErrLinkNotFound", err)
+	}
+}
+
+func testAnswerLink(t *testing.T, s Stores) {
+	ctx := context.Background()
+	link := createLink(t, s, time.Minute)
+
+	if answered, err := s.Links.AnswerLink(ctx, link.ID, model.LinkStatusAccepted); err != nil || !answered {
+		t.Fatalf("AnswerLink() = %v, %v; want true, nil", answered, err)
+	}
+	if answered, err := s.Links.AnswerLink(ctx, link.ID, model.LinkStatusRejected); err != nil || answered {
+		t.Errorf("second AnswerLink() = %v, %v; want false, nil", answered, err)
+	}
+	if expired, err := s.Links.ExpireLink(ctx, link.ID); err != nil || expired {
+		t.Errorf("ExpireLink() on an answered link = %v, %v; want false, nil", expired, err)
+	}
+	if got := getLink(t, s, link.ID).Status; got != model.LinkStatusAccepted {
+		t.Errorf("link is %s, want accepted", got)
+	}
+
+	pending, err := s.Links.GetPendingLinks(ctx)
+	if err != nil || len(pending) != 0 {
+		t.Errorf("GetPendingLinks() = %d links, %v; want none", len(pending), err)
+	}
+
+	if answered, err := s.Links.AnswerLink(ctx, primitive.NewObjectID(), model.LinkStatusAccepted); err != nil || answered {
+		t.Errorf("AnswerLink() for an unknown link = %v, %v; want false, nil", answered, err)
+	}
+}
+
+func testAnswerExpiredLink(t *testing.T, s Stores) {
+	ctx := context.Background()
+	link := createLink(t, s, -time.Second)
+
+	// Past its ExpiresAt the link can't be answered, even before it's expired
+	if answered, err := s.Links.AnswerLink(ctx, link.ID, model.LinkStatusAccepted); err != nil || answered {
+		t.Fatalf("AnswerLink() past ExpiresAt = %v, %v; want false, nil", answered, err)
+	}
+	if got := getLink(t, s, link.ID).Status; got != model.LinkStatusPending {
+		t.Errorf("link is %s, want it left pending for expiry", got)
+	}
+
+	if expired, err := s.Links.ExpireLink(ctx, link.ID); err != nil || !expired {
+		t.Fatalf("ExpireLink() = %v, %v; want true, nil", expired, err)
+	}
+	if expired, err := s.Links.ExpireLink(ctx, link.ID); err != nil || expired {
+		t.Errorf("second ExpireLink() = %v, %v; want false, nil", expired, err)
+	}
+	if got := getLink(t, s, link.ID).Status; got != model.LinkStatusExpired {
+		t.Errorf("link is %s, want expired", got)
+	}
+}
+
+func testUpdateLinkStatus(t *testing.T, s Stores) {
+	link := createLink(t, s, time.Minute)
+
+	if err := s.Links.UpdateLinkStatus(context.Background(), link.ID, model.LinkStatusExpired); err != nil {
+		t.Fatalf("UpdateLinkStatus() error = %v", err)
+	}
+	if got := getLink(t, s, link.ID).Status; got != model.LinkStatusExpired {
+		t.Errorf("link is %s, want expired", got)
+	}
+}
@@ -0,0 +1,171 @@
+// Package repotest is the conformance suite shared by the storage backends.
+// Each backend runs it from its own tests against fresh stores, so the
+// service layer sees the same behaviour whichever one is configured.
+package repotest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+)
+
+// Stores are the stores under test. They share a database, since links and
+// chatrooms refer to users.
+type Stores struct {
+	Users     repository.UserStore
+	Links     repository.LinkStore
+	Chatrooms repository.ChatroomStore
+}
+
+type conformanceTest struct {
+	name string
+	test func(t *testing.T, s Stores)
+}
+
+// Run runs every conformance test as a subtest, each against empty stores
+// from newStores.
+func Run(t *testing.T, newStores func(t *testing.T) Stores) {
+	var tests []conformanceTest
+	tests = append(tests, userTests...)
+	tests = append(tests, linkTests...)
+	tests = append(tests, chatroomTests...)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStores(t))
+		})
+	}
+}
+
+// NewUser returns an unsaved idle user with the given ID. Every user it
+// makes is a 30 year old woman looking for women of any adult age, so they
+// all match each other.
+func NewUser(id primitive.ObjectID) *model.User {
+	return &model.User{
+		ID:       id,
+		Username: "user" + id.Hex(),
+		Email:    id.Hex() + "@example.com",
+		Password: "hash",
+		Profile: model.Profile{
+			DateOfBirth: time.Now().AddDate(-30, 0, 0),
+			Gender:      "female",
+		},
+		Preferences: model.Preferences{MinAge: 18, MaxAge: 99, Gender: []string{"female"}},
+	}
+}
+
+// createUser stores a new user from NewUser.
+func createUser(t *testing.T, s Stores) *model.User {
+	t.Helper()
+	return createUserWithID(t, s, primitive.NewObjectID())
+}
+
+// createUserWithID is createUser for a user with a chosen ID, which every
+// store has to keep.
+func createUserWithID(t *testing.T, s Stores, id primitive.ObjectID) *model.User {
+	t.Helper()
+
+	user := NewUser(id)
+	if err := s.Users.Create(context.Background(), user); err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	if user.ID != id {
+		t.Fatalf("Create() replaced the ID %s with %s", id.Hex(), user.ID.Hex())
+	}
+
+	return user
+}
+
+// createSearcher stores a user searching at the given point.
+func createSearcher(t *testing.T, s Stores, longitude, latitude float64) *model.User {
+	t.Helper()
+	return startSearching(t, s, createUser(t, s).ID, longitude, latitude)
+}
+
+// startSearching moves an idle user to the given point and starts them
+// searching.
+func startSearching(t *testing.T, s Stores, id primitive.ObjectID, longitude, latitude float64) *model.User {
+	t.Helper()
+	ctx := context.Background()
+
+	if err := s.Users.UpdateLocation(ctx, id.Hex(), latitude, longitude); err != nil {
+		t.Fatalf("updating location: %v", err)
+	}
+	moved, err := s.Users.TransitionState(ctx, id, repository.StateTransition{
+		From: []model.UserState{model.UserStateIdle},
+		To:   model.UserStateSearching,
+	})
+	if err != nil || !moved {
+		t.Fatalf("starting search: moved %v, err %v", moved, err)
+	}
+
+	return getUser(t, s, id)
+}
+
+func getUser(t *testing.T, s Stores, id primitive.ObjectID) *model.User {
+	t.Helper()
+
+	user, err := s.Users.GetByID(context.Background(), id.Hex())
+	if err != nil {
+		t.Fatalf("loading user: %v", err)
+	}
+	return user
+}
+
+// createLink links two new users with a link that expires after ttl.
+func createLink(t *testing.T, s Stores, ttl time.Duration) *model.Link {
+	t.Helper()
+
+	userA, userB := createUser(t, s), createUser(t, s)
+	link, err := s.Links.CreateLink(context.Background(), userA.ID, userB.ID, ttl)
+	if err != nil {
+		t.Fatalf("creating link: %v", err)
+	}
+	return link
+}
+
+func getLink(t *testing.T, s Stores, id primitive.ObjectID) *model.Link {
+	t.Helper()
+
+	link, err := s.Links.GetLink(context.Background(), id)
+	if err != nil {
+		t.Fatalf("loading link: %v", err)
+	}
+	return link
+}
+
+// createChatroom opens a chatroom for the users of a new link.
+func createChatroom(t *testing.T, s Stores) *model.Chatroom {
+	t.Helper()
+
+	link := createLink(t, s, time.Minute)
+	chatroom, err := s.Chatrooms.CreateChatroom(context.Background(), link.ID, link.UserAID, link.UserBID)
+	if err != nil {
+		t.Fatalf("creating chatroom: %v", err)
+	}
+	return chatroom
+}
+
+func getChatroom(t *testing.T, s Stores, id primitive.ObjectID) *model.Chatroom {
+	t.Helper()
+
+	chatroom, err := s.Chatrooms.GetChatroom(context.Background(), id)
+	if err != nil {
+		t.Fatalf("loading chatroom: %v", err)
+	}
+	return chatroom
+}
+
+// ids lists the IDs of users, in order.
+func ids(users []*model.User) []primitive.ObjectID {
+	found := make([]primitive.ObjectID, len(users))
+	for i, user := range users {
+		found[i] = user.ID
+	}
+	return found
+}
@@ -0,0 +1,485 @@
+package repotest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+)
+
+var userTests = []conformanceTest{
+	{"CreateUser", testCreateUser},
+	{"CreateUserConflict", testCreateUserConflict},
+	{"UpdateUser", testUpdateUser},
+	{"TransitionState", testTransitionState},
+	{"TransitionPair", testTransitionPair},
+	{"GetUsersInStateSince", testGetUsersInStateSince},
+	{"MarkEmailVerified", testMarkEmailVerified},
+	{"ChangePassword", testChangePassword},
+	{"LocationHistory", testLocationHistory},
+	{"StopStaleSearches", testStopStaleSearches},
+	{"SearchMatches", testSearchMatches},
+	{"FindPotentialMatch", testFindPotentialMatch},
+}
+
+func testCreateUser(t *testing.T, s Stores) {
+	ctx := context.Background()
+	created := createUser(t, s)
+
+	user := getUser(t, s, created.ID)
+	if user.Username != created.Username || user.Email != created.Email {
+		t.Errorf("got %s <%s>, want %s <%s>", user.Username, user.Email, created.Username, created.Email)
+	}
+	if user.State != model.UserStateIdle || user.IsSearching || !user.CurrentLinkID.IsZero() {
+		t.Errorf("new user is %s, searching %v, on link %s; want idle with no link", user.State, user.IsSearching, user.CurrentLinkID.Hex())
+	}
+	if user.EmailVerified() {
+		t.Error("new user's email is verified")
+	}
+	if user.Profile.Gender != "female" || !slices.Equal(user.Preferences.Gender, []string{"female"}) {
+		t.Errorf("profile %+v, preferences %+v weren't stored", user.Profile, user.Preferences)
+	}
+
+	byEmail, err := s.Users.GetByEmail(ctx, created.Email)
+	if err != nil {
+		t.Fatalf("GetByEmail() error = %v", err)
+	}
+	if byEmail.ID != created.ID {
+		t.Errorf("GetByEmail() = %s, want %s", byEmail.ID.Hex(), created.ID.Hex())
+	}
+
+	if _, err := s.Users.GetByID(ctx, primitive.NewObjectID().Hex()); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("GetByID() for an unknown user error = %v, want ErrUserNotFound", err)
+	}
+	if _, err := s.Users.GetByEmail(ctx, "nobody@example.com"); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("GetByEmail() for an unknown email error = %v, want ErrUserNotFound", err)
+	}
+}
+
+func testCreateUserConflict(t *testing.T, s Stores) {
+	ctx := context.Background()
+	existing := createUser(t, s)
+
+	tests := []struct {
+		name      string
+		user      *model.User
+		wantField string
+	}{
+		{
+			name:      "email",
+			user:      &model.User{Username: "someone-else", Email: existing.Email},
+			wantField: "email",
+		},
+		{
+			name:      "username",
+			user:      &model.User{Username: existing.Username, Email: "someone-else@example.com"},
+			wantField: "username",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.user.Profile.DateOfBirth = time.Now().AddDate(-30, 0, 0)
+
+			err := s.Users.Create(ctx, tt.user)
+			var conflict *repository.ConflictError
+			if !errors.As(err, &conflict) || !errors.Is(err, repository.ErrConflict) {
+				t.Fatalf("Create() error = %v, want a ConflictError", err)
+			}
+			if conflict.Field != tt.wantField {
+				t.Errorf("conflict on %q, want %q", conflict.Field, tt.wantField)
+			}
+		})
+	}
+}
+
+func testUpdateUser(t *testing.T, s Stores) {
+	ctx := context.Background()
+	other := createUser(t, s)
+
+	// A copy loaded before the user verified their email, started searching
+	// and edited their profile
+	stale := createUser(t, s)
+	if _, err := s.Users.MarkEmailVerified(ctx, stale.ID, stale.Email); err != nil {
+		t.Fatal(err)
+	}
+	startSearching(t, s, stale.ID, 0, 0)
+	if err := s.Users.UpdateProfile(ctx, stale.ID.Hex(), model.Profile{
+		DateOfBirth: stale.Profile.DateOfBirth,
+		Gender:      "female",
+		Bio:         "fresh",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Users.ChangePassword(ctx, stale.ID, stale.Password, "new hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	stale.Username = "renamed" + stale.ID.Hex()
+	stale.Email = "renamed" + stale.ID.Hex() + "@example.com"
+	stale.Profile.Bio = "stale"
+	if err := s.Users.Update(ctx, stale); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	user := getUser(t, s, stale.ID)
+	if user.Username != stale.Username || user.Email != stale.Email {
+		t.Errorf("got %s <%s>, want %s <%s>", user.Username, user.Email, stale.Username, stale.Email)
+	}
+	if user.EmailVerified() {
+		t.Error("changed email is still verified")
+	}
+	if user.State != model.UserStateSearching || user.Location.IsZero() || user.Password != "new hash" {
+		t.Errorf("Update() overwrote state %s, location %v or password", user.State, user.Location.Coordinates)
+	}
+	if user.Profile.Bio != "fresh" {
+		t.Errorf("Update() overwrote the profile: bio = %q", user.Profile.Bio)
+	}
+
+	stale.Email = other.Email
+	if err := s.Users.Update(ctx, stale); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("Update() to a taken email error = %v, want ErrConflict", err)
+	}
+}
+
+func testTransitionState(t *testing.T, s Stores) {
+	ctx := context.Background()
+	user := createUser(t, s)
+	linkID := primitive.NewObjectID()
+
+	tests := []struct {
+		name      string
+		t         repository.StateTransition
+		wantMoved bool
+		wantState model.UserState
+		wantLink  primitive.ObjectID
+	}{
+		{
+			name:      "allowed",
+			t:         repository.StateTransition{From: []model.UserState{model.UserStateIdle}, To: model.UserStateSearching},
+			wantMoved: true,
+			wantState: model.UserStateSearching,
+		},
+		{
+			name:      "from another state",
+			t:         repository.StateTransition{From: []model.UserState{model.UserStateIdle}, To: model.UserStateLinked, LinkID: linkID},
+			wantState: model.UserStateSearching,
+		},
+		{
+			name:      "onto a link",
+			t:         repository.StateTransition{From: []model.UserState{model.UserStateIdle, model.UserStateSearching}, To: model.UserStateLinked, LinkID: linkID},
+			wantMoved: true,
+			wantState: model.UserStateLinked,
+			wantLink:  linkID,
+		},
+		{
+			name:      "on another link",
+			t:         repository.StateTransition{From: []model.UserState{model.UserStateLinked}, To: model.UserStateSearching, OnLink: primitive.NewObjectID()},
+			wantState: model.UserStateLinked,
+			wantLink:  linkID,
+		},
+		{
+			name:      "off the link",
+			t:         repository.StateTransition{From: []model.UserState{model.UserStateLinked}, To: model.UserStateSearching, OnLink: linkID},
+			wantMoved: true,
+			wantState: model.UserStateSearching,
+		},
+	}
+	// Each case starts where the one before left off
+	for _, tt := range tests {
+		moved, err := s.Users.TransitionState(ctx, user.ID, tt.t)
+		if err != nil {
+			t.Fatalf("%s: TransitionState() error = %v", tt.name, err)
+		}
+		if moved != tt.wantMoved {
+			t.Errorf("%s: moved = %v, want %v", tt.name, moved, tt.wantMoved)
+		}
+
+		got := getUser(t, s, user.ID)
+		if got.State != tt.wantState || got.CurrentLinkID != tt.wantLink {
+			t.Errorf("%s: user is %s on link %s, want %s on link %s", tt.name, got.State, got.CurrentLinkID.Hex(), tt.wantState, tt.wantLink.Hex())
+		}
+		if got.IsSearching != (got.State == model.UserStateSearching) {
+			t.Errorf("%s: is_searching = %v for a %s user", tt.name, got.IsSearching, got.State)
+		}
+	}
+
+	if moved, err := s.Users.TransitionState(ctx, primitive.NewObjectID(), tests[0].t); err != nil || moved {
+		t.Errorf("TransitionState() for an unknown user = %v, %v; want false, nil", moved, err)
+	}
+}
+
+func testTransitionPair(t *testing.T, s Stores) {
+	ctx := context.Background()
+	userA := createSearcher(t, s, 0, 0)
+	userB := createSearcher(t, s, 0, 0)
+	idle := createUser(t, s)
+
+	linkID := primitive.NewObjectID()
+	link := repository.StateTransition{
+		From:   []model.UserState{model.UserStateSearching},
+		To:     model.UserStateLinked,
+		LinkID: linkID,
+	}
+
+	// Neither moves if one of them can't
+	if moved, err := s.Users.TransitionPair(ctx, userA.ID, idle.ID, link); err != nil || moved {
+		t.Fatalf("TransitionPair() with an idle user = %v, %v; want false, nil", moved, err)
+	}
+	if got := getUser(t, s, userA.ID); got.State != model.UserStateSearching || !got.CurrentLinkID.IsZero() {
+		t.Errorf("user moved to %s on link %s when their pair couldn't", got.State, got.CurrentLinkID.Hex())
+	}
+	if got := getUser(t, s, idle.ID); got.State != model.UserStateIdle {
+		t.Errorf("idle user moved to %s", got.State)
+	}
+
+	if moved, err := s.Users.TransitionPair(ctx, userA.ID, userA.ID, link); err != nil || moved {
+		t.Errorf("TransitionPair() with the same user twice = %v, %v; want false, nil", moved, err)
+	}
+
+	if moved, err := s.Users.TransitionPair(ctx, userA.ID, userB.ID, link); err != nil || !moved {
+		t.Fatalf("TransitionPair() = %v, %v; want true, nil", moved, err)
+	}
+	for _, id := range []primitive.ObjectID{userA.ID, userB.ID} {
+		if got := getUser(t, s, id); got.State != model.UserStateLinked || got.CurrentLinkID != linkID || got.IsSearching {
+			t.Errorf("user is %s on link %s, searching %v; want linked on %s", got.State, got.CurrentLinkID.Hex(), got.IsSearching, linkID.Hex())
+		}
+	}
+
+	// Both are already linked
+	if moved, err := s.Users.TransitionPair(ctx, userA.ID, userB.ID, link); err != nil || moved {
+		t.Errorf("repeated TransitionPair() = %v, %v; want false, nil", moved, err)
+	}
+
+	chat := repository.StateTransition{
+		From:   []model.UserState{model.UserStateLinked},
+		To:     model.UserStateInChat,
+		OnLink: primitive.NewObjectID(),
+		LinkID: linkID,
+	}
+	if moved, err := s.Users.TransitionPair(ctx, userA.ID, userB.ID, chat); err != nil || moved {
+		t.Errorf("TransitionPair() on another link = %v, %v; want false, nil", moved, err)
+	}
+	chat.OnLink = linkID
+	if moved, err := s.Users.TransitionPair(ctx, userA.ID, userB.ID, chat); err != nil || !moved {
+		t.Errorf("TransitionPair() on their link = %v, %v; want true, nil", moved, err)
+	}
+}
+
+func testGetUsersInStateSince(t *testing.T, s Stores) {
+	ctx := context.Background()
+	before := time.Now().Add(-time.Minute)
+	searcher := createSearcher(t, s, 0, 0)
+	createUser(t, s)
+	after := time.Now().Add(time.Minute)
+
+	users, err := s.Users.GetUsersInStateSince(ctx, model.UserStateSearching, after)
+	if err != nil {
+		t.Fatalf("GetUsersInStateSince() error = %v", err)
+	}
+	if got := ids(users); !slices.Equal(got, []primitive.ObjectID{searcher.ID}) {
+		t.Errorf("GetUsersInStateSince() = %v, want only the searcher", got)
+	}
+
+	users, err = s.Users.GetUsersInStateSince(ctx, model.UserStateSearching, before)
+	if err != nil {
+		t.Fatalf("GetUsersInStateSince() error = %v", err)
+	}
+	if len(users) != 0 {
+		t.Errorf("GetUsersInStateSince() before anyone searched = %v, want none", ids(users))
+	}
+
+	count, err := s.Users.CountByState(ctx, model.UserStateSearching)
+	if err != nil || count != 1 {
+		t.Errorf("CountByState() = %d, %v; want 1, nil", count, err)
+	}
+}
+
+func testMarkEmailVerified(t *testing.T, s Stores) {
+	ctx := context.Background()
+	user := createUser(t, s)
+
+	if verified, err := s.Users.MarkEmailVerified(ctx, user.ID, "old@example.com"); err != nil || verified {
+		t.Errorf("MarkEmailVerified() for another email = %v, %v; want false, nil", verified, err)
+	}
+	if verified, err := s.Users.MarkEmailVerified(ctx, user.ID, user.Email); err != nil || !verified {
+		t.Fatalf("MarkEmailVerified() = %v, %v; want true, nil", verified, err)
+	}
+	if !getUser(t, s, user.ID).EmailVerified() {
+		t.Error("email isn't verified")
+	}
+	if verified, err := s.Users.MarkEmailVerified(ctx, user.ID, user.Email); err != nil || verified {
+		t.Errorf("repeated MarkEmailVerified() = %v, %v; want false, nil", verified, err)
+	}
+}
+
+func testChangePassword(t *testing.T, s Stores) {
+	ctx := context.Background()
+	user := createUser(t, s)
+
+	if changed, err := s.Users.ChangePassword(ctx, user.ID, user.Password, "new hash"); err != nil || !changed {
+		t.Fatalf("ChangePassword() = %v, %v; want true, nil", changed, err)
+	}
+	if changed, err := s.Users.ChangePassword(ctx, user.ID, user.Password, "other hash"); err != nil || changed {
+		t.Errorf("ChangePassword() from a replaced hash = %v, %v; want false, nil", changed, err)
+	}
+	if got := getUser(t, s, user.ID).Password; got != "new hash" {
+		t.Errorf("password = %q, want the new hash", got)
+	}
+}
+
+func testLocationHistory(t *testing.T, s Stores) {
+	ctx := context.Background()
+	user := createUser(t, s)
+
+	points := [][]float64{{10, 20}, {11, 21}}
+	for _, point := range points {
+		if err := s.Users.UpdateLocation(ctx, user.ID.Hex(), point[1], point[0]); err != nil {
+			t.Fatalf("UpdateLocation() error = %v", err)
+		}
+		// Keep the updates apart at the coarsest timestamp precision
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	got := getUser(t, s, user.ID)
+	if !slices.Equal(got.Location.Coordinates, points[1]) || got.LocationUpdatedAt.IsZero() {
+		t.Errorf("location = %v at %v, want %v", got.Location.Coordinates, got.LocationUpdatedAt, points[1])
+	}
+
+	history, err := s.Users.GetLocationHistory(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetLocationHistory() error = %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("got %d location updates, want 2", len(history))
+	}
+	// Newest first
+	for i, update := range history {
+		if want := points[len(points)-1-i]; !slices.Equal(update.Location.Coordinates, want) {
+			t.Errorf("update %d at %v, want %v", i, update.Location.Coordinates, want)
+		}
+	}
+
+	cleared, err := s.Users.ClearStaleLocations(ctx, time.Now().Add(time.Minute))
+	if err != nil || cleared != 1 {
+		t.Fatalf("ClearStaleLocations() = %d, %v; want 1, nil", cleared, err)
+	}
+	if got := getUser(t, s, user.ID); !got.Location.IsZero() {
+		t.Errorf("stale location %v wasn't cleared", got.Location.Coordinates)
+	}
+}
+
+func testStopStaleSearches(t *testing.T, s Stores) {
+	ctx := context.Background()
+	searcher := createSearcher(t, s, 0, 0)
+	createUser(t, s)
+
+	stopped, err := s.Users.StopStaleSearches(ctx, time.Now().Add(-time.Minute))
+	if err != nil || stopped != 0 {
+		t.Fatalf("StopStaleSearches() with a fresh location = %d, %v; want 0, nil", stopped, err)
+	}
+
+	stopped, err = s.Users.StopStaleSearches(ctx, time.Now().Add(time.Minute))
+	if err != nil || stopped != 1 {
+		t.Fatalf("StopStaleSearches() = %d, %v; want 1, nil", stopped, err)
+	}
+	if got := getUser(t, s, searcher.ID); got.State != model.UserStateIdle || got.IsSearching {
+		t.Errorf("user is %s, searching %v; want idle", got.State, got.IsSearching)
+	}
+}
+
+func testSearchMatches(t *testing.T, s Stores) {
+	ctx := context.Background()
+	searcher := createSearcher(t, s, 0, 0)
+
+	// IDs are taken in order but users at the same spot are stored highest ID
+	// first, so only the tie-break can put them back in order
+	want := make([]primitive.ObjectID, 5)
+	for i := range want {
+		want[i] = primitive.NewObjectID()
+	}
+	for _, at := range []struct {
+		id        primitive.ObjectID
+		longitude float64
+	}{
+		{want[1], 0.001}, {want[0], 0.001}, {want[2], 0.002}, {want[4], 0.003}, {want[3], 0.003},
+	} {
+		startSearching(t, s, createUserWithID(t, s, at.id).ID, at.longitude, 0)
+	}
+
+	// Out of the 5 km radius
+	createSearcher(t, s, 1, 0)
+
+	// Close by, but not searching
+	idle := createSearcher(t, s, 0.001, 0)
+	if _, err := s.Users.TransitionState(ctx, idle.ID, repository.StateTransition{
+		From: []model.UserState{model.UserStateSearching},
+		To:   model.UserStateIdle,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close by, but not interested
+	uninterested := createSearcher(t, s, 0.001, 0)
+	if err := s.Users.UpdatePreferences(ctx, uninterested.ID.Hex(), model.Preferences{
+		MinAge: 18, MaxAge: 99, Gender: []string{"male"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	freshSince := time.Now().Add(-time.Hour)
+	search := func(limit, offset int) []primitive.ObjectID {
+		t.Helper()
+		matches, err := s.Users.SearchMatches(ctx, searcher, freshSince, 5000, limit, offset)
+		if err != nil {
+			t.Fatalf("SearchMatches(%d, %d) error = %v", limit, offset, err)
+		}
+		return ids(matches)
+	}
+
+	if got := search(10, 0); !slices.Equal(got, want) {
+		t.Fatalf("matches = %v, want %v", got, want)
+	}
+
+	var paged []primitive.ObjectID
+	for offset := 0; offset < len(want); offset += 2 {
+		paged = append(paged, search(2, offset)...)
+	}
+	if !slices.Equal(paged, want) {
+		t.Errorf("paged matches = %v, want %v", paged, want)
+	}
+
+	if got := search(2, len(want)); len(got) != 0 {
+		t.Errorf("page past the end = %v, want none", got)
+	}
+
+	if matches, err := s.Users.SearchMatches(ctx, searcher, time.Now().Add(time.Minute), 5000, 10, 0); err != nil || len(matches) != 0 {
+		t.Errorf("SearchMatches() with every location stale = %v, %v; want none", ids(matches), err)
+	}
+}
+
+func testFindPotentialMatch(t *testing.T, s Stores) {
+	ctx := context.Background()
+	freshSince := time.Now().Add(-time.Hour)
+	searcher := createSearcher(t, s, 0, 0)
+	createSearcher(t, s, 1, 0)
+
+	match, err := s.Users.FindPotentialMatch(ctx, searcher, freshSince, 5000)
+	if err != nil || match != nil {
+		t.Fatalf("FindPotentialMatch() with nobody nearby = %v, %v; want nil, nil", match, err)
+	}
+
+	nearby := createSearcher(t, s, 0.001, 0)
+	match, err = s.Users.FindPotentialMatch(ctx, searcher, freshSince, 5000)
+	if err != nil {
+		t.Fatalf("FindPotentialMatch() error = %v", err)
+	}
+	if match == nil || match.ID != nearby.ID {
+		t.Errorf("FindPotentialMatch() = %v, want the nearby user", match)
+	}
+}
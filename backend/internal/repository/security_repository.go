@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SecurityEventRetention is how long the security audit trail is kept.
+const SecurityEventRetention = 90 * 24 * time.Hour
+
+// LoginAttemptRepository keeps failure counts in the login_failures
+// collection. A TTL index deletes them once they're forgotten.
+type LoginAttemptRepository struct {
+	collection *mongo.Collection
+	timeouts   Timeouts
+}
+
+func NewLoginAttemptRepository(db *mongo.Database, timeouts Timeouts) *LoginAttemptRepository {
+	return &LoginAttemptRepository{collection: db.Collection("login_failures"), timeouts: timeouts}
+}
+
+func (r *LoginAttemptRepository) Failures(ctx context.Context, key string) (*model.LoginFailures, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	// The TTL monitor only runs every minute, so expired counts may linger
+	filter := bson.M{"_id": key, "expires_at": bson.M{"$gt": time.Now()}}
+
+	var failures model.LoginFailures
+	err := r.collection.FindOne(ctx, filter).Decode(&failures)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &failures, nil
+}
+
+// RecordFailure counts the failure in a single update, so concurrent
+// failures for the same key are all counted.
+func (r *LoginAttemptRepository) RecordFailure(ctx context.Context, key string, forgetAfter time.Duration) (*model.LoginFailures, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	now := time.Now()
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			// A missing expires_at sorts before any date, so new and
+			// forgotten counts both start again at one
+			"failures":        bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$expires_at", now}}, bson.M{"$add": bson.A{"$failures", 1}}, 1}},
+			"last_failure_at": now,
+			"expires_at":      now.Add(forgetAfter),
+		}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var failures model.LoginFailures
+	err := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&failures)
+	if mongo.IsDuplicateKeyError(err) {
+		// Another failure created the count first; it exists now
+		err = r.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&failures)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &failures, nil
+}
+
+func (r *LoginAttemptRepository) Clear(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
+
+type SecurityEventRepository struct {
+	collection *mongo.Collection
+	timeouts   Timeouts
+}
+
+func NewSecurityEventRepository(db *mongo.Database, timeouts Timeouts) *SecurityEventRepository {
+	return &SecurityEventRepository{collection: db.Collection("security_events"), timeouts: timeouts}
+}
+
+func (r *SecurityEventRepository) Record(ctx context.Context, event *model.SecurityEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	result, err := r.collection.InsertOne(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	event.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
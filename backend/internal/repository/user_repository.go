@@ -3,55 +3,98 @@ package repository
 import (
 	"context"
 	"errors"
-	"log"
+	"regexp"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/seunghoon34/linkapp/backend/internal/model"
 )
 
 type UserRepository struct {
-	collection *mongo.Collection
+	collection        *mongo.Collection
+	historyCollection *mongo.Collection
+	timeouts          Timeouts
 }
 
-func NewUserRepository(db *mongo.Database) *UserRepository {
-	collection := db.Collection("users")
+// LocationHistoryRetention is how long past location updates are kept before
+// MongoDB's TTL monitor deletes them. The TTL index is created by the
+// migrations in internal/migrate.
+const LocationHistoryRetention = 24 * time.Hour
 
-	// Create a 2dsphere index on the location field
-	_, err := collection.Indexes().CreateOne(
-		context.Background(),
-		mongo.IndexModel{
-			Keys: bson.D{{Key: "location", Value: "2dsphere"}},
-		},
-	)
-	if err != nil {
-		log.Fatalf("Error creating geospatial index: %v", err)
+func NewUserRepository(db *mongo.Database, timeouts Timeouts) *UserRepository {
+	return &UserRepository{
+		collection:        db.Collection("users"),
+		historyCollection: db.Collection("location_history"),
+		timeouts:          timeouts,
 	}
-
-	return &UserRepository{collection: collection}
 }
 
-func (r *UserRepository) Create(user *model.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *UserRepository) Create(ctx context.Context, user *model.User) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
 	defer cancel()
 
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = time.Now()
+	if user.State == "" {
+		user.State = model.UserStateIdle
+	}
 
 	result, err := r.collection.InsertOne(ctx, user)
 	if err != nil {
-		return err
+		return conflictError(err)
 	}
 
 	user.ID = result.InsertedID.(primitive.ObjectID)
 	return nil
 }
 
-func (r *UserRepository) GetByID(id string) (*model.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// The unique indexes on users, created by the migrations in internal/migrate.
+const (
+	UserEmailIndex    = "email_1"
+	UserUsernameIndex = "username_1"
+)
+
+// uniqueIndexFields maps each unique user index to the field it guards.
+var uniqueIndexFields = map[string]string{
+	UserEmailIndex:    "email",
+	UserUsernameIndex: "username",
+}
+
+// duplicateKeyIndex picks the index name out of a duplicate key error
+// message, e.g. "E11000 duplicate key error collection: app.users index:
+// email_1 dup key: { email: ... }".
+var duplicateKeyIndex = regexp.MustCompile(`index: (\S+) dup key`)
+
+// conflictError turns a duplicate key error from the unique user indexes
+// into a ConflictError naming the field.
+func conflictError(err error) error {
+	var writeErr mongo.WriteException
+	if !errors.As(err, &writeErr) {
+		return err
+	}
+
+	for _, e := range writeErr.WriteErrors {
+		if e.Code != 11000 {
+			continue
+		}
+		match := duplicateKeyIndex.FindStringSubmatch(e.Message)
+		if match == nil {
+			continue
+		}
+		if field, ok := uniqueIndexFields[match[1]]; ok {
+			return &ConflictError{Field: field}
+		}
+	}
+
+	return err
+}
+
+func (r *UserRepository) GetByID(ctx context.Context, id string) (*model.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
 	defer cancel()
 
 	objectID, err := primitive.ObjectIDFromHex(id)
@@ -62,29 +105,44 @@ func (r *UserRepository) GetByID(id string) (*model.User, error) {
 	var user model.User
 	err = r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&user)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrUserNotFound
+		}
 		return nil, err
 	}
 
 	return &user, nil
 }
 
-func (r *UserRepository) Update(user *model.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// Update saves the user's account details: username, email and whether the
+// email is verified. State, location, password and the rest have their own
+// guarded updates, which a stale copy of the user mustn't undo.
+func (r *UserRepository) Update(ctx context.Context, user *model.User) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
 	defer cancel()
 
 	user.UpdatedAt = time.Now()
 
-	_, err := r.collection.UpdateOne(
-		ctx,
-		bson.M{"_id": user.ID},
-		bson.M{"$set": user},
-	)
+	set := bson.M{
+		"username":   user.Username,
+		"email":      user.Email,
+		"updated_at": user.UpdatedAt,
+	}
+	update := bson.M{"$set": set}
+	// A changed email comes back unverified
+	if user.EmailVerified() {
+		set["email_verified_at"] = user.EmailVerifiedAt
+	} else {
+		update["$unset"] = bson.M{"email_verified_at": ""}
+	}
 
-	return err
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": user.ID}, update)
+
+	return conflictError(err)
 }
 
-func (r *UserRepository) UpdateProfile(id string, profile model.Profile) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *UserRepository) UpdateProfile(ctx context.Context, id string, profile model.Profile) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
 	defer cancel()
 
 	objectID, err := primitive.ObjectIDFromHex(id)
@@ -106,8 +164,8 @@ func (r *UserRepository) UpdateProfile(id string, profile model.Profile) error {
 	return err
 }
 
-func (r *UserRepository) UpdatePreferences(id string, preferences model.Preferences) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *UserRepository) UpdatePreferences(ctx context.Context, id string, preferences model.Preferences) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
 	defer cancel()
 
 	objectID, err := primitive.ObjectIDFromHex(id)
@@ -131,9 +189,12 @@ func (r *UserRepository) UpdatePreferences(id string, preferences model.Preferen
 
 var ErrUserNotFound = errors.New("user not found")
 
-func (r *UserRepository) GetByEmail(email string) (*model.User, error) {
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*model.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
 	var user model.User
-	err := r.collection.FindOne(context.Background(), bson.M{"email": email}).Decode(&user)
+	err := r.collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return nil, ErrUserNotFound
@@ -143,39 +204,40 @@ func (r *UserRepository) GetByEmail(email string) (*model.User, error) {
 	return &user, nil
 }
 
-const FixedSearchDistance = 200
-
-func (r *UserRepository) SearchMatches(user *model.User, limit int) ([]*model.User, error) {
-	ctx := context.Background()
+func (r *UserRepository) SearchMatches(ctx context.Context, user *model.User, freshSince time.Time, radius float64, limit, offset int) ([]*model.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Search)
+	defer cancel()
 
 	// Calculate min and max birth dates based on age preferences
 	minBirthDate := time.Now().AddDate(-user.Preferences.MaxAge-1, 0, 0)
 	maxBirthDate := time.Now().AddDate(-user.Preferences.MinAge, 0, 0)
 
 	pipeline := mongo.Pipeline{
-		// Match users based on gender preference, age range, and active search status
-		{{Key: "$match", Value: bson.M{
-			"is_searching":   true,
-			"profile.gender": bson.M{"$in": user.Preferences.Gender},
-			"profile.date_of_birth": bson.M{
-				"$gte": minBirthDate,
-				"$lte": maxBirthDate,
-			},
-			"_id": bson.M{"$ne": user.ID},
-		}}},
-		// Calculate distance and filter based on fixed distance
+		// $geoNear must be the first stage, so every filter goes into its query.
+		// Results come back sorted by distance, nearest first.
 		{{Key: "$geoNear", Value: bson.M{
 			"near":          user.Location,
 			"distanceField": "distance",
-			"maxDistance":   FixedSearchDistance,
+			"maxDistance":   radius,
 			"spherical":     true,
+			"query": bson.M{
+				"_id":                 bson.M{"$ne": user.ID},
+				"state":               model.UserStateSearching,
+				"location_updated_at": bson.M{"$gte": freshSince},
+				"profile.gender":      bson.M{"$in": user.Preferences.Gender},
+				"profile.date_of_birth": bson.M{
+					"$gte": minBirthDate,
+					"$lte": maxBirthDate,
+				},
+				// Check if the current user matches the potential match's preferences
+				"preferences.gender":  user.Profile.Gender,
+				"preferences.min_age": bson.M{"$lte": age(user.Profile.DateOfBirth)},
+				"preferences.max_age": bson.M{"$gte": age(user.Profile.DateOfBirth)},
+			},
 		}}},
-		// Check if the current user matches the potential match's preferences
-		{{Key: "$match", Value: bson.M{
-			"preferences.gender":  user.Profile.Gender,
-			"preferences.min_age": bson.M{"$lte": age(user.Profile.DateOfBirth)},
-			"preferences.max_age": bson.M{"$gte": age(user.Profile.DateOfBirth)},
-		}}},
+		// Break ties between equidistant users so pages are stable
+		{{Key: "$sort", Value: bson.D{{Key: "distance", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$skip", Value: offset}},
 		{{Key: "$limit", Value: limit}},
 	}
 
@@ -197,8 +259,8 @@ func age(birthDate time.Time) int {
 	return int(time.Since(birthDate).Hours() / 24 / 365)
 }
 
-func (r *UserRepository) UpdateLocation(userID string, latitude, longitude float64) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *UserRepository) UpdateLocation(ctx context.Context, userID string, latitude, longitude float64) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
 	defer cancel()
 
 	objectID, err := primitive.ObjectIDFromHex(userID)
@@ -206,53 +268,302 @@ func (r *UserRepository) UpdateLocation(userID string, latitude, longitude float
 		return err
 	}
 
+	now := time.Now()
 	update := bson.M{
 		"$set": bson.M{
 			"location": model.GeoLocation{
 				Type:        "Point",
 				Coordinates: []float64{longitude, latitude},
 			},
-			"updatedAt": time.Now(),
+			"location_updated_at": now,
+			"updatedAt":           now,
 		},
 	}
 
 	_, err = r.collection.UpdateOne(ctx, bson.M{"_id": objectID}, update)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.historyCollection.InsertOne(ctx, model.LocationUpdate{
+		UserID: objectID,
+		Location: model.GeoLocation{
+			Type:        "Point",
+			Coordinates: []float64{longitude, latitude},
+		},
+		CreatedAt: now,
+	})
 	return err
 }
 
-func (r *UserRepository) SetSearchingStatus(userID primitive.ObjectID, isSearching bool) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// GetLocationHistory returns the user's retained location updates, newest first.
+func (r *UserRepository) GetLocationHistory(ctx context.Context, userID primitive.ObjectID) ([]*model.LocationUpdate, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
 	defer cancel()
 
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := r.historyCollection.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var history []*model.LocationUpdate
+	if err = cursor.All(ctx, &history); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// ClearStaleLocations forgets the coordinates of every user whose last
+// location update is older than before.
+func (r *UserRepository) ClearStaleLocations(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Search)
+	defer cancel()
+
+	filter := bson.M{
+		"location_updated_at": bson.M{"$lt": before},
+	}
 	update := bson.M{
-		"$set": bson.M{
-			"is_searching": isSearching,
-			"updated_at":   time.Now(),
+		"$unset": bson.M{
+			"location":            "",
+			"location_updated_at": "",
 		},
+		"$set": bson.M{"updated_at": time.Now()},
 	}
 
-	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": userID}, update)
-	return err
+	result, err := r.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
 }
 
-func (r *UserRepository) SetCurrentLink(userID, linkID primitive.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// StopStaleSearches moves every searching user whose location hasn't been
+// updated since freshSince back to idle.
+func (r *UserRepository) StopStaleSearches(ctx context.Context, freshSince time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Search)
 	defer cancel()
 
+	filter := bson.M{
+		"state": model.UserStateSearching,
+		"$or": bson.A{
+			bson.M{"location_updated_at": bson.M{"$lt": freshSince}},
+			bson.M{"location_updated_at": bson.M{"$exists": false}},
+		},
+	}
+	now := time.Now()
 	update := bson.M{
 		"$set": bson.M{
-			"current_link_id": linkID,
-			"is_searching":    false,
-			"updated_at":      time.Now(),
+			"state":            model.UserStateIdle,
+			"is_searching":     false,
+			"state_changed_at": now,
+			"updated_at":       now,
 		},
 	}
 
-	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": userID}, update)
-	return err
+	result, err := r.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// CountByState counts the users currently in state.
+func (r *UserRepository) CountByState(ctx context.Context, state model.UserState) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	return r.collection.CountDocuments(ctx, bson.M{"state": state})
+}
+
+// GetUsersInStateSince lists the users that entered state before
+// changedBefore and are still in it.
+func (r *UserRepository) GetUsersInStateSince(ctx context.Context, state model.UserState, changedBefore time.Time) ([]*model.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Search)
+	defer cancel()
+
+	cursor, err := r.collection.Find(ctx, bson.M{
+		"state":            state,
+		"state_changed_at": bson.M{"$lt": changedBefore},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []*model.User
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// StateTransition describes a guarded move of a user between states.
+type StateTransition struct {
+	// From lists the states the user may currently be in
+	From []model.UserState
+	To   model.UserState
+	// OnLink, if set, also requires the user's current link to be this one
+	OnLink primitive.ObjectID
+	// LinkID becomes the user's current link; the nil ID clears it
+	LinkID primitive.ObjectID
+}
+
+// TransitionState applies the transition in a single update so the state,
+// is_searching and current_link_id never disagree. It reports whether the
+// user was in an allowed state and so was moved.
+func (r *UserRepository) TransitionState(ctx context.Context, userID primitive.ObjectID, t StateTransition) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	filter, update := transitionUpdate(userID, t, time.Now())
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+// TransitionPair applies the same transition to both users in one
+// transaction, so either both move or neither does. It reports whether both
+// were in an allowed state. Transactions need MongoDB to run as a replica
+// set, even one with a single member.
+func (r *UserRepository) TransitionPair(ctx context.Context, userAID, userBID primitive.ObjectID, t StateTransition) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	if userAID == userBID {
+		return false, nil
+	}
+
+	session, err := r.collection.Database().Client().StartSession()
+	if err != nil {
+		return false, err
+	}
+	defer session.EndSession(ctx)
+
+	now := time.Now()
+	moved, err := session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		for _, userID := range []primitive.ObjectID{userAID, userBID} {
+			filter, update := transitionUpdate(userID, t, now)
+			result, err := r.collection.UpdateOne(ctx, filter, update)
+			if err != nil {
+				return false, err
+			}
+			if result.ModifiedCount != 1 {
+				return false, errPairNotMoved
+			}
+		}
+		return true, nil
+	})
+	if errors.Is(err, errPairNotMoved) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return moved.(bool), nil
+}
+
+// errPairNotMoved aborts a TransitionPair transaction when one of the users
+// can't move.
+var errPairNotMoved = errors.New("user can't make the transition")
+
+// CheckTransactions returns an error unless the deployment can run the
+// transactions TransitionPair needs, which takes a replica set, even one with
+// a single member, or a sharded cluster. A standalone server can't.
+func CheckTransactions(ctx context.Context, client *mongo.Client) error {
+	var hello struct {
+		SetName string `bson:"setName"`
+		// Msg is "isdbgrid" from a mongos
+		Msg string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return err
+	}
+
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return errors.New("MongoDB is a standalone server, which can't run transactions; start it as a replica set, even one with a single member")
+	}
+	return nil
+}
+
+// transitionUpdate builds the filter matching the user only while the
+// transition is allowed, and the update applying it.
+func transitionUpdate(userID primitive.ObjectID, t StateTransition, now time.Time) (bson.M, bson.M) {
+	from := bson.A{}
+	for _, state := range t.From {
+		from = append(from, state)
+		// Users created before states existed have no state field and are idle
+		if state == model.UserStateIdle {
+			from = append(from, nil)
+		}
+	}
+
+	filter := bson.M{
+		"_id":   userID,
+		"state": bson.M{"$in": from},
+	}
+	if !t.OnLink.IsZero() {
+		filter["current_link_id"] = t.OnLink
+	}
+
+	set := bson.M{
+		"state":            t.To,
+		"is_searching":     t.To == model.UserStateSearching,
+		"state_changed_at": now,
+		"updated_at":       now,
+	}
+	update := bson.M{"$set": set}
+	if t.LinkID.IsZero() {
+		update["$unset"] = bson.M{"current_link_id": ""}
+	} else {
+		set["current_link_id"] = t.LinkID
+	}
+
+	return filter, update
+}
+
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, userID primitive.ObjectID, email string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	now := time.Now()
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": userID, "email": email, "email_verified_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"email_verified_at": now, "updated_at": now}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+func (r *UserRepository) ChangePassword(ctx context.Context, userID primitive.ObjectID, oldHash, newHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Query)
+	defer cancel()
+
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": userID, "password": oldHash},
+		bson.M{"$set": bson.M{"password": newHash, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
 }
 
-func (r *UserRepository) FindPotentialMatch(user *model.User) (*model.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (r *UserRepository) FindPotentialMatch(ctx context.Context, user *model.User, freshSince time.Time, radius float64) (*model.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Search)
 	defer cancel()
 
 	minBirthDate := time.Now().AddDate(-user.Preferences.MaxAge-1, 0, 0)
@@ -262,14 +573,15 @@ func (r *UserRepository) FindPotentialMatch(user *model.User) (*model.User, erro
 		{{Key: "$geoNear", Value: bson.M{
 			"near":               user.Location,
 			"distanceField":      "distance",
-			"maxDistance":        200,
+			"maxDistance":        radius,
 			"spherical":          true,
 			"distanceMultiplier": 0.001,
 		}}},
 		{{Key: "$match", Value: bson.M{
-			"_id":            bson.M{"$ne": user.ID},
-			"is_searching":   true,
-			"profile.gender": bson.M{"$in": user.Preferences.Gender},
+			"_id":                 bson.M{"$ne": user.ID},
+			"state":               model.UserStateSearching,
+			"location_updated_at": bson.M{"$gte": freshSince},
+			"profile.gender":      bson.M{"$in": user.Preferences.Gender},
 			"profile.date_of_birth": bson.M{
 				"$gte": minBirthDate,
 				"$lte": maxBirthDate,
@@ -0,0 +1,321 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/seunghoon34/linkapp/backend/internal/mail"
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+)
+
+// AccountTokenPolicy says how long the links emailed to users work.
+type AccountTokenPolicy struct {
+	VerificationTTL time.Duration
+	ResetTTL        time.Duration
+}
+
+var DefaultAccountTokenPolicy = AccountTokenPolicy{
+	VerificationTTL: 48 * time.Hour,
+	ResetTTL:        time.Hour,
+}
+
+// DefaultAppURL is where the links in emails point: the mobile app.
+const DefaultAppURL = "linkapp://"
+
+// mailTimeout bounds sending one email.
+const mailTimeout = 30 * time.Second
+
+var (
+	ErrInvalidAccountToken  = newError(KindInvalid, "invalid_token", "invalid link")
+	ErrAccountTokenExpired  = newError(KindGone, "token_expired", "link has expired, ask for a new one")
+	ErrAccountTokenUsed     = newError(KindConflict, "token_used", "link has already been used")
+	ErrEmailAlreadyVerified = newError(KindConflict, "email_already_verified", "email is already verified")
+	ErrEmailNotVerified     = newError(KindPrecondition, "email_not_verified", "verify your email first")
+)
+
+const (
+	purposeVerifyEmail   = "verify_email"
+	purposeResetPassword = "reset_password"
+)
+
+type accountTokenClaims struct {
+	Purpose string `json:"p"`
+	UserID  string `json:"u"`
+	// Fingerprint ties the token to the state it changes, the email it
+	// verifies or the password it replaces, so it stops working once used
+	Fingerprint string `json:"f"`
+	ExpiresAt   int64  `json:"e"`
+}
+
+func fingerprint(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// SetMailer sets how emails are sent and where their links point, such as
+// https://linkapp.example or the app's own scheme.
+func (s *UserService) SetMailer(mailer mail.Mailer, appURL string) {
+	s.mailer = mailer
+	s.appURL = appURL
+}
+
+func (s *UserService) SetAccountTokenPolicy(policy AccountTokenPolicy) {
+	s.accountTokenPolicy = policy
+}
+
+// SetAccountTokenSecret sets the key emailed links are signed with. Every
+// instance needs the same one, and it has to outlast restarts for links to
+// keep working.
+func (s *UserService) SetAccountTokenSecret(secret []byte) {
+	s.accountTokenSecret = secret
+}
+
+func (s *UserService) encodeAccountToken(purpose string, userID primitive.ObjectID, state string, ttl time.Duration) (string, error) {
+	return encodeSignedToken(s.accountTokenSecret, accountTokenClaims{
+		Purpose:     purpose,
+		UserID:      userID.Hex(),
+		Fingerprint: fingerprint(state),
+		ExpiresAt:   time.Now().Add(ttl).Unix(),
+	})
+}
+
+// checkAccountToken decodes a token made for purpose and loads the user
+// it's for. The caller checks the fingerprint.
+func (s *UserService) checkAccountToken(ctx context.Context, token, purpose string) (*accountTokenClaims, *model.User, error) {
+	var claims accountTokenClaims
+	if !decodeSignedToken(s.accountTokenSecret, token, &claims) || claims.Purpose != purpose {
+		return nil, nil, ErrInvalidAccountToken
+	}
+
+	if time.Now().Unix() > claims.ExpiresAt {
+		return nil, nil, ErrAccountTokenExpired
+	}
+
+	user, err := s.userRepo.GetByID(ctx, claims.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, nil, ErrInvalidAccountToken
+		}
+		return nil, nil, err
+	}
+
+	return &claims, user, nil
+}
+
+// link is the app URL for path with the token attached.
+func (s *UserService) link(path, token string) string {
+	base := s.appURL
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	return base + path + "?token=" + url.QueryEscape(token)
+}
+
+func (s *UserService) verificationMessage(user *model.User) (mail.Message, error) {
+	token, err := s.encodeAccountToken(purposeVerifyEmail, user.ID, user.Email, s.accountTokenPolicy.VerificationTTL)
+	if err != nil {
+		return mail.Message{}, err
+	}
+
+	return mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email for LinkApp",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen this link to verify your email and start finding matches:\n\n%s\n\n"+
+			"The link works for %s. If you didn't sign up for LinkApp, you can ignore this email.\n",
+			user.Username, s.link("verify-email", token), readableDuration(s.accountTokenPolicy.VerificationTTL)),
+	}, nil
+}
+
+// readableDuration writes d the way an email would, such as "2 days", in
+// the largest unit that divides it.
+func readableDuration(d time.Duration) string {
+	n, unit := int64(d/time.Minute), "minute"
+	switch {
+	case d%(24*time.Hour) == 0:
+		n, unit = int64(d/(24*time.Hour)), "day"
+	case d%time.Hour == 0:
+		n, unit = int64(d/time.Hour), "hour"
+	}
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+func (s *UserService) sendMail(ctx context.Context, msg mail.Message) error {
+	ctx, cancel := context.WithTimeout(ctx, mailTimeout)
+	defer cancel()
+
+	return s.mailer.Send(ctx, msg)
+}
+
+// sendMailInBackground sends msg without holding up the request, logging
+// if it fails. WaitForMail waits for it to be sent.
+func (s *UserService) sendMailInBackground(ctx context.Context, msg mail.Message) {
+	ctx = context.WithoutCancel(ctx)
+	s.mailInBackground.Add(1)
+	go func() {
+		defer s.mailInBackground.Done()
+		if err := s.sendMail(ctx, msg); err != nil {
+			slog.ErrorContext(ctx, "sending email failed", "subject", msg.Subject, "error", err)
+		}
+	}()
+}
+
+// WaitForMail waits for every email being sent in the background. Call it
+// once requests have stopped, before closing the mailer.
+func (s *UserService) WaitForMail() {
+	s.mailInBackground.Wait()
+}
+
+// sendVerificationInBackground emails user a verification link after
+// signing up or changing their email, where a mail server that's down
+// shouldn't fail the change. They can ask again.
+func (s *UserService) sendVerificationInBackground(ctx context.Context, user *model.User) {
+	msg, err := s.verificationMessage(user)
+	if err != nil {
+		slog.ErrorContext(ctx, "creating verification email failed", "error", err)
+		return
+	}
+	s.sendMailInBackground(ctx, msg)
+}
+
+// RequestEmailVerification emails the user a new link to verify their
+// email.
+func (s *UserService) RequestEmailVerification(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, span := startSpan(ctx, "RequestEmailVerification")
+	defer span.End()
+
+	user, err := s.userRepo.GetByID(ctx, userID.Hex())
+	if err != nil {
+		return err
+	}
+
+	if user.EmailVerified() {
+		return ErrEmailAlreadyVerified
+	}
+
+	msg, err := s.verificationMessage(user)
+	if err != nil {
+		return err
+	}
+	return s.sendMail(ctx, msg)
+}
+
+// VerifyEmail verifies the email a verification link was sent to, as long
+// as the user still has it.
+func (s *UserService) VerifyEmail(ctx context.Context, token string) error {
+	ctx, span := startSpan(ctx, "VerifyEmail")
+	defer span.End()
+
+	claims, user, err := s.checkAccountToken(ctx, token, purposeVerifyEmail)
+	if err != nil {
+		return err
+	}
+
+	// The link was for an email the user has since changed
+	if claims.Fingerprint != fingerprint(user.Email) {
+		return ErrInvalidAccountToken
+	}
+
+	verified, err := s.userRepo.MarkEmailVerified(ctx, user.ID, user.Email)
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return ErrAccountTokenUsed
+	}
+
+	slog.InfoContext(ctx, "email verified", "user_id", user.ID.Hex())
+	return nil
+}
+
+// RequestPasswordReset emails a password reset link if an account has the
+// email. It answers the same either way, and the email goes out in the
+// background, so neither the answer nor its timing says whether the
+// account exists.
+func (s *UserService) RequestPasswordReset(ctx context.Context, email string) error {
+	ctx, span := startSpan(ctx, "RequestPasswordReset")
+	defer span.End()
+
+	user, err := s.userRepo.GetByEmail(ctx, NormalizeEmail(email))
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	token, err := s.encodeAccountToken(purposeResetPassword, user.ID, user.Password, s.accountTokenPolicy.ResetTTL)
+	if err != nil {
+		return err
+	}
+
+	s.sendMailInBackground(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your LinkApp password",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen this link to choose a new password:\n\n%s\n\n"+
+			"The link works for %s. If you didn't ask to reset your password, you can ignore this email; "+
+			"your password hasn't changed.\n",
+			user.Username, s.link("reset-password", token), readableDuration(s.accountTokenPolicy.ResetTTL)),
+	})
+	return nil
+}
+
+// ResetPassword sets a new password with the token from a reset link. The
+// link also proves the user owns the email, so it verifies it, and it
+// lifts any lockout from failed logins.
+func (s *UserService) ResetPassword(ctx context.Context, token, password, clientIP string) error {
+	ctx, span := startSpan(ctx, "ResetPassword")
+	defer span.End()
+
+	claims, user, err := s.checkAccountToken(ctx, token, purposeResetPassword)
+	if err != nil {
+		return err
+	}
+
+	// The password has changed since the link was sent, whether through
+	// this link or another
+	if claims.Fingerprint != fingerprint(user.Password) {
+		return ErrAccountTokenUsed
+	}
+
+	errs := fieldErrors{}
+	validatePassword(errs, password)
+	if err := errs.err(); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	changed, err := s.userRepo.ChangePassword(ctx, user.ID, user.Password, string(hashedPassword))
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return ErrAccountTokenUsed
+	}
+
+	if !user.EmailVerified() {
+		if _, err := s.userRepo.MarkEmailVerified(ctx, user.ID, user.Email); err != nil {
+			return err
+		}
+	}
+	s.clearLoginFailures(ctx, user.Email)
+	s.recordSecurityEvent(ctx, &model.SecurityEvent{Type: model.SecurityEventPasswordReset, UserID: user.ID, IP: clientIP})
+
+	return nil
+}
@@ -0,0 +1,293 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/seunghoon34/linkapp/backend/internal/mail"
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+)
+
+// recordingMailer keeps every message sent through it.
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []mail.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *recordingMailer) messages() []mail.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]mail.Message(nil), m.sent...)
+}
+
+var linkToken = regexp.MustCompile(`\?token=(\S+)`)
+
+// lastToken waits for the emails in flight and returns the token from the
+// link in the last one.
+func lastToken(t *testing.T, s *testService, mailer *recordingMailer) string {
+	t.Helper()
+	s.WaitForMail()
+
+	messages := mailer.messages()
+	if len(messages) == 0 {
+		t.Fatal("no email was sent")
+	}
+	match := linkToken.FindStringSubmatch(messages[len(messages)-1].Body)
+	if match == nil {
+		t.Fatalf("no link in %q", messages[len(messages)-1].Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// signedUp signs a user up through the service, which emails them a
+// verification link.
+func signedUp(t *testing.T) (*testService, *recordingMailer, *model.User) {
+	t.Helper()
+
+	s := newTestService(t)
+	mailer := &recordingMailer{}
+	s.SetMailer(mailer, "https://linkapp.example")
+
+	user := &model.User{
+		Username: "someone",
+		Email:    "Someone@Example.com",
+		Password: "correct horse battery",
+	}
+	if err := s.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("signing up: %v", err)
+	}
+	return s, mailer, user
+}
+
+func TestSignedToken(t *testing.T) {
+	secret := []byte("secret")
+	type claims struct {
+		Name string `json:"n"`
+	}
+
+	token, err := encodeSignedToken(secret, claims{Name: "someone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got claims
+	if !decodeSignedToken(secret, token, &got) || got.Name != "someone" {
+		t.Fatalf("decoded %+v from a valid token, want the claims it was made with", got)
+	}
+
+	payload, signature, _ := strings.Cut(token, ".")
+	forged, err := encodeSignedToken(secret, claims{Name: "someone-else"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+
+	tests := []struct {
+		name   string
+		secret []byte
+		token  string
+	}{
+		{"other secret", []byte("other"), token},
+		{"swapped payload", secret, forgedPayload + "." + signature},
+		{"truncated signature", secret, payload + "." + signature[:len(signature)-1]},
+		{"no signature", secret, payload},
+		{"bad payload", secret, "!!!." + signPayload(secret, "!!!")},
+		{"empty", secret, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got claims
+			if decodeSignedToken(tt.secret, tt.token, &got) {
+				t.Errorf("decodeSignedToken() accepted %q as %+v", tt.token, got)
+			}
+		})
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	s, mailer, user := signedUp(t)
+	ctx := context.Background()
+	token := lastToken(t, s, mailer)
+
+	messages := mailer.messages()
+	if len(messages) != 1 || messages[0].To != "someone@example.com" {
+		t.Fatalf("sent %+v, want one verification email to the normalized address", messages)
+	}
+	if body := messages[0].Body; !strings.Contains(body, "https://linkapp.example/verify-email?token=") || !strings.Contains(body, "2 days") {
+		t.Errorf("verification email %q doesn't link to the app or say how long the link works", body)
+	}
+
+	if err := s.StartSearching(ctx, user.ID); !errors.Is(err, ErrEmailNotVerified) {
+		t.Errorf("StartSearching() before verifying error = %v, want ErrEmailNotVerified", err)
+	}
+
+	if err := s.VerifyEmail(ctx, token); err != nil {
+		t.Fatalf("VerifyEmail() error = %v", err)
+	}
+	if !s.user(t, user.ID).EmailVerified() {
+		t.Error("email isn't verified")
+	}
+
+	// Single use
+	if err := s.VerifyEmail(ctx, token); !errors.Is(err, ErrAccountTokenUsed) {
+		t.Errorf("second VerifyEmail() error = %v, want ErrAccountTokenUsed", err)
+	}
+	if err := s.RequestEmailVerification(ctx, user.ID); !errors.Is(err, ErrEmailAlreadyVerified) {
+		t.Errorf("RequestEmailVerification() once verified error = %v, want ErrEmailAlreadyVerified", err)
+	}
+}
+
+func TestVerifyEmailAfterEmailChange(t *testing.T) {
+	s, mailer, user := signedUp(t)
+	ctx := context.Background()
+	oldToken := lastToken(t, s, mailer)
+
+	if err := s.UpdateUser(ctx, user.ID.Hex(), user.Username, "new@example.com"); err != nil {
+		t.Fatalf("UpdateUser() error = %v", err)
+	}
+	newToken := lastToken(t, s, mailer)
+	if got := mailer.messages()[1].To; got != "new@example.com" {
+		t.Errorf("second verification email went to %s, want the new address", got)
+	}
+
+	// The old link was for an email the user no longer has
+	if err := s.VerifyEmail(ctx, oldToken); !errors.Is(err, ErrInvalidAccountToken) {
+		t.Fatalf("VerifyEmail() with the old link error = %v, want ErrInvalidAccountToken", err)
+	}
+	if s.user(t, user.ID).EmailVerified() {
+		t.Fatal("the old link verified the new email")
+	}
+
+	if err := s.VerifyEmail(ctx, newToken); err != nil {
+		t.Fatalf("VerifyEmail() with the new link error = %v", err)
+	}
+	if !s.user(t, user.ID).EmailVerified() {
+		t.Error("new email isn't verified")
+	}
+}
+
+func TestAccountTokenRejected(t *testing.T) {
+	s, mailer, user := signedUp(t)
+	ctx := context.Background()
+	lastToken(t, s, mailer)
+
+	expired, err := s.encodeAccountToken(purposeVerifyEmail, user.ID, user.Email, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reset, err := s.encodeAccountToken(purposeResetPassword, user.ID, user.Email, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSecret := newTestService(t)
+	foreign, err := otherSecret.encodeAccountToken(purposeVerifyEmail, user.ID, user.Email, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"expired", expired, ErrAccountTokenExpired},
+		{"other purpose", reset, ErrInvalidAccountToken},
+		{"other secret", foreign, ErrInvalidAccountToken},
+		{"garbage", "not-a-token", ErrInvalidAccountToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.VerifyEmail(ctx, tt.token); !errors.Is(err, tt.want) {
+				t.Errorf("VerifyEmail() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if s.user(t, user.ID).EmailVerified() {
+		t.Error("a rejected link verified the email")
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	s, mailer, user := signedUp(t)
+	ctx := context.Background()
+	lastToken(t, s, mailer)
+
+	// Nobody has the address, and the answer doesn't say so
+	if err := s.RequestPasswordReset(ctx, "nobody@example.com"); err != nil {
+		t.Fatalf("RequestPasswordReset() for an unknown email error = %v", err)
+	}
+	s.WaitForMail()
+	if n := len(mailer.messages()); n != 1 {
+		t.Fatalf("sent %d emails, want only the verification email", n)
+	}
+
+	if err := s.RequestPasswordReset(ctx, " SOMEONE@example.com "); err != nil {
+		t.Fatalf("RequestPasswordReset() error = %v", err)
+	}
+	token := lastToken(t, s, mailer)
+	if body := mailer.messages()[1].Body; !strings.Contains(body, "/reset-password?token=") || !strings.Contains(body, "1 hour") {
+		t.Errorf("reset email %q doesn't link to the reset page or say how long the link works", body)
+	}
+
+	// A password that fails validation leaves the link usable
+	var invalid *ValidationError
+	if err := s.ResetPassword(ctx, token, "short", "192.0.2.1"); !errors.As(err, &invalid) {
+		t.Fatalf("ResetPassword() with a short password error = %v, want a ValidationError", err)
+	}
+
+	if err := s.ResetPassword(ctx, token, "a brand new password", "192.0.2.1"); err != nil {
+		t.Fatalf("ResetPassword() error = %v", err)
+	}
+	stored := s.user(t, user.ID)
+	if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("a brand new password")) != nil {
+		t.Error("password wasn't changed")
+	}
+	// The link came through the user's email
+	if !stored.EmailVerified() {
+		t.Error("resetting the password didn't verify the email")
+	}
+
+	// Single use: the password it was made for has gone
+	if err := s.ResetPassword(ctx, token, "yet another password", "192.0.2.1"); !errors.Is(err, ErrAccountTokenUsed) {
+		t.Errorf("second ResetPassword() error = %v, want ErrAccountTokenUsed", err)
+	}
+}
+
+func TestReadableDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{48 * time.Hour, "2 days"},
+		{24 * time.Hour, "1 day"},
+		{36 * time.Hour, "36 hours"},
+		{time.Hour, "1 hour"},
+		{90 * time.Minute, "90 minutes"},
+		{time.Minute, "1 minute"},
+	}
+	for _, tt := range tests {
+		if got := readableDuration(tt.d); got != tt.want {
+			t.Errorf("readableDuration(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultLockedChatroomTTL is how long a chatroom can stay locked before it
+// expires and both users go back to the pool.
+const DefaultLockedChatroomTTL = 7 * 24 * time.Hour
+
+var ErrChatroomClosed = newError(KindGone, "chatroom_closed", "chatroom is closed")
+
+func (s *UserService) SetLockedChatroomTTL(ttl time.Duration) {
+	s.lockedChatroomTTL = ttl
+}
+
+// closeChatroom closes the chatroom with the given status and moves both
+// users back to idle so they can search again.
+func (s *UserService) closeChatroom(ctx context.Context, chatroom *model.Chatroom, status model.ChatroomStatus) error {
+	closed, err := s.chatroomRepo.CloseChatroom(ctx, chatroom.ID, status)
+	if err != nil {
+		return err
+	}
+	if !closed {
+		return ErrChatroomClosed
+	}
+	slog.InfoContext(ctx, "chatroom closed", "chatroom_id", chatroom.ID.Hex(), "status", status)
+
+	// The chatroom is closed now, so finish tidying up even if the caller
+	// has gone away
+	ctx = context.WithoutCancel(ctx)
+
+	if err := s.stopLocationSharing(ctx, chatroom.ID); err != nil {
+		return err
+	}
+
+	// Either user may have moved on already, e.g. by a racing unmatch
+	for _, userID := range []primitive.ObjectID{chatroom.UserAID, chatroom.UserBID} {
+		err := s.transition(ctx, userID, model.UserStateIdle, chatroom.LinkID, primitive.NilObjectID)
+		if err != nil && !errors.Is(err, ErrInvalidTransition) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Unmatch lets either participant leave the chatroom, locked or not.
+func (s *UserService) Unmatch(ctx context.Context, userID, chatroomID primitive.ObjectID) error {
+	ctx, span := startSpan(ctx, "Unmatch")
+	defer span.End()
+
+	chatroom, err := s.chatroomRepo.GetChatroom(ctx, chatroomID)
+	if err != nil {
+		return err
+	}
+
+	if chatroom.UserAID != userID && chatroom.UserBID != userID {
+		return ErrNotChatroomMember
+	}
+
+	return s.closeChatroom(ctx, chatroom, model.ChatroomStatusUnmatched)
+}
+
+// ExpireChatrooms closes every chatroom that stayed locked past the TTL.
+func (s *UserService) ExpireChatrooms(ctx context.Context) error {
+	ctx, span := startSpan(ctx, "ExpireChatrooms")
+	defer span.End()
+
+	chatrooms, err := s.chatroomRepo.GetExpiredLockedChatrooms(ctx, time.Now().Add(-s.lockedChatroomTTL))
+	if err != nil {
+		return err
+	}
+
+	for _, chatroom := range chatrooms {
+		err := s.closeChatroom(ctx, chatroom, model.ChatroomStatusExpired)
+		if err != nil && !errors.Is(err, ErrChatroomClosed) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import "github.com/seunghoon34/linkapp/backend/internal/repository"
+
+// Kind says what sort of failure an Error is, so callers can react to a
+// whole class of errors without knowing each one.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	// KindInvalid means the request itself is malformed
+	KindInvalid
+	KindUnauthorized
+	// KindForbidden means the user isn't allowed to do this
+	KindForbidden
+	KindNotFound
+	// KindConflict means the request clashes with the current state
+	KindConflict
+	// KindGone means the thing acted on has closed or expired for good
+	KindGone
+	// KindPrecondition means the user has to do something else first
+	KindPrecondition
+	// KindThrottled means the caller has to wait before trying again
+	KindThrottled
+)
+
+// Error is a failure the service expects and can explain. Code is stable
+// and meant for clients to branch on; Message is for people.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(kind Kind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+// ErrUserNotFound is the repositories' error, so it matches whichever
+// storage backend is in use.
+var ErrUserNotFound = repository.ErrUserNotFound
+
+var (
+	ErrInvalidCredentials  = newError(KindUnauthorized, "invalid_credentials", "invalid email or password")
+	ErrNotLinkMember       = newError(KindForbidden, "not_link_member", "user is not part of this link")
+	ErrLinkNotPending      = newError(KindGone, "link_not_pending", "link is no longer pending")
+	ErrNoMatchFound        = newError(KindNotFound, "no_match_found", "no potential match found")
+	ErrMessageLimitReached = newError(KindForbidden, "message_limit_reached", "chatroom is locked and message limit reached")
+)
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/metrics"
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultLinkTTL is how long a pending link waits for both users to respond.
+const DefaultLinkTTL = 30 * time.Second
+
+func (s *UserService) SetLinkTTL(ttl time.Duration) {
+	s.linkTTL = ttl
+}
+
+// linkTimers holds one timer per pending link this instance knows about,
+// each firing at the link's ExpiresAt. running counts the timers that have
+// fired and are still expiring their link.
+type linkTimers struct {
+	mu      sync.Mutex
+	timers  map[primitive.ObjectID]*time.Timer
+	running sync.WaitGroup
+	stopped bool
+}
+
+func newLinkTimers() *linkTimers {
+	return &linkTimers{
+		timers: make(map[primitive.ObjectID]*time.Timer),
+	}
+}
+
+// scheduleLinkExpiry arms a timer for the link unless one is already armed.
+func (s *UserService) scheduleLinkExpiry(link *model.Link) {
+	s.linkTimers.mu.Lock()
+	defer s.linkTimers.mu.Unlock()
+
+	if _, ok := s.linkTimers.timers[link.ID]; ok || s.linkTimers.stopped {
+		return
+	}
+
+	linkID := link.ID
+	s.linkTimers.timers[linkID] = time.AfterFunc(time.Until(link.ExpiresAt), func() {
+		s.linkTimers.mu.Lock()
+		delete(s.linkTimers.timers, linkID)
+		if s.linkTimers.stopped {
+			s.linkTimers.mu.Unlock()
+			return
+		}
+		s.linkTimers.running.Add(1)
+		s.linkTimers.mu.Unlock()
+		defer s.linkTimers.running.Done()
+
+		// The timer outlives the request that created the link
+		if err := s.expireLink(context.Background(), linkID); err != nil {
+			slog.Error("expiring link failed", "link_id", linkID.Hex(), "error", err)
+		}
+	})
+}
+
+// cancelLinkExpiry stops the link's timer once it's been answered.
+func (s *UserService) cancelLinkExpiry(linkID primitive.ObjectID) {
+	s.linkTimers.mu.Lock()
+	defer s.linkTimers.mu.Unlock()
+
+	if timer, ok := s.linkTimers.timers[linkID]; ok {
+		timer.Stop()
+		delete(s.linkTimers.timers, linkID)
+	}
+}
+
+// StopLinkExpiry disarms every timer this instance holds, for good, and
+// waits for any that already fired to finish. Links left pending are picked
+// up again by RecoverLinkExpiry on whichever instance leads next.
+func (s *UserService) StopLinkExpiry() {
+	s.linkTimers.mu.Lock()
+	s.linkTimers.stopped = true
+	for linkID, timer := range s.linkTimers.timers {
+		timer.Stop()
+		delete(s.linkTimers.timers, linkID)
+	}
+	s.linkTimers.mu.Unlock()
+
+	s.linkTimers.running.Wait()
+}
+
+// expireLink expires a pending link and sends both users back to searching.
+// The status change is conditional, so if several instances race on the same
+// link only one of them resets the users.
+func (s *UserService) expireLink(ctx context.Context, linkID primitive.ObjectID) error {
+	ctx, span := startSpan(ctx, "expireLink")
+	defer span.End()
+
+	expired, err := s.linkRepo.ExpireLink(ctx, linkID)
+	if err != nil || !expired {
+		return err
+	}
+	metrics.Links.WithLabelValues(metrics.LinkExpired).Inc()
+	slog.InfoContext(ctx, "link expired", "link_id", linkID.Hex())
+
+	link, err := s.linkRepo.GetLink(ctx, linkID)
+	if err != nil {
+		return err
+	}
+
+	// Anyone this fails to release is picked up by RecoverLinkExpiry later
+	return s.releaseLinkedUsers(ctx, link)
+}
+
+// releaseLinkedUsers sends the users still waiting on the link back to
+// searching. Users who have already moved on from it are left alone.
+func (s *UserService) releaseLinkedUsers(ctx context.Context, link *model.Link) error {
+	for _, userID := range []primitive.ObjectID{link.UserAID, link.UserBID} {
+		err := s.transition(ctx, userID, model.UserStateSearching, link.ID, primitive.NilObjectID)
+		if err != nil && !errors.Is(err, ErrInvalidTransition) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecoverLinkExpiry arms timers for every pending link in the database,
+// including ones created by instances that have since stopped. Links already
+// past their ExpiresAt fire straight away. It also releases users left
+// linked to links that are no longer pending. Only the instance holding the
+// link expiry lease should call it.
+func (s *UserService) RecoverLinkExpiry(ctx context.Context) error {
+	ctx, span := startSpan(ctx, "RecoverLinkExpiry")
+	defer span.End()
+
+	links, err := s.linkRepo.GetPendingLinks(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		s.scheduleLinkExpiry(link)
+	}
+
+	return s.releaseStuckUsers(ctx)
+}
+
+// releaseStuckUsers sends users left linked to a link that's no longer
+// pending back to searching, e.g. when releasing them failed after the link
+// expired or an instance stopped halfway through an answer.
+func (s *UserService) releaseStuckUsers(ctx context.Context) error {
+	// No link stays pending past its TTL, so a user linked for twice that
+	// has been left behind, with time to spare for answers in flight
+	users, err := s.userRepo.GetUsersInStateSince(ctx, model.UserStateLinked, time.Now().Add(-2*s.linkTTL))
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		link, err := s.linkRepo.GetLink(ctx, user.CurrentLinkID)
+		if err != nil && !errors.Is(err, repository.ErrLinkNotFound) {
+			return err
+		}
+		// Pending links have just had their timers armed
+		if err == nil && link.Status == model.LinkStatusPending {
+			continue
+		}
+
+		err = s.transition(ctx, user.ID, model.UserStateSearching, user.CurrentLinkID, primitive.NilObjectID)
+		if err != nil && !errors.Is(err, ErrInvalidTransition) {
+			return err
+		}
+		if err == nil {
+			slog.InfoContext(ctx, "released user stuck on link", "user_id", user.ID.Hex(), "link_id", user.CurrentLinkID.Hex())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,328 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultLocationSharingLimit is how long after a chatroom is created the
+// participants can see each other on the map.
+const DefaultLocationSharingLimit = 2 * time.Hour
+
+var (
+	ErrLocationSharingClosed = newError(KindConflict, "location_sharing_closed", "location sharing is no longer available for this chatroom")
+	ErrLocationSharingPaused = newError(KindConflict, "location_sharing_paused", "location sharing is paused")
+)
+
+// LocationSubscription delivers the peer's position updates for one chatroom.
+// Updates is closed when sharing ends for the room; Expires is when the
+// sharing window runs out.
+type LocationSubscription struct {
+	Updates <-chan *model.SharedLocation
+	Expires time.Time
+	cancel  func()
+}
+
+func (sub *LocationSubscription) Close() {
+	sub.cancel()
+}
+
+// locationBroker fans out location updates to the streams open on a chatroom
+// on this instance. With a location events store the updates reach it
+// through the store, so every instance's broker sees them.
+type locationBroker struct {
+	mu     sync.Mutex
+	subs   map[primitive.ObjectID]map[chan *model.SharedLocation]primitive.ObjectID
+	closed bool
+}
+
+func newLocationBroker() *locationBroker {
+	return &locationBroker{
+		subs: make(map[primitive.ObjectID]map[chan *model.SharedLocation]primitive.ObjectID),
+	}
+}
+
+func (b *locationBroker) subscribe(chatroomID, userID primitive.ObjectID) (chan *model.SharedLocation, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan *model.SharedLocation, 1)
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if b.subs[chatroomID] == nil {
+		b.subs[chatroomID] = make(map[chan *model.SharedLocation]primitive.ObjectID)
+	}
+	b.subs[chatroomID][ch] = userID
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[chatroomID][ch]; ok {
+			delete(b.subs[chatroomID], ch)
+			close(ch)
+		}
+	}
+}
+
+// publish sends the update to everyone in the room except its sender. Slow
+// readers only ever see the most recent position.
+func (b *locationBroker) publish(location *model.SharedLocation) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch, userID := range b.subs[location.ChatroomID] {
+		if userID == location.UserID {
+			continue
+		}
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- location
+	}
+}
+
+// close ends every stream open on the chatroom.
+func (b *locationBroker) close(chatroomID primitive.ObjectID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs[chatroomID] {
+		close(ch)
+	}
+	delete(b.subs, chatroomID)
+}
+
+// closeAll ends every open stream and any opened afterwards.
+func (b *locationBroker) closeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for chatroomID, subs := range b.subs {
+		for ch := range subs {
+			close(ch)
+		}
+		delete(b.subs, chatroomID)
+	}
+	b.closed = true
+}
+
+func (s *UserService) SetLocationSharingLimit(limit time.Duration) {
+	s.locationSharingLimit = limit
+}
+
+// SetLocationEvents sends location updates through events, so the streams
+// on every instance sharing it see them, rather than only the streams on
+// this one. Updates then only reach the streams while StartLocationFeed is
+// running.
+func (s *UserService) SetLocationEvents(events repository.LocationEventStore) {
+	s.locationEvents = events
+}
+
+// StartLocationFeed delivers the location events store's events to the
+// streams open on this instance until ctx is done, listening again whenever
+// the store fails. Updates published while it isn't listening are missed;
+// clients still see them the next time they fetch the peer's location.
+func (s *UserService) StartLocationFeed(ctx context.Context) {
+	if s.locationEvents == nil {
+		return
+	}
+
+	s.locationFeed.Add(1)
+	go func() {
+		defer s.locationFeed.Done()
+
+		retry := time.Second
+		for {
+			started := time.Now()
+			err := s.locationEvents.Listen(ctx, s.deliverLocationEvent)
+			if ctx.Err() != nil {
+				return
+			}
+			if time.Since(started) > time.Minute {
+				retry = time.Second
+			}
+			slog.Error("listening for location events failed", "error", err, "retry_in", retry)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retry):
+			}
+			retry = min(2*retry, time.Minute)
+		}
+	}()
+}
+
+// WaitForLocationFeed waits for StartLocationFeed to stop once its context
+// is done.
+func (s *UserService) WaitForLocationFeed() {
+	s.locationFeed.Wait()
+}
+
+// publishLocationEvent sends the event to the streams on every instance, or
+// just this one without a location events store. Failures are only logged:
+// the change is saved either way, and streams that miss it stay open.
+func (s *UserService) publishLocationEvent(ctx context.Context, event repository.LocationEvent) {
+	if s.locationEvents == nil {
+		s.deliverLocationEvent(event)
+		return
+	}
+	if err := s.locationEvents.Publish(ctx, event); err != nil {
+		slog.ErrorContext(ctx, "publishing location event failed", "chatroom_id", event.ChatroomID.Hex(), "error", err)
+	}
+}
+
+func (s *UserService) deliverLocationEvent(event repository.LocationEvent) {
+	if event.Closed {
+		s.locations.close(event.ChatroomID)
+		return
+	}
+	if event.Location != nil {
+		s.locations.publish(event.Location)
+	}
+}
+
+// locationSharingChatroom loads the chatroom and checks that userID can take
+// part in its location sharing.
+func (s *UserService) locationSharingChatroom(ctx context.Context, userID, chatroomID primitive.ObjectID) (*model.Chatroom, error) {
+	chatroom, err := s.chatroomRepo.GetChatroom(ctx, chatroomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if chatroom.UserAID != userID && chatroom.UserBID != userID {
+		return nil, ErrNotChatroomMember
+	}
+
+	if chatroom.IsClosed() || !chatroom.IsLocked || time.Now().After(s.locationSharingExpiry(chatroom)) {
+		return nil, ErrLocationSharingClosed
+	}
+
+	return chatroom, nil
+}
+
+func (s *UserService) locationSharingExpiry(chatroom *model.Chatroom) time.Time {
+	return chatroom.CreatedAt.Add(s.locationSharingLimit)
+}
+
+func peerID(chatroom *model.Chatroom, userID primitive.ObjectID) primitive.ObjectID {
+	if chatroom.UserAID == userID {
+		return chatroom.UserBID
+	}
+	return chatroom.UserAID
+}
+
+func hasPaused(chatroom *model.Chatroom, userID primitive.ObjectID) bool {
+	for _, id := range chatroom.LocationPausedBy {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *UserService) ShareLocation(ctx context.Context, userID, chatroomID primitive.ObjectID, latitude, longitude float64) (*model.SharedLocation, error) {
+	ctx, span := startSpan(ctx, "ShareLocation")
+	defer span.End()
+
+	if err := validateCoordinates(latitude, longitude); err != nil {
+		return nil, err
+	}
+
+	chatroom, err := s.locationSharingChatroom(ctx, userID, chatroomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if hasPaused(chatroom, userID) {
+		return nil, ErrLocationSharingPaused
+	}
+
+	location, err := s.chatroomRepo.SaveSharedLocation(ctx, chatroomID, userID, latitude, longitude)
+	if err != nil {
+		return nil, err
+	}
+
+	s.publishLocationEvent(ctx, repository.LocationEvent{ChatroomID: chatroomID, Location: location})
+	return location, nil
+}
+
+// GetPeerLocation returns the other participant's latest shared position, or
+// nil if they haven't shared one or have paused sharing.
+func (s *UserService) GetPeerLocation(ctx context.Context, userID, chatroomID primitive.ObjectID) (*model.SharedLocation, error) {
+	ctx, span := startSpan(ctx, "GetPeerLocation")
+	defer span.End()
+
+	chatroom, err := s.locationSharingChatroom(ctx, userID, chatroomID)
+	if err != nil {
+		return nil, err
+	}
+
+	peer := peerID(chatroom, userID)
+	if hasPaused(chatroom, peer) {
+		return nil, nil
+	}
+
+	return s.chatroomRepo.GetSharedLocation(ctx, chatroomID, peer)
+}
+
+func (s *UserService) SubscribePeerLocation(ctx context.Context, userID, chatroomID primitive.ObjectID) (*LocationSubscription, error) {
+	ctx, span := startSpan(ctx, "SubscribePeerLocation")
+	defer span.End()
+
+	chatroom, err := s.locationSharingChatroom(ctx, userID, chatroomID)
+	if err != nil {
+		return nil, err
+	}
+
+	updates, cancel := s.locations.subscribe(chatroomID, userID)
+	return &LocationSubscription{
+		Updates: updates,
+		Expires: s.locationSharingExpiry(chatroom),
+		cancel:  cancel,
+	}, nil
+}
+
+// CloseLocationStreams ends every location stream, now and from here on, so
+// a shutting down server isn't held open by clients watching the map.
+func (s *UserService) CloseLocationStreams() {
+	s.locations.closeAll()
+}
+
+func (s *UserService) PauseLocationSharing(ctx context.Context, userID, chatroomID primitive.ObjectID) error {
+	ctx, span := startSpan(ctx, "PauseLocationSharing")
+	defer span.End()
+
+	if _, err := s.locationSharingChatroom(ctx, userID, chatroomID); err != nil {
+		return err
+	}
+
+	return s.chatroomRepo.SetLocationSharingPaused(ctx, chatroomID, userID, true)
+}
+
+func (s *UserService) ResumeLocationSharing(ctx context.Context, userID, chatroomID primitive.ObjectID) error {
+	ctx, span := startSpan(ctx, "ResumeLocationSharing")
+	defer span.End()
+
+	if _, err := s.locationSharingChatroom(ctx, userID, chatroomID); err != nil {
+		return err
+	}
+
+	return s.chatroomRepo.SetLocationSharingPaused(ctx, chatroomID, userID, false)
+}
+
+// stopLocationSharing ends live sharing once a chatroom is unlocked; the
+// participants can exchange locations through chat from then on.
+func (s *UserService) stopLocationSharing(ctx context.Context, chatroomID primitive.ObjectID) error {
+	s.publishLocationEvent(ctx, repository.LocationEvent{ChatroomID: chatroomID, Closed: true})
+	return s.chatroomRepo.DeleteSharedLocations(ctx, chatroomID)
+}
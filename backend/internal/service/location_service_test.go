@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository/memory"
+)
+
+// sharingChatroom stores a locked chatroom, where the two users can share
+// their locations.
+func (s *testService) sharingChatroom(t *testing.T) (userA, userB primitive.ObjectID, chatroom *model.Chatroom) {
+	t.Helper()
+
+	userA, userB = primitive.NewObjectID(), primitive.NewObjectID()
+	chatroom, err := s.chatrooms.CreateChatroom(context.Background(), primitive.NewObjectID(), userA, userB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return userA, userB, chatroom
+}
+
+// nextLocation waits for the stream's next update; ok is false if the
+// stream ended.
+func nextLocation(t *testing.T, sub *LocationSubscription) (location *model.SharedLocation, ok bool) {
+	t.Helper()
+
+	select {
+	case location, ok = <-sub.Updates:
+		return location, ok
+	case <-time.After(time.Second):
+		t.Fatal("no location update within a second")
+		return nil, false
+	}
+}
+
+func TestLocationStream(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	userA, userB, chatroom := s.sharingChatroom(t)
+
+	sub, err := s.SubscribePeerLocation(ctx, userA, chatroom.ID)
+	if err != nil {
+		t.Fatalf("SubscribePeerLocation() error = %v", err)
+	}
+	defer sub.Close()
+
+	// Users don't hear their own updates
+	if _, err := s.ShareLocation(ctx, userA, chatroom.ID, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ShareLocation(ctx, userB, chatroom.ID, 2, 2); err != nil {
+		t.Fatal(err)
+	}
+	if location, ok := nextLocation(t, sub); !ok || location.UserID != userB {
+		t.Fatalf("stream got %+v, want userB's location", location)
+	}
+
+	if err := s.stopLocationSharing(ctx, chatroom.ID); err != nil {
+		t.Fatal(err)
+	}
+	if location, ok := nextLocation(t, sub); ok {
+		t.Errorf("stream got %+v after sharing stopped, want it closed", location)
+	}
+}
+
+func TestLocationStreamAcrossInstances(t *testing.T) {
+	ctx := context.Background()
+	feedCtx, stopFeeds := context.WithCancel(ctx)
+
+	// Two instances sharing one database
+	a := newTestService(t)
+	b := &testService{UserService: NewUserService(a.users, a.links, a.chatrooms), users: a.users, links: a.links, chatrooms: a.chatrooms}
+	events := memory.NewLocationEventRepository()
+	for _, s := range []*testService{a, b} {
+		s.SetLocationEvents(events)
+		s.StartLocationFeed(feedCtx)
+	}
+	t.Cleanup(func() {
+		stopFeeds()
+		a.WaitForLocationFeed()
+		b.WaitForLocationFeed()
+	})
+
+	userA, userB, chatroom := a.sharingChatroom(t)
+	sub, err := a.SubscribePeerLocation(ctx, userA, chatroom.ID)
+	if err != nil {
+		t.Fatalf("SubscribePeerLocation() error = %v", err)
+	}
+	defer sub.Close()
+
+	// The feed starts listening in the background, so share until it hears
+	deadline := time.Now().Add(time.Second)
+	var location *model.SharedLocation
+	for location == nil && time.Now().Before(deadline) {
+		if _, err := b.ShareLocation(ctx, userB, chatroom.ID, 2, 2); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case location = <-sub.Updates:
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if location == nil || location.UserID != userB {
+		t.Fatalf("stream on one instance got %+v, want userB's location shared through the other", location)
+	}
+
+	// Sharing stopped through the other instance ends the stream too
+	if err := b.stopLocationSharing(ctx, chatroom.ID); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, ok := nextLocation(t, sub); !ok {
+			break
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/metrics"
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+)
+
+// LoginPolicy slows down password guessing against one account, and then
+// stops it, on top of the per-route rate limits. Failures are counted per
+// account and per IP and forgotten LockoutDuration after the last one.
+type LoginPolicy struct {
+	// FreeAttempts is how many failures in a row an account takes before
+	// each further attempt has to wait.
+	FreeAttempts int
+	// Backoff is the first wait after the free attempts. It doubles with
+	// every failure after that.
+	Backoff time.Duration
+	// LockoutAfter is how many failures in a row lock the account. Zero
+	// never locks it.
+	LockoutAfter int
+	// LockoutDuration is how long a locked account or blocked IP stays that
+	// way.
+	LockoutDuration time.Duration
+	// IPLockoutAfter is how many failures from one IP, across all accounts,
+	// block it. Zero never blocks it. Many users can share an IP, so this
+	// is set well above LockoutAfter.
+	IPLockoutAfter int
+}
+
+var DefaultLoginPolicy = LoginPolicy{
+	FreeAttempts:    3,
+	Backoff:         time.Second,
+	LockoutAfter:    10,
+	LockoutDuration: 15 * time.Minute,
+	IPLockoutAfter:  50,
+}
+
+// accountWait is how long the account has to wait before its next attempt.
+func (p LoginPolicy) accountWait(failures *model.LoginFailures, now time.Time) time.Duration {
+	if failures == nil {
+		return 0
+	}
+	if p.LockoutAfter > 0 && failures.Failures >= p.LockoutAfter {
+		return failures.ExpiresAt.Sub(now)
+	}
+	return failures.LastFailureAt.Add(p.backoff(failures.Failures)).Sub(now)
+}
+
+func (p LoginPolicy) backoff(failures int) time.Duration {
+	if failures < p.FreeAttempts || p.Backoff <= 0 {
+		return 0
+	}
+
+	wait := p.Backoff
+	for i := p.FreeAttempts; i < failures && wait < p.LockoutDuration; i++ {
+		wait *= 2
+	}
+	return min(wait, p.LockoutDuration)
+}
+
+// ipWait is how long the IP has to wait before its next attempt.
+func (p LoginPolicy) ipWait(failures *model.LoginFailures, now time.Time) time.Duration {
+	if failures == nil || p.IPLockoutAfter <= 0 || failures.Failures < p.IPLockoutAfter {
+		return 0
+	}
+	return failures.ExpiresAt.Sub(now)
+}
+
+var ErrLoginThrottled = newError(KindThrottled, "login_throttled", "too many failed logins, try again later")
+
+// LoginThrottledError is ErrLoginThrottled along with how long the caller
+// has to wait.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return ErrLoginThrottled.Message
+}
+
+func (e *LoginThrottledError) Unwrap() error {
+	return ErrLoginThrottled
+}
+
+func (s *UserService) SetLoginPolicy(policy LoginPolicy) {
+	s.loginPolicy = policy
+}
+
+// SetLoginProtection turns on counting failed logins in attempts and
+// keeping the security events they raise in events. Logins aren't
+// throttled without it.
+func (s *UserService) SetLoginProtection(attempts repository.LoginAttemptStore, events repository.SecurityEventStore) {
+	s.loginAttempts = attempts
+	s.securityEvents = events
+}
+
+// Failures are counted by email rather than by user, so unknown emails are
+// throttled and locked just like real ones and don't stand out.
+func accountKey(email string) string {
+	return "account:" + email
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginWait says how long a login for email from clientIP has to wait
+// before it's tried, if at all.
+func (s *UserService) loginWait(ctx context.Context, email, clientIP string) (time.Duration, error) {
+	if s.loginAttempts == nil {
+		return 0, nil
+	}
+	now := time.Now()
+
+	account, err := s.loginAttempts.Failures(ctx, accountKey(email))
+	if err != nil {
+		return 0, err
+	}
+	wait := s.loginPolicy.accountWait(account, now)
+
+	if clientIP != "" {
+		ip, err := s.loginAttempts.Failures(ctx, ipKey(clientIP))
+		if err != nil {
+			return 0, err
+		}
+		wait = max(wait, s.loginPolicy.ipWait(ip, now))
+	}
+
+	return wait, nil
+}
+
+// recordLoginFailure counts a failed login against the account and the IP,
+// raising an event when either is locked out by it. user is nil when no
+// account has the email.
+func (s *UserService) recordLoginFailure(ctx context.Context, email, clientIP string, user *model.User) error {
+	event := &model.SecurityEvent{Type: model.SecurityEventLoginFailed, IP: clientIP}
+	if user != nil {
+		event.UserID = user.ID
+	}
+	if s.loginAttempts == nil {
+		s.recordSecurityEvent(ctx, event)
+		return nil
+	}
+
+	account, err := s.loginAttempts.RecordFailure(ctx, accountKey(email), s.loginPolicy.LockoutDuration)
+	if err != nil {
+		return err
+	}
+	event.Failures = account.Failures
+	if account.Failures == s.loginPolicy.LockoutAfter {
+		event.Type = model.SecurityEventAccountLocked
+	}
+	s.recordSecurityEvent(ctx, event)
+
+	if clientIP == "" {
+		return nil
+	}
+	ip, err := s.loginAttempts.RecordFailure(ctx, ipKey(clientIP), s.loginPolicy.LockoutDuration)
+	if err != nil {
+		return err
+	}
+	if ip.Failures == s.loginPolicy.IPLockoutAfter {
+		s.recordSecurityEvent(ctx, &model.SecurityEvent{Type: model.SecurityEventIPBlocked, IP: clientIP, Failures: ip.Failures})
+	}
+
+	return nil
+}
+
+// clearLoginFailures forgets the account's failures after a successful
+// login. The IP's are kept, since one good password doesn't vouch for
+// everything else coming from it.
+func (s *UserService) clearLoginFailures(ctx context.Context, email string) {
+	if s.loginAttempts == nil {
+		return
+	}
+	if err := s.loginAttempts.Clear(ctx, accountKey(email)); err != nil {
+		slog.ErrorContext(ctx, "clearing login failures failed", "error", err)
+	}
+}
+
+// recordSecurityEvent logs, counts and keeps the event. Failing to keep it
+// doesn't fail the login it's about.
+func (s *UserService) recordSecurityEvent(ctx context.Context, event *model.SecurityEvent) {
+	event.CreatedAt = time.Now()
+	metrics.SecurityEvents.WithLabelValues(string(event.Type)).Inc()
+
+	level := slog.LevelWarn
+	switch event.Type {
+	case model.SecurityEventLoginFailed, model.SecurityEventPasswordReset:
+		level = slog.LevelInfo
+	}
+	attrs := []any{"type", event.Type, "ip", event.IP}
+	if event.Failures > 0 {
+		attrs = append(attrs, "failures", event.Failures)
+	}
+	if !event.UserID.IsZero() {
+		attrs = append(attrs, "user_id", event.UserID.Hex())
+	}
+	slog.Log(ctx, level, "security event", attrs...)
+
+	if s.securityEvents == nil {
+		return
+	}
+	if err := s.securityEvents.Record(ctx, event); err != nil {
+		slog.ErrorContext(ctx, "recording security event failed", "type", event.Type, "error", err)
+	}
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository/memory"
+)
+
+// recordingEvents keeps every security event recorded through it.
+type recordingEvents struct {
+	mu     sync.Mutex
+	events []model.SecurityEvent
+}
+
+func (r *recordingEvents) Record(ctx context.Context, event *model.SecurityEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, *event)
+	return nil
+}
+
+// count is how many events of type t have been recorded.
+func (r *recordingEvents) count(t model.SecurityEventType) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for _, event := range r.events {
+		if event.Type == t {
+			n++
+		}
+	}
+	return n
+}
+
+const testPassword = "correct horse battery"
+
+// protectedLogin signs a user up on a service that counts failed logins
+// under policy.
+func protectedLogin(t *testing.T, policy LoginPolicy) (*testService, *recordingEvents, *model.User) {
+	t.Helper()
+
+	s := newTestService(t)
+	events := &recordingEvents{}
+	s.SetLoginPolicy(policy)
+	s.SetLoginProtection(memory.NewLoginAttemptRepository(), events)
+
+	user := &model.User{Username: "someone", Email: "someone@example.com", Password: testPassword}
+	if err := s.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("signing up: %v", err)
+	}
+	return s, events, user
+}
+
+// login fails the test unless logging in returns an error matching want, or
+// succeeds when want is nil.
+func (s *testService) login(t *testing.T, email, password, ip string, want error) error {
+	t.Helper()
+
+	_, err := s.AuthenticateUser(context.Background(), email, password, ip)
+	if want == nil && err != nil || want != nil && !errors.Is(err, want) {
+		t.Fatalf("AuthenticateUser(%s, %s) error = %v, want %v", email, ip, err, want)
+	}
+	return err
+}
+
+func TestLoginBackoff(t *testing.T) {
+	policy := LoginPolicy{FreeAttempts: 3, Backoff: time.Second, LockoutDuration: 15 * time.Minute}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{12, 512 * time.Second},
+		// Capped at the lockout
+		{13, 15 * time.Minute},
+		{100, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := policy.backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+
+	if got := (LoginPolicy{FreeAttempts: 3}).backoff(10); got != 0 {
+		t.Errorf("backoff without a Backoff = %v, want none", got)
+	}
+}
+
+func TestLoginBackoffThrottles(t *testing.T) {
+	s, events, user := protectedLogin(t, LoginPolicy{FreeAttempts: 2, Backoff: time.Minute, LockoutDuration: time.Hour})
+
+	s.login(t, user.Email, "wrong", "192.0.2.1", ErrInvalidCredentials)
+	s.login(t, user.Email, "wrong", "192.0.2.1", ErrInvalidCredentials)
+
+	// Even the right password has to wait, from any address
+	err := s.login(t, user.Email, testPassword, "192.0.2.2", ErrLoginThrottled)
+	var throttled *LoginThrottledError
+	if !errors.As(err, &throttled) || throttled.RetryAfter <= 59*time.Second || throttled.RetryAfter > time.Minute {
+		t.Errorf("AuthenticateUser() error = %#v, want a LoginThrottledError to retry in a minute", err)
+	}
+	if n := events.count(model.SecurityEventLoginThrottled); n != 1 {
+		t.Errorf("recorded %d throttled logins, want 1", n)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	s, events, user := protectedLogin(t, LoginPolicy{LockoutAfter: 3, LockoutDuration: time.Minute})
+
+	// A successful login forgets the failures before it
+	s.login(t, user.Email, "wrong", "192.0.2.1", ErrInvalidCredentials)
+	s.login(t, user.Email, "wrong", "192.0.2.1", ErrInvalidCredentials)
+	s.login(t, user.Email, testPassword, "192.0.2.1", nil)
+
+	for range 3 {
+		s.login(t, user.Email, "wrong", "192.0.2.1", ErrInvalidCredentials)
+	}
+	if n := events.count(model.SecurityEventAccountLocked); n != 1 {
+		t.Fatalf("recorded %d account lockouts, want 1", n)
+	}
+	if n := events.count(model.SecurityEventLoginFailed); n != 4 {
+		t.Errorf("recorded %d failed logins besides the lockout, want 4", n)
+	}
+
+	err := s.login(t, " SOMEONE@example.com ", testPassword, "192.0.2.2", ErrLoginThrottled)
+	var throttled *LoginThrottledError
+	if !errors.As(err, &throttled) || throttled.RetryAfter <= 59*time.Second || throttled.RetryAfter > time.Minute {
+		t.Errorf("AuthenticateUser() on a locked account error = %#v, want a LoginThrottledError until the lockout ends", err)
+	}
+}
+
+func TestLoginIPBlocked(t *testing.T) {
+	s, events, user := protectedLogin(t, LoginPolicy{IPLockoutAfter: 3, LockoutDuration: time.Minute})
+
+	// Failures count against the IP across accounts
+	for _, email := range []string{"a@example.com", "b@example.com", user.Email} {
+		s.login(t, email, "wrong", "192.0.2.1", ErrInvalidCredentials)
+	}
+	if n := events.count(model.SecurityEventIPBlocked); n != 1 {
+		t.Fatalf("recorded %d blocked IPs, want 1", n)
+	}
+
+	s.login(t, user.Email, testPassword, "192.0.2.1", ErrLoginThrottled)
+	s.login(t, user.Email, testPassword, "192.0.2.2", nil)
+}
+
+func TestLoginUnknownEmail(t *testing.T) {
+	s, _, user := protectedLogin(t, LoginPolicy{LockoutAfter: 3, LockoutDuration: time.Minute})
+
+	unknownErr := s.login(t, "nobody@example.com", testPassword, "192.0.2.1", ErrInvalidCredentials)
+	wrongErr := s.login(t, user.Email, "wrong", "192.0.2.1", ErrInvalidCredentials)
+	if unknownErr != wrongErr {
+		t.Errorf("unknown email error = %v, wrong password error = %v; want the same", unknownErr, wrongErr)
+	}
+
+	// Unknown emails are locked like real ones
+	s.login(t, "nobody@example.com", testPassword, "192.0.2.1", ErrInvalidCredentials)
+	s.login(t, "nobody@example.com", testPassword, "192.0.2.1", ErrInvalidCredentials)
+	s.login(t, "nobody@example.com", testPassword, "192.0.2.1", ErrLoginThrottled)
+}
+
+func TestLoginUnknownEmailTiming(t *testing.T) {
+	// The dummy hash takes as long to check as the real ones
+	cost, err := bcrypt.Cost([]byte(dummyPasswordHash))
+	if err != nil || cost != bcrypt.DefaultCost {
+		t.Fatalf("dummy hash cost = %d, %v; want %d", cost, err, bcrypt.DefaultCost)
+	}
+
+	s := newTestService(t)
+	ctx := context.Background()
+	user := &model.User{Username: "someone", Email: "someone@example.com", Password: testPassword}
+	if err := s.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fastest of a few tries, to keep the scheduler out of it
+	fastest := func(email string) time.Duration {
+		best := time.Duration(1<<63 - 1)
+		for range 3 {
+			start := time.Now()
+			s.AuthenticateUser(ctx, email, "wrong", "192.0.2.1")
+			best = min(best, time.Since(start))
+		}
+		return best
+	}
+	known := fastest(user.Email)
+	unknown := fastest("nobody@example.com")
+
+	// Skipping bcrypt would make the unknown email thousands of times faster
+	if unknown < known/4 {
+		t.Errorf("unknown email took %v, a wrong password %v; want them alike", unknown, known)
+	}
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// LocationGridSize is the cell size, in degrees, that coordinates are
+	// snapped to before they're shown to anyone without an accepted link.
+	// 0.01° is roughly 1.1 km of latitude.
+	LocationGridSize = 0.01
+
+	// DefaultLocationStaleAfter is how old a location can get before the user
+	// is reported as stale and no longer matched.
+	DefaultLocationStaleAfter = 15 * time.Minute
+
+	// LocationRetention is how long a user's raw coordinates are kept after
+	// their last update.
+	LocationRetention = 24 * time.Hour
+)
+
+// Distance buckets exposed during the link preview instead of coordinates.
+const (
+	DistanceWithin50m  = "within_50m"
+	DistanceWithin100m = "within_100m"
+	DistanceWithin200m = "within_200m"
+	DistanceFarther    = "farther"
+	DistanceUnknown    = "unknown"
+)
+
+const earthRadiusMeters = 6371000
+
+var ErrLocationStale = newError(KindPrecondition, "location_stale", "location is stale, send a location update first")
+
+// PublicUser is how a user is shown to other users: who they are and
+// roughly where, but nothing about their account or the match flow.
+// Coordinates are snapped to LocationGridSize, Distance is a bucket from the
+// viewer when there is one, and LocationStale tells clients the position
+// can't be relied on.
+type PublicUser struct {
+	ID            primitive.ObjectID `json:"id"`
+	Username      string             `json:"username"`
+	Profile       model.Profile      `json:"profile"`
+	Location      *model.GeoLocation `json:"location,omitempty"`
+	Distance      string             `json:"distance,omitempty"`
+	LocationStale bool               `json:"location_stale"`
+}
+
+// LinkPreview is what each side of a pending link sees of the other.
+type LinkPreview struct {
+	Link     *model.Link   `json:"link"`
+	Username string        `json:"username"`
+	Profile  model.Profile `json:"profile"`
+	Distance string        `json:"distance"`
+}
+
+func (s *UserService) SetLocationStaleAfter(d time.Duration) {
+	s.locationStaleAfter = d
+}
+
+// locationFreshSince is the oldest location update still good for matching.
+func (s *UserService) locationFreshSince() time.Time {
+	return time.Now().Add(-s.locationStaleAfter)
+}
+
+func (s *UserService) isLocationStale(user *model.User) bool {
+	return user.Location.IsZero() || user.LocationUpdatedAt.Before(s.locationFreshSince())
+}
+
+func snapLocation(location model.GeoLocation) model.GeoLocation {
+	if location.IsZero() {
+		return location
+	}
+
+	snapped := make([]float64, len(location.Coordinates))
+	for i, c := range location.Coordinates {
+		// Use the centre of the cell so snapped points never sit on a boundary
+		snapped[i] = (math.Floor(c/LocationGridSize) + 0.5) * LocationGridSize
+	}
+
+	return model.GeoLocation{Type: location.Type, Coordinates: snapped}
+}
+
+// toPublicUser shows user to viewer, who may be nil when nobody in
+// particular is looking.
+func (s *UserService) toPublicUser(user, viewer *model.User) *PublicUser {
+	public := &PublicUser{
+		ID:            user.ID,
+		Username:      user.Username,
+		Profile:       user.Profile,
+		LocationStale: s.isLocationStale(user),
+	}
+	if !user.Location.IsZero() {
+		location := snapLocation(user.Location)
+		public.Location = &location
+	}
+	if viewer != nil {
+		public.Distance = s.distanceBucket(viewer, user)
+	}
+	return public
+}
+
+// distanceMeters returns the great-circle distance between two GeoJSON points.
+func distanceMeters(a, b model.GeoLocation) float64 {
+	lng1, lat1 := a.Coordinates[0]*math.Pi/180, a.Coordinates[1]*math.Pi/180
+	lng2, lat2 := b.Coordinates[0]*math.Pi/180, b.Coordinates[1]*math.Pi/180
+
+	sinLat := math.Sin((lat2 - lat1) / 2)
+	sinLng := math.Sin((lng2 - lng1) / 2)
+	h := sinLat*sinLat + math.Cos(lat1)*math.Cos(lat2)*sinLng*sinLng
+
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(h))
+}
+
+func (s *UserService) distanceBucket(a, b *model.User) string {
+	if s.isLocationStale(a) || s.isLocationStale(b) {
+		return DistanceUnknown
+	}
+
+	switch d := distanceMeters(a.Location, b.Location); {
+	case d <= 50:
+		return DistanceWithin50m
+	case d <= 100:
+		return DistanceWithin100m
+	case d <= 200:
+		return DistanceWithin200m
+	default:
+		return DistanceFarther
+	}
+}
+
+func (s *UserService) GetPublicUser(ctx context.Context, id string) (*PublicUser, error) {
+	ctx, span := startSpan(ctx, "GetPublicUser")
+	defer span.End()
+
+	user, err := s.userRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.toPublicUser(user, nil), nil
+}
+
+func (s *UserService) GetLinkPreview(ctx context.Context, userID, linkID primitive.ObjectID) (*LinkPreview, error) {
+	ctx, span := startSpan(ctx, "GetLinkPreview")
+	defer span.End()
+
+	link, err := s.linkRepo.GetLink(ctx, linkID)
+	if err != nil {
+		return nil, err
+	}
+
+	if link.UserAID != userID && link.UserBID != userID {
+		return nil, ErrNotLinkMember
+	}
+
+	if link.Status != model.LinkStatusPending {
+		return nil, ErrLinkNotPending
+	}
+
+	otherID := link.UserBID
+	if link.UserBID == userID {
+		otherID = link.UserAID
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	peer, err := s.userRepo.GetByID(ctx, otherID.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	return &LinkPreview{
+		Link:     link,
+		Username: peer.Username,
+		Profile:  peer.Profile,
+		Distance: s.distanceBucket(user, peer),
+	}, nil
+}
+
+// PurgeStaleLocations drops raw coordinates that are past the retention window.
+func (s *UserService) PurgeStaleLocations(ctx context.Context) error {
+	ctx, span := startSpan(ctx, "PurgeStaleLocations")
+	defer span.End()
+
+	_, err := s.userRepo.ClearStaleLocations(ctx, time.Now().Add(-LocationRetention))
+	return err
+}
+
+// StopStaleSearches takes users whose location has gone stale out of the
+// searching pool. They rejoin by sending a fresh location and searching again.
+func (s *UserService) StopStaleSearches(ctx context.Context) error {
+	ctx, span := startSpan(ctx, "StopStaleSearches")
+	defer span.End()
+
+	_, err := s.userRepo.StopStaleSearches(ctx, s.locationFreshSince())
+	return err
+}
@@ -0,0 +1,16 @@
+package service
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/seunghoon34/linkapp/backend/internal/service")
+
+// startSpan starts the span for a UserService method, so a slow request
+// shows which step of it took the time.
+func startSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "UserService."+method)
+}
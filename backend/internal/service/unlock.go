@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/seunghoon34/linkapp/backend/internal/metrics"
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UnlockPolicy is the proof required, on top of the tap itself, that both
+// participants really met before a chatroom unlocks.
+type UnlockPolicy struct {
+	// MaxDistance is how far apart, in meters, the two reported locations
+	// can be.
+	MaxDistance float64
+	// MaxLocationAge is how old either participant's last location report
+	// can be at unlock time.
+	MaxLocationAge time.Duration
+	// TapWindow is how long one participant's tap waits for the other's.
+	TapWindow time.Duration
+}
+
+var DefaultUnlockPolicy = UnlockPolicy{
+	MaxDistance:    50,
+	MaxLocationAge: 2 * time.Minute,
+	TapWindow:      30 * time.Second,
+}
+
+var (
+	ErrUnlockPeerNotTapped = newError(KindPrecondition, "peer_not_tapped", "the other user has to tap too")
+	ErrUnlockLocationStale = newError(KindPrecondition, "stale_location", "both users need a recent location report to unlock")
+	ErrUnlockTooFar        = newError(KindForbidden, "too_far", "users are too far apart to unlock")
+	ErrChatroomNotLocked   = newError(KindConflict, "already_unlocked", "chatroom is already unlocked")
+	ErrNotChatroomMember   = newError(KindForbidden, "not_chatroom_member", "user is not part of this chatroom")
+)
+
+func (s *UserService) SetUnlockPolicy(policy UnlockPolicy) {
+	s.unlockPolicy = policy
+}
+
+// lockedChatroomFor loads a chatroom that userID belongs to and that is still locked.
+func (s *UserService) lockedChatroomFor(ctx context.Context, userID, chatroomID primitive.ObjectID) (*model.Chatroom, error) {
+	chatroom, err := s.chatroomRepo.GetChatroom(ctx, chatroomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if chatroom.UserAID != userID && chatroom.UserBID != userID {
+		return nil, ErrNotChatroomMember
+	}
+
+	if chatroom.IsClosed() {
+		return nil, ErrChatroomClosed
+	}
+
+	if !chatroom.IsLocked {
+		return nil, ErrChatroomNotLocked
+	}
+
+	return chatroom, nil
+}
+
+// unlock opens the chatroom for full chat and ends live location sharing.
+// nonce is the QR unlock token's, if that's how it was unlocked; it's only
+// spent if the chatroom actually unlocks.
+func (s *UserService) unlock(ctx context.Context, chatroom *model.Chatroom, nonce string) error {
+	unlocked, err := s.chatroomRepo.UnlockChatroom(ctx, chatroom.ID, nonce)
+	if err != nil {
+		return err
+	}
+	if !unlocked {
+		return ErrChatroomNotLocked
+	}
+
+	metrics.ObserveSince(metrics.TimeToUnlock, chatroom.CreatedAt)
+	slog.InfoContext(ctx, "chatroom unlocked", "chatroom_id", chatroom.ID.Hex())
+
+	return s.stopLocationSharing(context.WithoutCancel(ctx), chatroom.ID)
+}
+
+// countUnlockAttempt records an in-person unlock attempt under the error
+// code it failed with, or as unlocked.
+func countUnlockAttempt(method string, err error) {
+	outcome := "unlocked"
+	if err != nil {
+		outcome = "error"
+		var serviceErr *Error
+		if errors.As(err, &serviceErr) {
+			outcome = serviceErr.Code
+		}
+	}
+	metrics.UnlockAttempts.WithLabelValues(method, outcome).Inc()
+}
+
+// verifyMeeting records userID's tap on the chatroom and checks that the
+// peer tapped too and that both reported being close together recently.
+func (s *UserService) verifyMeeting(ctx context.Context, chatroom *model.Chatroom, userID primitive.ObjectID) error {
+	now := time.Now()
+	if err := s.chatroomRepo.RecordUnlockTap(ctx, chatroom.ID, userID, now); err != nil {
+		return err
+	}
+
+	peer := peerID(chatroom, userID)
+	peerTap, ok := chatroom.UnlockTaps[peer.Hex()]
+	if !ok || now.Sub(peerTap) > s.unlockPolicy.TapWindow {
+		return ErrUnlockPeerNotTapped
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID.Hex())
+	if err != nil {
+		return err
+	}
+
+	other, err := s.userRepo.GetByID(ctx, peer.Hex())
+	if err != nil {
+		return err
+	}
+
+	reportedSince := now.Add(-s.unlockPolicy.MaxLocationAge)
+	for _, u := range []*model.User{user, other} {
+		if u.Location.IsZero() || u.LocationUpdatedAt.Before(reportedSince) {
+			return ErrUnlockLocationStale
+		}
+	}
+
+	if distanceMeters(user.Location, other.Location) > s.unlockPolicy.MaxDistance {
+		return ErrUnlockTooFar
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/seunghoon34/linkapp/backend/internal/metrics"
+)
+
+var (
+	ErrInvalidUnlockToken = newError(KindInvalid, "invalid_token", "invalid unlock token")
+	ErrUnlockTokenExpired = newError(KindGone, "token_expired", "unlock token has expired")
+	ErrUnlockTokenUsed    = newError(KindConflict, "token_used", "unlock token has already been used")
+)
+
+// UnlockToken is shown as a QR code by one participant and scanned by the
+// other, for phones that can't do an NFC tap.
+type UnlockToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type unlockTokenClaims struct {
+	ChatroomID string `json:"c"`
+	IssuerID   string `json:"u"`
+	Nonce      string `json:"n"`
+	ExpiresAt  int64  `json:"e"`
+}
+
+func (s *UserService) SetUnlockTokenSecret(secret []byte) {
+	s.unlockTokenSecret = secret
+}
+
+func (s *UserService) encodeUnlockToken(claims unlockTokenClaims) (string, error) {
+	return encodeSignedToken(s.unlockTokenSecret, claims)
+}
+
+func (s *UserService) decodeUnlockToken(token string) (*unlockTokenClaims, error) {
+	var claims unlockTokenClaims
+	if !decodeSignedToken(s.unlockTokenSecret, token, &claims) {
+		return nil, ErrInvalidUnlockToken
+	}
+	return &claims, nil
+}
+
+// IssueUnlockToken creates a QR unlock token for userID to show their peer.
+// Showing the code counts as the issuer's tap, so it's only valid for the
+// unlock policy's tap window.
+func (s *UserService) IssueUnlockToken(ctx context.Context, userID, chatroomID primitive.ObjectID) (*UnlockToken, error) {
+	ctx, span := startSpan(ctx, "IssueUnlockToken")
+	defer span.End()
+
+	chatroom, err := s.lockedChatroomFor(ctx, userID, chatroomID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := s.chatroomRepo.RecordUnlockTap(ctx, chatroom.ID, userID, now); err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	expiresAt := now.Add(s.unlockPolicy.TapWindow)
+	token, err := s.encodeUnlockToken(unlockTokenClaims{
+		ChatroomID: chatroom.ID.Hex(),
+		IssuerID:   userID.Hex(),
+		Nonce:      hex.EncodeToString(nonce),
+		ExpiresAt:  expiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &UnlockToken{Token: token, ExpiresAt: expiresAt}, nil
+}
+
+// RedeemUnlockToken unlocks the chatroom when userID scans a token shown by
+// the other participant, applying the same checks as an NFC tap.
+func (s *UserService) RedeemUnlockToken(ctx context.Context, userID, chatroomID primitive.ObjectID, token string) (err error) {
+	ctx, span := startSpan(ctx, "RedeemUnlockToken")
+	defer span.End()
+
+	defer func() { countUnlockAttempt(metrics.UnlockQR, err) }()
+
+	claims, err := s.decodeUnlockToken(token)
+	if err != nil {
+		return err
+	}
+
+	if claims.ChatroomID != chatroomID.Hex() {
+		return ErrInvalidUnlockToken
+	}
+
+	if time.Now().Unix() > claims.ExpiresAt {
+		return ErrUnlockTokenExpired
+	}
+
+	chatroom, err := s.lockedChatroomFor(ctx, userID, chatroomID)
+	if err != nil {
+		return err
+	}
+
+	// The code has to come from the other participant
+	if claims.IssuerID != peerID(chatroom, userID).Hex() {
+		return ErrInvalidUnlockToken
+	}
+
+	// A token that fails the meeting check can be scanned again within its
+	// window, so its nonce is only spent by the unlock itself
+	if err := s.verifyMeeting(ctx, chatroom, userID); err != nil {
+		return err
+	}
+
+	err = s.unlock(ctx, chatroom, claims.Nonce)
+	if errors.Is(err, ErrChatroomNotLocked) && s.unlockNonceUsed(ctx, chatroomID, claims.Nonce) {
+		return ErrUnlockTokenUsed
+	}
+	return err
+}
+
+// unlockNonceUsed reports whether the chatroom was unlocked with nonce.
+func (s *UserService) unlockNonceUsed(ctx context.Context, chatroomID primitive.ObjectID, nonce string) bool {
+	chatroom, err := s.chatroomRepo.GetChatroom(ctx, chatroomID)
+	return err == nil && slices.Contains(chatroom.UsedUnlockNonces, nonce)
+}
@@ -1,273 +1,481 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"log/slog"
+	"sync"
+	"time"
 
+	"github.com/seunghoon34/linkapp/backend/internal/mail"
+	"github.com/seunghoon34/linkapp/backend/internal/metrics"
 	"github.com/seunghoon34/linkapp/backend/internal/model"
 	"github.com/seunghoon34/linkapp/backend/internal/repository"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// DefaultSearchRadius is how far away, in metres, a match can be.
+const DefaultSearchRadius = 200
+
 type UserService struct {
-	userRepo     *repository.UserRepository
-	linkRepo     *repository.LinkRepository
-	chatroomRepo *repository.ChatroomRepository
+	userRepo     repository.UserStore
+	linkRepo     repository.LinkStore
+	chatroomRepo repository.ChatroomStore
+
+	locations            *locationBroker
+	locationEvents       repository.LocationEventStore
+	locationFeed         sync.WaitGroup
+	linkTimers           *linkTimers
+	linkTTL              time.Duration
+	searchRadius         float64
+	locationSharingLimit time.Duration
+	locationStaleAfter   time.Duration
+	unlockPolicy         UnlockPolicy
+	unlockTokenSecret    []byte
+	lockedChatroomTTL    time.Duration
+	loginPolicy          LoginPolicy
+	loginAttempts        repository.LoginAttemptStore
+	securityEvents       repository.SecurityEventStore
+	mailer               mail.Mailer
+	mailInBackground     sync.WaitGroup
+	appURL               string
+	accountTokenPolicy   AccountTokenPolicy
+	accountTokenSecret   []byte
 }
 
-func NewUserService(userRepo *repository.UserRepository, linkRepo *repository.LinkRepository, chatroomRepo *repository.ChatroomRepository) *UserService {
+func NewUserService(userRepo repository.UserStore, linkRepo repository.LinkStore, chatroomRepo repository.ChatroomStore) *UserService {
 	return &UserService{
 		userRepo:     userRepo,
 		linkRepo:     linkRepo,
 		chatroomRepo: chatroomRepo,
+
+		locations:            newLocationBroker(),
+		linkTimers:           newLinkTimers(),
+		linkTTL:              DefaultLinkTTL,
+		searchRadius:         DefaultSearchRadius,
+		locationSharingLimit: DefaultLocationSharingLimit,
+		locationStaleAfter:   DefaultLocationStaleAfter,
+		unlockPolicy:         DefaultUnlockPolicy,
+		unlockTokenSecret:    randomSecret(),
+		lockedChatroomTTL:    DefaultLockedChatroomTTL,
+		loginPolicy:          DefaultLoginPolicy,
+		mailer:               mail.Discard,
+		appURL:               DefaultAppURL,
+		accountTokenPolicy:   DefaultAccountTokenPolicy,
+		accountTokenSecret:   randomSecret(),
 	}
 }
 
-func (s *UserService) CreateUser(user *model.User) error {
+// CreateUser normalizes and validates the signup details before storing the
+// user. A taken email or username comes back as a repository.ConflictError.
+func (s *UserService) CreateUser(ctx context.Context, user *model.User) error {
+	ctx, span := startSpan(ctx, "CreateUser")
+	defer span.End()
+
+	user.Username = NormalizeUsername(user.Username)
+	user.Email = NormalizeEmail(user.Email)
+
+	errs := fieldErrors{}
+	validateUsername(errs, user.Username)
+	validateEmail(errs, user.Email)
+	validatePassword(errs, user.Password)
+	if err := errs.err(); err != nil {
+		return err
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return err
 	}
 	user.Password = string(hashedPassword)
-	return s.userRepo.Create(user)
+	if err := s.userRepo.Create(ctx, user); err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "user created", "user_id", user.ID.Hex())
+	s.sendVerificationInBackground(ctx, user)
+	return nil
+}
+
+func (s *UserService) SetSearchRadius(meters float64) {
+	s.searchRadius = meters
 }
 
-func (s *UserService) GetUserByID(id string) (*model.User, error) {
-	return s.userRepo.GetByID(id)
+func (s *UserService) GetUserByID(ctx context.Context, id string) (*model.User, error) {
+	ctx, span := startSpan(ctx, "GetUserByID")
+	defer span.End()
+
+	return s.userRepo.GetByID(ctx, id)
 
 }
 
-func (s *UserService) UpdateProfile(id string, profile model.Profile) error {
-	return s.userRepo.UpdateProfile(id, profile)
+func (s *UserService) UpdateProfile(ctx context.Context, id string, profile model.Profile) error {
+	ctx, span := startSpan(ctx, "UpdateProfile")
+	defer span.End()
+
+	return s.userRepo.UpdateProfile(ctx, id, profile)
 }
 
-func (s *UserService) UpdatePreferences(id string, preferences model.Preferences) error {
-	return s.userRepo.UpdatePreferences(id, preferences)
+func (s *UserService) UpdatePreferences(ctx context.Context, id string, preferences model.Preferences) error {
+	ctx, span := startSpan(ctx, "UpdatePreferences")
+	defer span.End()
+
+	return s.userRepo.UpdatePreferences(ctx, id, preferences)
 }
 
-func (s *UserService) UpdateUser(id string, username, email string) error {
-	user, err := s.userRepo.GetByID(id)
+func (s *UserService) UpdateUser(ctx context.Context, id string, username, email string) error {
+	ctx, span := startSpan(ctx, "UpdateUser")
+	defer span.End()
+
+	user, err := s.userRepo.GetByID(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	user.Username = username
-	user.Email = email
+	user.Username = NormalizeUsername(username)
+	// A new email has to be verified again
+	emailChanged := NormalizeEmail(email) != user.Email
+	if emailChanged {
+		user.Email = NormalizeEmail(email)
+		user.EmailVerifiedAt = time.Time{}
+	}
+
+	errs := fieldErrors{}
+	validateUsername(errs, user.Username)
+	validateEmail(errs, user.Email)
+	if err := errs.err(); err != nil {
+		return err
+	}
+
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		return err
+	}
 
-	return s.userRepo.Update(user)
+	if emailChanged {
+		s.sendVerificationInBackground(ctx, user)
+	}
+	return nil
 }
 
-var (
-	ErrInvalidCredentials = errors.New("invalid email or password")
-	ErrUserNotFound       = errors.New("user not found")
-)
+// dummyPasswordHash stands in for the stored hash when no account has the
+// email. Its cost matches the real hashes', so checking a password against
+// it takes as long as checking a real one.
+const dummyPasswordHash = "$2a$10$k41fKW.p5qPfQbfvCViWPeEV1JQTnf6.aYmnodLJGdUkBSqftgV0."
+
+// AuthenticateUser checks the email and password, unless too many logins
+// for the email or from clientIP have failed lately, in which case it
+// returns a *LoginThrottledError without checking anything.
+func (s *UserService) AuthenticateUser(ctx context.Context, email, password, clientIP string) (*model.User, error) {
+	ctx, span := startSpan(ctx, "AuthenticateUser")
+	defer span.End()
+
+	email = NormalizeEmail(email)
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
 
-func (s *UserService) AuthenticateUser(email, password string) (*model.User, error) {
-	user, err := s.userRepo.GetByEmail(email)
+	wait, err := s.loginWait(ctx, email, clientIP)
 	if err != nil {
-		if errors.Is(err, ErrUserNotFound) {
-			// Use the same error message for non-existent user to prevent email enumeration
-			return nil, ErrInvalidCredentials
+		return nil, err
+	}
+	if wait > 0 {
+		event := &model.SecurityEvent{Type: model.SecurityEventLoginThrottled, IP: clientIP}
+		if user != nil {
+			event.UserID = user.ID
 		}
-		return nil, err // Return the original error for other database issues
+		s.recordSecurityEvent(ctx, event)
+		return nil, &LoginThrottledError{RetryAfter: wait}
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
-	if err != nil {
-		// Use a generic error message to prevent timing attacks
+	// An unknown email still costs a bcrypt comparison, so response times
+	// don't give away which emails have accounts. Both cases get the same
+	// error for the same reason.
+	hash := dummyPasswordHash
+	if user != nil {
+		hash = user.Password
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil || user == nil {
+		if err := s.recordLoginFailure(ctx, email, clientIP, user); err != nil {
+			return nil, err
+		}
 		return nil, ErrInvalidCredentials
 	}
 
+	s.clearLoginFailures(ctx, email)
+
 	// Create a new user object without the password field
 	authenticatedUser := &model.User{
-		ID:       user.ID,
-		Username: user.Username,
-		Email:    user.Email,
+		ID:              user.ID,
+		Username:        user.Username,
+		Email:           user.Email,
+		EmailVerifiedAt: user.EmailVerifiedAt,
 		// Add other fields as necessary, but omit the password
 	}
 
 	return authenticatedUser, nil
 }
 
-func (s *UserService) SearchMatches(userID string, limit int) ([]*model.User, error) {
-	user, err := s.GetUserByID(userID)
+func (s *UserService) SearchMatches(ctx context.Context, userID string, limit, offset int) ([]*PublicUser, error) {
+	ctx, span := startSpan(ctx, "SearchMatches")
+	defer span.End()
+
+	user, err := s.GetUserByID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
 
-	return s.userRepo.SearchMatches(user, limit)
-}
-
-func (s *UserService) UpdateLocation(userID string, latitude, longitude float64) error {
-	// Basic validation
-	if latitude < -90 || latitude > 90 {
-		return errors.New("invalid latitude")
+	matches, err := s.userRepo.SearchMatches(ctx, user, s.locationFreshSince(), s.searchRadius, limit, offset)
+	if err != nil {
+		return nil, err
 	}
-	if longitude < -180 || longitude > 180 {
-		return errors.New("invalid longitude")
+
+	publicMatches := make([]*PublicUser, len(matches))
+	for i, match := range matches {
+		publicMatches[i] = s.toPublicUser(match, user)
 	}
 
-	return s.userRepo.UpdateLocation(userID, latitude, longitude)
+	return publicMatches, nil
 }
 
-func (s *UserService) StartSearching(userID primitive.ObjectID) error {
-	return s.userRepo.SetSearchingStatus(userID, true)
+func (s *UserService) UpdateLocation(ctx context.Context, userID string, latitude, longitude float64) error {
+	ctx, span := startSpan(ctx, "UpdateLocation")
+	defer span.End()
+
+	if err := validateCoordinates(latitude, longitude); err != nil {
+		return err
+	}
+
+	return s.userRepo.UpdateLocation(ctx, userID, latitude, longitude)
 }
 
-func (s *UserService) StopSearching(userID primitive.ObjectID) error {
-	return s.userRepo.SetSearchingStatus(userID, false)
+func (s *UserService) GetLocationHistory(ctx context.Context, userID primitive.ObjectID) ([]*model.LocationUpdate, error) {
+	ctx, span := startSpan(ctx, "GetLocationHistory")
+	defer span.End()
+
+	return s.userRepo.GetLocationHistory(ctx, userID)
 }
 
-func (s *UserService) FindMatch(userID primitive.ObjectID) (*model.Link, error) {
-	user, err := s.userRepo.GetByID(userID.Hex())
+func (s *UserService) StartSearching(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, span := startSpan(ctx, "StartSearching")
+	defer span.End()
+
+	user, err := s.userRepo.GetByID(ctx, userID.Hex())
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if !user.IsSearching {
-		return nil, errors.New("user is not in searching mode")
+	if !user.EmailVerified() {
+		return ErrEmailNotVerified
 	}
 
-	potentialMatch, err := s.userRepo.FindPotentialMatch(user)
+	if s.isLocationStale(user) {
+		return ErrLocationStale
+	}
+
+	return s.transition(ctx, userID, model.UserStateSearching, primitive.NilObjectID, primitive.NilObjectID)
+}
+
+func (s *UserService) StopSearching(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, span := startSpan(ctx, "StopSearching")
+	defer span.End()
+
+	return s.transition(ctx, userID, model.UserStateIdle, primitive.NilObjectID, primitive.NilObjectID)
+}
+
+func (s *UserService) FindMatch(ctx context.Context, userID primitive.ObjectID) (*model.Link, error) {
+	ctx, span := startSpan(ctx, "FindMatch")
+	defer span.End()
+
+	user, err := s.userRepo.GetByID(ctx, userID.Hex())
 	if err != nil {
 		return nil, err
 	}
 
-	if potentialMatch == nil {
-		return nil, errors.New("no potential match found")
+	if user.State != model.UserStateSearching {
+		return nil, ErrInvalidTransition
+	}
+
+	if s.isLocationStale(user) {
+		return nil, ErrLocationStale
 	}
 
-	link, err := s.linkRepo.CreateLink(user.ID, potentialMatch.ID)
+	potentialMatch, err := s.userRepo.FindPotentialMatch(ctx, user, s.locationFreshSince(), s.searchRadius)
 	if err != nil {
 		return nil, err
 	}
 
-	err = s.userRepo.SetCurrentLink(user.ID, link.ID)
+	if potentialMatch == nil {
+		return nil, ErrNoMatchFound
+	}
+
+	link, err := s.linkRepo.CreateLink(ctx, user.ID, potentialMatch.ID, s.linkTTL)
 	if err != nil {
 		return nil, err
 	}
 
-	err = s.userRepo.SetCurrentLink(potentialMatch.ID, link.ID)
+	// With the link created, a client going away mustn't leave it half set up
+	ctx = context.WithoutCancel(ctx)
+
+	// Either user may have been linked by someone else in the meantime
+	err = s.transitionPair(ctx, user.ID, potentialMatch.ID, model.UserStateLinked, primitive.NilObjectID, link.ID)
 	if err != nil {
+		s.linkRepo.UpdateLinkStatus(ctx, link.ID, model.LinkStatusExpired)
 		return nil, err
 	}
 
+	metrics.Links.WithLabelValues(metrics.LinkCreated).Inc()
+	slog.InfoContext(ctx, "link created", "link_id", link.ID.Hex(), "user_a_id", link.UserAID.Hex(), "user_b_id", link.UserBID.Hex())
+	metrics.ObserveSince(metrics.TimeToMatch, user.StateChangedAt)
+	metrics.ObserveSince(metrics.TimeToMatch, potentialMatch.StateChangedAt)
+
+	s.scheduleLinkExpiry(link)
+
 	return link, nil
 }
 
-func (s *UserService) RespondToLink(userID primitive.ObjectID, linkID primitive.ObjectID, accept bool) error {
-	link, err := s.linkRepo.GetLink(linkID)
+func (s *UserService) RespondToLink(ctx context.Context, userID primitive.ObjectID, linkID primitive.ObjectID, accept bool) error {
+	ctx, span := startSpan(ctx, "RespondToLink")
+	defer span.End()
+
+	link, err := s.linkRepo.GetLink(ctx, linkID)
 	if err != nil {
 		return err
 	}
 
 	if link.UserAID != userID && link.UserBID != userID {
-		return errors.New("user is not part of this link")
+		return ErrNotLinkMember
 	}
 
-	if accept {
-		err = s.linkRepo.UpdateLinkStatus(linkID, model.LinkStatusAccepted)
-		if err == nil {
-			// Create a new chatroom
-			_, err = s.chatroomRepo.CreateChatroom(linkID, link.UserAID, link.UserBID)
-		}
-	} else {
-		err = s.linkRepo.UpdateLinkStatus(linkID, model.LinkStatusRejected)
-		if err == nil {
-			// If rejected, set both users back to searching
-			s.userRepo.SetSearchingStatus(link.UserAID, true)
-			s.userRepo.SetSearchingStatus(link.UserBID, true)
-			s.userRepo.SetCurrentLink(link.UserAID, primitive.NilObjectID)
-			s.userRepo.SetCurrentLink(link.UserBID, primitive.NilObjectID)
-		}
+	if link.Status != model.LinkStatusPending {
+		return ErrInvalidTransition
 	}
 
-	return err
-}
+	status := model.LinkStatusRejected
+	if accept {
+		status = model.LinkStatusAccepted
+	}
 
-func (s *UserService) ExpireLinks() error {
-	err := s.linkRepo.ExpireLinks()
+	// Settle the link before moving anyone, so a second answer or one that
+	// lost the race with expiry can't move the users again
+	answered, err := s.linkRepo.AnswerLink(ctx, linkID, status)
 	if err != nil {
 		return err
 	}
+	if !answered {
+		return ErrLinkNotPending
+	}
 
-	expiredLinks, err := s.linkRepo.GetExpiredLinks()
+	// The link's timer is gone from here on, so see the answer through even
+	// if the client disconnects
+	ctx = context.WithoutCancel(ctx)
+	s.cancelLinkExpiry(linkID)
+
+	if accept {
+		err = s.transitionPair(ctx, link.UserAID, link.UserBID, model.UserStateInChat, linkID, linkID)
+	} else {
+		// If rejected, set both users back to searching
+		err = s.transitionPair(ctx, link.UserAID, link.UserBID, model.UserStateSearching, linkID, primitive.NilObjectID)
+	}
 	if err != nil {
+		// Neither user moved, so let the link go rather than leave them on it
+		if expireErr := s.linkRepo.UpdateLinkStatus(ctx, linkID, model.LinkStatusExpired); expireErr != nil {
+			slog.ErrorContext(ctx, "expiring unanswerable link failed", "link_id", linkID.Hex(), "error", expireErr)
+		}
+		if releaseErr := s.releaseLinkedUsers(ctx, link); releaseErr != nil {
+			slog.ErrorContext(ctx, "releasing linked users failed", "link_id", linkID.Hex(), "error", releaseErr)
+		}
 		return err
 	}
 
-	for _, link := range expiredLinks {
-		s.userRepo.SetSearchingStatus(link.UserAID, true)
-		s.userRepo.SetSearchingStatus(link.UserBID, true)
-		s.userRepo.SetCurrentLink(link.UserAID, primitive.NilObjectID)
-		s.userRepo.SetCurrentLink(link.UserBID, primitive.NilObjectID)
+	if !accept {
+		metrics.Links.WithLabelValues(metrics.LinkRejected).Inc()
+		slog.InfoContext(ctx, "link rejected", "link_id", linkID.Hex(), "user_id", userID.Hex())
+		return nil
 	}
 
-	return nil
+	metrics.Links.WithLabelValues(metrics.LinkAccepted).Inc()
+	slog.InfoContext(ctx, "link accepted", "link_id", linkID.Hex(), "user_id", userID.Hex())
+	// Create a new chatroom
+	_, err = s.chatroomRepo.CreateChatroom(ctx, linkID, link.UserAID, link.UserBID)
+	return err
 }
 
-func (s *UserService) SendMessage(userID, chatroomID primitive.ObjectID, content string) (*model.Message, error) {
-	chatroom, err := s.chatroomRepo.GetChatroom(chatroomID)
+func (s *UserService) SendMessage(ctx context.Context, userID, chatroomID primitive.ObjectID, content string) (*model.Message, error) {
+	ctx, span := startSpan(ctx, "SendMessage")
+	defer span.End()
+
+	chatroom, err := s.chatroomRepo.GetChatroom(ctx, chatroomID)
 	if err != nil {
 		return nil, err
 	}
 
 	if chatroom.UserAID != userID && chatroom.UserBID != userID {
-		return nil, errors.New("user is not part of this chatroom")
+		return nil, ErrNotChatroomMember
+	}
+
+	if chatroom.IsClosed() {
+		return nil, ErrChatroomClosed
 	}
 
 	if chatroom.IsLocked {
-		messages, err := s.chatroomRepo.GetMessages(chatroomID)
+		messages, err := s.chatroomRepo.GetMessages(ctx, chatroomID)
 		if err != nil {
 			return nil, err
 		}
 		if len(messages) >= 2 {
-			return nil, errors.New("chatroom is locked and message limit reached")
+			return nil, ErrMessageLimitReached
 		}
 	}
 
-	return s.chatroomRepo.AddMessage(chatroomID, userID, content)
+	message, err := s.chatroomRepo.AddMessage(ctx, chatroomID, userID, content)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.MessagesSent.Inc()
+	slog.DebugContext(ctx, "message sent", "message", message)
+	return message, nil
 }
 
-func (s *UserService) GetMessages(userID, chatroomID primitive.ObjectID) ([]*model.Message, error) {
-	chatroom, err := s.chatroomRepo.GetChatroom(chatroomID)
+func (s *UserService) GetMessages(ctx context.Context, userID, chatroomID primitive.ObjectID) ([]*model.Message, error) {
+	ctx, span := startSpan(ctx, "GetMessages")
+	defer span.End()
+
+	chatroom, err := s.chatroomRepo.GetChatroom(ctx, chatroomID)
 	if err != nil {
 		return nil, err
 	}
 
 	if chatroom.UserAID != userID && chatroom.UserBID != userID {
-		return nil, errors.New("user is not part of this chatroom")
+		return nil, ErrNotChatroomMember
 	}
 
-	return s.chatroomRepo.GetMessages(chatroomID)
+	return s.chatroomRepo.GetMessages(ctx, chatroomID)
 }
 
-func (s *UserService) UnlockChatroom(chatroomID primitive.ObjectID) error {
-	return s.chatroomRepo.UnlockChatroom(chatroomID)
-}
+func (s *UserService) VerifyNFCAndUnlockChatroom(ctx context.Context, userID, chatroomID primitive.ObjectID) (err error) {
+	ctx, span := startSpan(ctx, "VerifyNFCAndUnlockChatroom")
+	defer span.End()
 
-func (s *UserService) VerifyNFCAndUnlockChatroom(userID, chatroomID primitive.ObjectID) error {
-	// Get the chatroom
-	chatroom, err := s.chatroomRepo.GetChatroom(chatroomID)
+	defer func() { countUnlockAttempt(metrics.UnlockNFC, err) }()
+
+	// Check that the user is part of this chatroom and it's still locked
+	chatroom, err := s.lockedChatroomFor(ctx, userID, chatroomID)
 	if err != nil {
 		return err
 	}
 
-	// Check if the user is part of this chatroom
-	if chatroom.UserAID != userID && chatroom.UserBID != userID {
-		return errors.New("user is not part of this chatroom")
-	}
-
-	// Check if the chatroom is already unlocked
-	if !chatroom.IsLocked {
-		return errors.New("chatroom is already unlocked")
+	// A tap alone can be replayed by a modified client, so both users must
+	// also have tapped recently and reported being close to each other.
+	if err = s.verifyMeeting(ctx, chatroom, userID); err != nil {
+		return err
 	}
 
-	// In a real-world scenario, we might want to implement additional verification here,
-	// such as checking if both users have reported an NFC connection within a short time frame.
-
 	// Unlock the chatroom
-	err = s.chatroomRepo.UnlockChatroom(chatroomID)
+	err = s.unlock(ctx, chatroom, "")
 	if err != nil {
 		return err
 	}
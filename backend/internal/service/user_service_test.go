@@ -0,0 +1,329 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+	"github.com/seunghoon34/linkapp/backend/internal/repository/memory"
+	"github.com/seunghoon34/linkapp/backend/internal/repository/repotest"
+)
+
+type testService struct {
+	*UserService
+	users     *memory.UserRepository
+	links     *memory.LinkRepository
+	chatrooms *memory.ChatroomRepository
+}
+
+// newTestService returns a service on empty memory stores.
+func newTestService(t *testing.T) *testService {
+	t.Helper()
+
+	users := memory.NewUserRepository()
+	links := memory.NewLinkRepository()
+	chatrooms := memory.NewChatroomRepository()
+	s := NewUserService(users, links, chatrooms)
+	t.Cleanup(s.StopLinkExpiry)
+
+	return &testService{UserService: s, users: users, links: links, chatrooms: chatrooms}
+}
+
+// searchingUser stores a verified user from repotest.NewUser and starts them
+// searching at the given point.
+func (s *testService) searchingUser(t *testing.T, longitude, latitude float64) primitive.ObjectID {
+	t.Helper()
+	ctx := context.Background()
+
+	user := repotest.NewUser(primitive.NewObjectID())
+	user.EmailVerifiedAt = time.Now()
+	if err := s.users.Create(ctx, user); err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	if err := s.UpdateLocation(ctx, user.ID.Hex(), latitude, longitude); err != nil {
+		t.Fatalf("updating location: %v", err)
+	}
+	if err := s.StartSearching(ctx, user.ID); err != nil {
+		t.Fatalf("starting search: %v", err)
+	}
+
+	return user.ID
+}
+
+func (s *testService) user(t *testing.T, id primitive.ObjectID) *model.User {
+	t.Helper()
+
+	user, err := s.users.GetByID(context.Background(), id.Hex())
+	if err != nil {
+		t.Fatalf("loading user: %v", err)
+	}
+	return user
+}
+
+func (s *testService) link(t *testing.T, id primitive.ObjectID) *model.Link {
+	t.Helper()
+
+	link, err := s.links.GetLink(context.Background(), id)
+	if err != nil {
+		t.Fatalf("loading link: %v", err)
+	}
+	return link
+}
+
+// wantState fails the test unless every user is in state, linked to linkID.
+func (s *testService) wantState(t *testing.T, state model.UserState, linkID primitive.ObjectID, ids ...primitive.ObjectID) {
+	t.Helper()
+
+	for _, id := range ids {
+		user := s.user(t, id)
+		if user.State != state || user.CurrentLinkID != linkID {
+			t.Errorf("user %s is %s on link %s, want %s on link %s",
+				id.Hex(), user.State, user.CurrentLinkID.Hex(), state, linkID.Hex())
+		}
+	}
+}
+
+// linkedPair finds a match for two nearby searchers and returns them with
+// their link.
+func (s *testService) linkedPair(t *testing.T) (primitive.ObjectID, primitive.ObjectID, *model.Link) {
+	t.Helper()
+
+	userA := s.searchingUser(t, 0, 0)
+	userB := s.searchingUser(t, 0.001, 0)
+
+	link, err := s.FindMatch(context.Background(), userA)
+	if err != nil {
+		t.Fatalf("finding a match: %v", err)
+	}
+	return userA, userB, link
+}
+
+func TestFindMatch(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	userA := s.searchingUser(t, 0, 0)
+	userB := s.searchingUser(t, 0.001, 0)
+	farAway := s.searchingUser(t, 1, 0)
+
+	link, err := s.FindMatch(ctx, userA)
+	if err != nil {
+		t.Fatalf("FindMatch() error = %v", err)
+	}
+	if link.UserAID != userA || link.UserBID != userB {
+		t.Errorf("linked %s and %s, want %s and %s", link.UserAID.Hex(), link.UserBID.Hex(), userA.Hex(), userB.Hex())
+	}
+	if got := s.link(t, link.ID).Status; got != model.LinkStatusPending {
+		t.Errorf("link status = %s, want pending", got)
+	}
+	s.wantState(t, model.UserStateLinked, link.ID, userA, userB)
+	s.wantState(t, model.UserStateSearching, primitive.NilObjectID, farAway)
+
+	if _, err := s.FindMatch(ctx, userA); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("FindMatch() for a linked user error = %v, want ErrInvalidTransition", err)
+	}
+	if _, err := s.FindMatch(ctx, farAway); !errors.Is(err, ErrNoMatchFound) {
+		t.Errorf("FindMatch() with nobody nearby error = %v, want ErrNoMatchFound", err)
+	}
+}
+
+func TestRespondToLinkAccept(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	userA, userB, link := s.linkedPair(t)
+
+	if err := s.RespondToLink(ctx, userB, link.ID, true); err != nil {
+		t.Fatalf("RespondToLink() error = %v", err)
+	}
+
+	if got := s.link(t, link.ID).Status; got != model.LinkStatusAccepted {
+		t.Errorf("link status = %s, want accepted", got)
+	}
+	s.wantState(t, model.UserStateInChat, link.ID, userA, userB)
+
+	chatrooms, err := s.chatrooms.GetExpiredLockedChatrooms(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chatrooms) != 1 || chatrooms[0].LinkID != link.ID {
+		t.Fatalf("got %d chatrooms, want one for the link", len(chatrooms))
+	}
+
+	if err := s.RespondToLink(ctx, userA, link.ID, false); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("answering an answered link error = %v, want ErrInvalidTransition", err)
+	}
+	s.wantState(t, model.UserStateInChat, link.ID, userA, userB)
+}
+
+func TestRespondToLinkReject(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	userA, userB, link := s.linkedPair(t)
+
+	if err := s.RespondToLink(ctx, userA, link.ID, false); err != nil {
+		t.Fatalf("RespondToLink() error = %v", err)
+	}
+
+	if got := s.link(t, link.ID).Status; got != model.LinkStatusRejected {
+		t.Errorf("link status = %s, want rejected", got)
+	}
+	s.wantState(t, model.UserStateSearching, primitive.NilObjectID, userA, userB)
+
+	chatrooms, err := s.chatrooms.GetExpiredLockedChatrooms(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chatrooms) != 0 {
+		t.Errorf("got %d chatrooms for a rejected link, want none", len(chatrooms))
+	}
+}
+
+func TestRespondToLinkNotMember(t *testing.T) {
+	s := newTestService(t)
+	_, _, link := s.linkedPair(t)
+
+	err := s.RespondToLink(context.Background(), primitive.NewObjectID(), link.ID, true)
+	if !errors.Is(err, ErrNotLinkMember) {
+		t.Errorf("RespondToLink() error = %v, want ErrNotLinkMember", err)
+	}
+}
+
+func TestRespondToLinkExpired(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	// Keep the timer out of it, as if the instance holding it had stopped
+	s.StopLinkExpiry()
+	s.SetLinkTTL(10 * time.Millisecond)
+	userA, userB, link := s.linkedPair(t)
+	time.Sleep(20 * time.Millisecond)
+
+	if err := s.RespondToLink(ctx, userB, link.ID, true); !errors.Is(err, ErrLinkNotPending) {
+		t.Fatalf("RespondToLink() error = %v, want ErrLinkNotPending", err)
+	}
+	if got := s.link(t, link.ID).Status; got != model.LinkStatusPending {
+		t.Errorf("link status = %s, want it left for expiry", got)
+	}
+	s.wantState(t, model.UserStateLinked, link.ID, userA, userB)
+
+	if err := s.expireLink(ctx, link.ID); err != nil {
+		t.Fatalf("expireLink() error = %v", err)
+	}
+	if got := s.link(t, link.ID).Status; got != model.LinkStatusExpired {
+		t.Errorf("link status = %s, want expired", got)
+	}
+	s.wantState(t, model.UserStateSearching, primitive.NilObjectID, userA, userB)
+}
+
+func TestLinkExpiry(t *testing.T) {
+	s := newTestService(t)
+	s.SetLinkTTL(50 * time.Millisecond)
+	userA, userB, link := s.linkedPair(t)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.link(t, link.ID).Status == model.LinkStatusPending {
+		if time.Now().After(deadline) {
+			t.Fatal("link never expired")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := s.link(t, link.ID).Status; got != model.LinkStatusExpired {
+		t.Errorf("link status = %s, want expired", got)
+	}
+	s.wantState(t, model.UserStateSearching, primitive.NilObjectID, userA, userB)
+}
+
+func TestRecoverLinkExpiry(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("expires pending links", func(t *testing.T) {
+		s := newTestService(t)
+		userA := s.searchingUser(t, 0, 0)
+		userB := s.searchingUser(t, 0.001, 0)
+
+		// A link made by an instance that stopped, already past its time
+		link, err := s.links.CreateLink(ctx, userA, userB, -time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if moved, err := s.users.TransitionPair(ctx, userA, userB, repository.StateTransition{
+			From:   []model.UserState{model.UserStateSearching},
+			To:     model.UserStateLinked,
+			LinkID: link.ID,
+		}); err != nil || !moved {
+			t.Fatalf("linking: moved %v, err %v", moved, err)
+		}
+
+		if err := s.RecoverLinkExpiry(ctx); err != nil {
+			t.Fatalf("RecoverLinkExpiry() error = %v", err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for s.link(t, link.ID).Status == model.LinkStatusPending {
+			if time.Now().After(deadline) {
+				t.Fatal("link never expired")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		s.StopLinkExpiry()
+		s.wantState(t, model.UserStateSearching, primitive.NilObjectID, userA, userB)
+	})
+
+	t.Run("releases users stuck on a settled link", func(t *testing.T) {
+		s := newTestService(t)
+		s.SetLinkTTL(10 * time.Millisecond)
+		s.StopLinkExpiry()
+		userA, userB, link := s.linkedPair(t)
+
+		// The link expired but releasing its users never happened
+		if _, err := s.links.ExpireLink(ctx, link.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.RecoverLinkExpiry(ctx); err != nil {
+			t.Fatalf("RecoverLinkExpiry() error = %v", err)
+		}
+		s.wantState(t, model.UserStateLinked, link.ID, userA, userB)
+
+		time.Sleep(20 * time.Millisecond)
+		if err := s.RecoverLinkExpiry(ctx); err != nil {
+			t.Fatalf("RecoverLinkExpiry() error = %v", err)
+		}
+		s.wantState(t, model.UserStateSearching, primitive.NilObjectID, userA, userB)
+	})
+}
+
+func TestSendMessageLockedLimit(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	userA, userB := primitive.NewObjectID(), primitive.NewObjectID()
+	chatroom, err := s.chatrooms.CreateChatroom(ctx, primitive.NewObjectID(), userA, userB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sender := range []primitive.ObjectID{userA, userB} {
+		if _, err := s.SendMessage(ctx, sender, chatroom.ID, "hi"); err != nil {
+			t.Fatalf("SendMessage() error = %v", err)
+		}
+	}
+	if _, err := s.SendMessage(ctx, userA, chatroom.ID, "hello?"); !errors.Is(err, ErrMessageLimitReached) {
+		t.Fatalf("third message in a locked chatroom error = %v, want ErrMessageLimitReached", err)
+	}
+	if _, err := s.SendMessage(ctx, primitive.NewObjectID(), chatroom.ID, "hi"); !errors.Is(err, ErrNotChatroomMember) {
+		t.Errorf("message from outsider error = %v, want ErrNotChatroomMember", err)
+	}
+
+	if unlocked, err := s.chatrooms.UnlockChatroom(ctx, chatroom.ID, ""); err != nil || !unlocked {
+		t.Fatalf("unlocking: unlocked %v, err %v", unlocked, err)
+	}
+	if _, err := s.SendMessage(ctx, userA, chatroom.ID, "hello?"); err != nil {
+		t.Errorf("SendMessage() once unlocked error = %v", err)
+	}
+}
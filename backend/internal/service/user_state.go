@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+	"github.com/seunghoon34/linkapp/backend/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrInvalidTransition = newError(KindConflict, "invalid_transition", "user can't make that move from their current state")
+
+// userTransitions lists, for each state, the states a user can move into it from.
+var userTransitions = map[model.UserState][]model.UserState{
+	model.UserStateIdle: {
+		model.UserStateSearching, // stopped searching or location went stale
+		model.UserStateInChat,    // chatroom closed
+	},
+	model.UserStateSearching: {
+		model.UserStateIdle,   // started searching
+		model.UserStateLinked, // link rejected or expired
+	},
+	model.UserStateLinked: {
+		model.UserStateSearching, // matched
+	},
+	model.UserStateInChat: {
+		model.UserStateLinked, // link accepted
+	},
+	model.UserStateSuspended: {
+		model.UserStateIdle,
+		model.UserStateSearching,
+		model.UserStateLinked,
+		model.UserStateInChat,
+	},
+}
+
+// transition moves the user into state to, returning ErrInvalidTransition if
+// their current state doesn't allow it. A non-nil onLink also requires the
+// user to still be on that link, and linkID becomes their current link.
+func (s *UserService) transition(ctx context.Context, userID primitive.ObjectID, to model.UserState, onLink, linkID primitive.ObjectID) error {
+	moved, err := s.userRepo.TransitionState(ctx, userID, repository.StateTransition{
+		From:   userTransitions[to],
+		To:     to,
+		OnLink: onLink,
+		LinkID: linkID,
+	})
+	if err != nil {
+		return err
+	}
+	if !moved {
+		return ErrInvalidTransition
+	}
+
+	slog.DebugContext(ctx, "user state changed", "user_id", userID.Hex(), "state", to)
+	return nil
+}
+
+// transitionPair moves both users of a link into state to together. The
+// store applies both moves atomically, so if either user can't make the move
+// neither changes.
+func (s *UserService) transitionPair(ctx context.Context, userAID, userBID primitive.ObjectID, to model.UserState, onLink, linkID primitive.ObjectID) error {
+	moved, err := s.userRepo.TransitionPair(ctx, userAID, userBID, repository.StateTransition{
+		From:   userTransitions[to],
+		To:     to,
+		OnLink: onLink,
+		LinkID: linkID,
+	})
+	if err != nil {
+		return err
+	}
+	if !moved {
+		return ErrInvalidTransition
+	}
+
+	slog.DebugContext(ctx, "user states changed", "user_a_id", userAID.Hex(), "user_b_id", userBID.Hex(), "state", to)
+	return nil
+}
+
+func (s *UserService) SuspendUser(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, span := startSpan(ctx, "SuspendUser")
+	defer span.End()
+
+	if err := s.transition(ctx, userID, model.UserStateSuspended, primitive.NilObjectID, primitive.NilObjectID); err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "user suspended", "user_id", userID.Hex())
+	return nil
+}
+
+// ReinstateUser lifts a suspension. It's kept out of userTransitions so that
+// no other move into idle can end one.
+func (s *UserService) ReinstateUser(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, span := startSpan(ctx, "ReinstateUser")
+	defer span.End()
+
+	moved, err := s.userRepo.TransitionState(ctx, userID, repository.StateTransition{
+		From: []model.UserState{model.UserStateSuspended},
+		To:   model.UserStateIdle,
+	})
+	if err != nil {
+		return err
+	}
+	if !moved {
+		return ErrInvalidTransition
+	}
+
+	slog.InfoContext(ctx, "user reinstated", "user_id", userID.Hex())
+	return nil
+}
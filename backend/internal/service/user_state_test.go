@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/seunghoon34/linkapp/backend/internal/model"
+)
+
+func TestSuspendUser(t *testing.T) {
+	ctx := context.Background()
+
+	// Each setup returns a user in the state under test and their partner,
+	// if they have one
+	tests := []struct {
+		state model.UserState
+		setup func(t *testing.T, s *testService) (user, partner primitive.ObjectID, link *model.Link)
+	}{
+		{model.UserStateIdle, func(t *testing.T, s *testService) (primitive.ObjectID, primitive.ObjectID, *model.Link) {
+			user := s.searchingUser(t, 0, 0)
+			if err := s.StopSearching(ctx, user); err != nil {
+				t.Fatal(err)
+			}
+			return user, primitive.NilObjectID, nil
+		}},
+		{model.UserStateSearching, func(t *testing.T, s *testService) (primitive.ObjectID, primitive.ObjectID, *model.Link) {
+			return s.searchingUser(t, 0, 0), primitive.NilObjectID, nil
+		}},
+		{model.UserStateLinked, func(t *testing.T, s *testService) (primitive.ObjectID, primitive.ObjectID, *model.Link) {
+			return s.linkedPair(t)
+		}},
+		{model.UserStateInChat, func(t *testing.T, s *testService) (primitive.ObjectID, primitive.ObjectID, *model.Link) {
+			userA, userB, link := s.linkedPair(t)
+			if err := s.RespondToLink(ctx, userA, link.ID, true); err != nil {
+				t.Fatal(err)
+			}
+			return userA, userB, link
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.state), func(t *testing.T) {
+			s := newTestService(t)
+			user, partner, link := tt.setup(t, s)
+			if got := s.user(t, user).State; got != tt.state {
+				t.Fatalf("set up a user who is %s, want %s", got, tt.state)
+			}
+
+			if err := s.SuspendUser(ctx, user); err != nil {
+				t.Fatalf("SuspendUser() error = %v", err)
+			}
+			// Suspending takes the user off their link, and leaves the partner on it
+			s.wantState(t, model.UserStateSuspended, primitive.NilObjectID, user)
+			if link != nil {
+				s.wantState(t, tt.state, link.ID, partner)
+			}
+
+			if err := s.SuspendUser(ctx, user); !errors.Is(err, ErrInvalidTransition) {
+				t.Errorf("second SuspendUser() error = %v, want ErrInvalidTransition", err)
+			}
+		})
+	}
+}
+
+func TestSuspendedUserStaysSuspended(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	user := s.searchingUser(t, 0, 0)
+	other := s.searchingUser(t, 0.001, 0)
+	if err := s.SuspendUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only ReinstateUser ends a suspension
+	moves := map[string]func() error{
+		"StartSearching": func() error { return s.StartSearching(ctx, user) },
+		"StopSearching":  func() error { return s.StopSearching(ctx, user) },
+		"FindMatch": func() error {
+			_, err := s.FindMatch(ctx, user)
+			return err
+		},
+	}
+	for name, move := range moves {
+		if err := move(); !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("%s() for a suspended user error = %v, want ErrInvalidTransition", name, err)
+		}
+	}
+	s.wantState(t, model.UserStateSuspended, primitive.NilObjectID, user)
+
+	// Nobody gets matched with them either
+	if _, err := s.FindMatch(ctx, other); !errors.Is(err, ErrNoMatchFound) {
+		t.Errorf("FindMatch() next to a suspended user error = %v, want ErrNoMatchFound", err)
+	}
+}
+
+func TestSuspendedPartnerIsReleased(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	userA, userB, link := s.linkedPair(t)
+	if err := s.SuspendUser(ctx, userA); err != nil {
+		t.Fatal(err)
+	}
+
+	// The partner goes back to searching when the link expires, and the
+	// suspended user stays put
+	if err := s.expireLink(ctx, link.ID); err != nil {
+		t.Fatalf("expireLink() error = %v", err)
+	}
+	s.wantState(t, model.UserStateSearching, primitive.NilObjectID, userB)
+	s.wantState(t, model.UserStateSuspended, primitive.NilObjectID, userA)
+}
+
+func TestReinstateUser(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	user := s.searchingUser(t, 0, 0)
+	if err := s.ReinstateUser(ctx, user); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("ReinstateUser() for a user who isn't suspended error = %v, want ErrInvalidTransition", err)
+	}
+	s.wantState(t, model.UserStateSearching, primitive.NilObjectID, user)
+
+	if err := s.SuspendUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReinstateUser(ctx, user); err != nil {
+		t.Fatalf("ReinstateUser() error = %v", err)
+	}
+	s.wantState(t, model.UserStateIdle, primitive.NilObjectID, user)
+
+	if err := s.ReinstateUser(ctx, user); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("second ReinstateUser() error = %v, want ErrInvalidTransition", err)
+	}
+	// Reinstated users can search again
+	if err := s.StartSearching(ctx, user); err != nil {
+		t.Errorf("StartSearching() after reinstating error = %v", err)
+	}
+}
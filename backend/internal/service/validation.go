@@ -0,0 +1,108 @@
+package service
+
+import (
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	MaxUsernameLength = 50
+	MinPasswordLength = 8
+	// bcrypt ignores everything past 72 bytes
+	MaxPasswordLength = 72
+)
+
+// ValidationError lists the fields of a request that aren't acceptable,
+// with a reason for each.
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	fields := make([]string, 0, len(e.Fields))
+	for field := range e.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return "invalid " + strings.Join(fields, ", ")
+}
+
+// fieldErrors collects validation failures, keeping the first per field.
+type fieldErrors map[string]string
+
+func (f fieldErrors) add(field, reason string) {
+	if _, ok := f[field]; !ok {
+		f[field] = reason
+	}
+}
+
+func (f fieldErrors) err() error {
+	if len(f) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: f}
+}
+
+// NormalizeEmail trims and lowercases an email so each address maps to one
+// account however it's typed.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizeUsername trims a username and puts it in Unicode NFKC form, so
+// names that look the same but are encoded differently can't coexist.
+func NormalizeUsername(username string) string {
+	return norm.NFKC.String(strings.TrimSpace(username))
+}
+
+func validateCoordinates(latitude, longitude float64) error {
+	errs := fieldErrors{}
+	if latitude < -90 || latitude > 90 {
+		errs.add("latitude", "must be between -90 and 90")
+	}
+	if longitude < -180 || longitude > 180 {
+		errs.add("longitude", "must be between -180 and 180")
+	}
+	return errs.err()
+}
+
+func validateUsername(errs fieldErrors, username string) {
+	switch {
+	case username == "":
+		errs.add("username", "is required")
+	case utf8.RuneCountInString(username) > MaxUsernameLength:
+		errs.add("username", "is too long")
+	}
+}
+
+func validateEmail(errs fieldErrors, email string) {
+	if email == "" {
+		errs.add("email", "is required")
+		return
+	}
+
+	// ParseAddress also accepts display names and comments, and domains
+	// without a dot, none of which belong in a signup form
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		errs.add("email", "is not a valid email address")
+		return
+	}
+	if domain := email[strings.LastIndex(email, "@")+1:]; !strings.Contains(domain, ".") {
+		errs.add("email", "is not a valid email address")
+	}
+}
+
+func validatePassword(errs fieldErrors, password string) {
+	switch {
+	case utf8.RuneCountInString(password) < MinPasswordLength:
+		errs.add("password", fmt.Sprintf("must be at least %d characters", MinPasswordLength))
+	case len(password) > MaxPasswordLength:
+		errs.add("password", fmt.Sprintf("must be at most %d bytes", MaxPasswordLength))
+	}
+}
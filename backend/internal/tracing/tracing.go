@@ -0,0 +1,95 @@
+// Package tracing sets up OpenTelemetry tracing for the API. Spans come from
+// the router, the service layer, the repository stores and the MongoDB
+// driver, and go to an OTLP collector or, for local use, stdout.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// ServiceName names the API in traces.
+const ServiceName = "linkapp-api"
+
+// Exporters Options.Exporter can name.
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// Options says where spans go and how many of them are kept.
+type Options struct {
+	// Exporter is none, otlp or stdout
+	Exporter string
+	// Endpoint is the OTLP/HTTP collector address, such as localhost:4318.
+	// Empty leaves it to the exporter's default and the standard
+	// OTEL_EXPORTER_OTLP_* variables, which also configure TLS.
+	Endpoint string
+	// SampleRatio is the share of new traces recorded, from 0 to 1. Requests
+	// that arrive as part of a sampled trace are always recorded.
+	SampleRatio float64
+}
+
+var DefaultOptions = Options{
+	Exporter:    ExporterNone,
+	SampleRatio: 1,
+}
+
+// Setup installs the global tracer provider and propagator. The returned
+// function flushes buffered spans and should run before the process exits.
+// With the none exporter, tracing stays the default no-op.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+	switch opts.Exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		var clientOpts []otlptracehttp.Option
+		if opts.Endpoint != "" {
+			clientOpts = append(clientOpts, otlptracehttp.WithEndpoint(opts.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, clientOpts...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", opts.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
@@ -7,8 +7,12 @@ import (
 	_ "github.com/lib/pq"
 )
 
-func NewPostgresConnection(host, port, user, password, dbname string) (*sql.DB, error) {
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+// PostgresConnString is the lib/pq connection string for the database.
+func PostgresConnString(host, port, user, password, dbname string) string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		host, port, user, password, dbname)
-	return sql.Open("postgres", connStr)
+}
+
+func NewPostgresConnection(host, port, user, password, dbname string) (*sql.DB, error) {
+	return sql.Open("postgres", PostgresConnString(host, port, user, password, dbname))
 }
@@ -3,11 +3,24 @@ import { View, Text, StyleSheet, ActivityIndicator } from 'react-native';
 import { useDispatch, useSelector } from 'react-redux';
 import { AppDispatch, RootState } from '../store/store';
 import { findMatch } from '../store/slices/matchSlice';
+import LocationService from '../services/LocationService';
 
 const MatchingScreen: React.FC = () => {
   const dispatch = useDispatch<AppDispatch>();
   const [isSearching, setIsSearching] = useState(true);
   const currentMatch = useSelector((state: RootState) => state.match.currentMatch);
+  const token = useSelector((state: RootState) => state.auth.token);
+  const userId = useSelector((state: RootState) => state.auth.user?.id);
+
+  // Keep our location fresh while searching so the backend keeps matching us
+  useEffect(() => {
+    if (!isSearching || !token || !userId) {
+      return;
+    }
+
+    LocationService.startHeartbeat(token, userId);
+    return () => LocationService.stopHeartbeat();
+  }, [isSearching, token, userId]);
 
   useEffect(() => {
     const searchForMatch = async () => {
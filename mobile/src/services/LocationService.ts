@@ -0,0 +1,39 @@
+import * as Location from 'expo-location';
+import { updateLocation } from './api';
+
+// The backend stops matching users whose location is older than 15 minutes,
+// so ping well inside that window while searching.
+const HEARTBEAT_INTERVAL_MS = 60 * 1000;
+
+class LocationService {
+  private static heartbeat: ReturnType<typeof setInterval> | null = null;
+
+  static async requestPermission(): Promise<boolean> {
+    const { status } = await Location.requestForegroundPermissionsAsync();
+    return status === 'granted';
+  }
+
+  static async sendLocation(token: string, userId: string) {
+    try {
+      const position = await Location.getCurrentPositionAsync({});
+      await updateLocation(token, userId, position.coords.latitude, position.coords.longitude);
+    } catch (error) {
+      console.error('Error sending location:', error);
+    }
+  }
+
+  static startHeartbeat(token: string, userId: string) {
+    this.stopHeartbeat();
+    this.sendLocation(token, userId);
+    this.heartbeat = setInterval(() => this.sendLocation(token, userId), HEARTBEAT_INTERVAL_MS);
+  }
+
+  static stopHeartbeat() {
+    if (this.heartbeat) {
+      clearInterval(this.heartbeat);
+      this.heartbeat = null;
+    }
+  }
+}
+
+export default LocationService;
@@ -9,6 +9,11 @@ const api = axios.create({
   },
 });
 
+// Error responses carry a stable `code` (e.g. "too_far", "chatroom_closed")
+// to branch on, alongside a human-readable `message`.
+export const getErrorCode = (error: any): string | undefined =>
+  error?.response?.data?.code;
+
 export const login = async (email: string, password: string) => {
   const response = await api.post('/login', { email, password });
   return response.data;
@@ -47,6 +52,13 @@ export const sendMessage = async (token: string, recipientId: string, content: s
   return response.data;
 };
 
+export const updateLocation = async (token: string, userId: string, latitude: number, longitude: number) => {
+  const response = await api.put(`/users/${userId}/location`, { latitude, longitude }, {
+    headers: { Authorization: `Bearer ${token}` }
+  });
+  return response.data;
+};
+
 export const startSearching = async (token: string) => {
   const response = await api.post('/users/start-searching', {}, {
     headers: { Authorization: `Bearer ${token}` }
@@ -75,15 +87,22 @@ export const respondToLink = async (token: string, linkId: string, accept: boole
   return response.data;
 };
 
-export const unlockChatroom = async (token: string, chatroomId: string) => {
-  const response = await api.post(`/chatrooms/${chatroomId}/unlock`, {}, {
+export const verifyNFCAndUnlockChatroom = async (token: string, chatroomId: string) => {
+  const response = await api.post(`/users/chatrooms/${chatroomId}/nfc-unlock`, {}, {
     headers: { Authorization: `Bearer ${token}` }
   });
   return response.data;
 };
 
-export const verifyNFCAndUnlockChatroom = async (token: string, chatroomId: string) => {
-  const response = await api.post(`/users/chatrooms/${chatroomId}/nfc-unlock`, {}, {
+export const issueUnlockToken = async (token: string, chatroomId: string) => {
+  const response = await api.post(`/users/chatrooms/${chatroomId}/qr-unlock/token`, {}, {
+    headers: { Authorization: `Bearer ${token}` }
+  });
+  return response.data;
+};
+
+export const redeemUnlockToken = async (token: string, chatroomId: string, unlockToken: string) => {
+  const response = await api.post(`/users/chatrooms/${chatroomId}/qr-unlock`, { token: unlockToken }, {
     headers: { Authorization: `Bearer ${token}` }
   });
   return response.data;